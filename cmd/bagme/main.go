@@ -0,0 +1,276 @@
+// Command bagme converts an HTML file with optional CSS stylesheets to PDF.
+//
+// Usage:
+//
+//	bagme [flags] input.html
+//
+// Run bagme -h for the list of flags.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boxesandglue/bagme/document"
+)
+
+// Exit codes.
+const (
+	exitOK     = 0
+	exitRender = 1 // reading input, rendering or writing the PDF failed
+	exitUsage  = 2 // invalid command line
+)
+
+// stringList collects the values of a flag that may be given several times.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+type options struct {
+	output         string
+	css            stringList
+	fontDirs       stringList
+	attach         stringList
+	pdfua          bool
+	pdfua2         bool
+	pdfa3b         bool
+	pdfx3          bool
+	pdfx4          bool
+	zugferd        string
+	zugferdProfile string
+	creationDate   string
+	pageTemplate   string
+	firstTemplate  string
+	outline        bool
+	htmlMetadata   bool
+
+	title    string
+	author   string
+	keywords string
+	creator  string
+	subject  string
+	lang     string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+// run executes the command with the given arguments and returns the exit
+// code. Diagnostics are written to stderr.
+func run(args []string, stderr io.Writer) int {
+	var o options
+	fs := flag.NewFlagSet("bagme", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: bagme [flags] input.html")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.output, "o", "", "output PDF `file` (default: input name with .pdf extension)")
+	fs.Var(&o.css, "css", "CSS `file` to load before rendering (repeatable)")
+	fs.Var(&o.fontDirs, "fontdir", "load the .ttf and .otf fonts in `directory` (repeatable)")
+	fs.Var(&o.attach, "attach", "embed `file` in the PDF (repeatable)")
+	fs.BoolVar(&o.pdfua, "pdfua", false, "create PDF/UA-1 (tagged, accessible) output")
+	fs.BoolVar(&o.pdfua2, "pdfua2", false, "create PDF/UA-2 output on PDF 2.0")
+	fs.BoolVar(&o.pdfa3b, "pdfa3b", false, "create PDF/A-3b output")
+	fs.BoolVar(&o.pdfx3, "pdfx3", false, "create PDF/X-3 output")
+	fs.BoolVar(&o.pdfx4, "pdfx4", false, "create PDF/X-4 output")
+	fs.StringVar(&o.zugferd, "zugferd", "", "embed the Factur-X, ZUGFeRD or XRechnung invoice XML `file`, the standard is detected from the XML (implies PDF/A-3b)")
+	fs.StringVar(&o.zugferdProfile, "zugferd-profile", "EN 16931", "Factur-X conformance `level` used with -zugferd")
+	fs.StringVar(&o.creationDate, "creation-date", "", "fixed creation `date` (RFC 3339 or Unix seconds) for reproducible output")
+	fs.StringVar(&o.pageTemplate, "page-template", "", "draw the first page of the PDF `file` behind every page (letterhead, stationery)")
+	fs.StringVar(&o.firstTemplate, "first-page-template", "", "draw the first page of the PDF `file` behind the first page instead of -page-template")
+	fs.BoolVar(&o.outline, "outline", true, "generate PDF bookmarks from headings")
+	fs.BoolVar(&o.htmlMetadata, "html-metadata", false, "take missing metadata from <title>, <meta> and <html lang>")
+	fs.StringVar(&o.title, "title", "", "document title")
+	fs.StringVar(&o.author, "author", "", "document author")
+	fs.StringVar(&o.keywords, "keywords", "", "comma separated document keywords")
+	fs.StringVar(&o.creator, "creator", "bagme", "creating application")
+	fs.StringVar(&o.subject, "subject", "", "document subject")
+	fs.StringVar(&o.lang, "lang", "", "document language as BCP 47 `tag` (e.g. en, de)")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if o.pdfua && o.pdfua2 {
+		fmt.Fprintln(stderr, "bagme: -pdfua and -pdfua2 are mutually exclusive")
+		return exitUsage
+	}
+	if o.pdfx3 && o.pdfx4 {
+		fmt.Fprintln(stderr, "bagme: -pdfx3 and -pdfx4 are mutually exclusive")
+		return exitUsage
+	}
+	input := fs.Arg(0)
+	if o.output == "" {
+		o.output = strings.TrimSuffix(input, filepath.Ext(input)) + ".pdf"
+	}
+	opts, err := o.documentOptions()
+	if err != nil {
+		fmt.Fprintln(stderr, "bagme:", err)
+		return exitUsage
+	}
+	if err := convert(input, &o, opts); err != nil {
+		fmt.Fprintln(stderr, "bagme:", err)
+		return exitRender
+	}
+	return exitOK
+}
+
+// documentOptions translates the command line flags to document options.
+// Files referenced by -attach and -zugferd are read here.
+func (o *options) documentOptions() ([]document.Option, error) {
+	var opts []document.Option
+	if o.pdfua {
+		opts = append(opts, document.WithPDFUA())
+	}
+	if o.pdfua2 {
+		opts = append(opts, document.WithPDFUA2())
+	}
+	if o.pdfa3b {
+		opts = append(opts, document.WithPDFA3b())
+	}
+	if o.pdfx3 {
+		opts = append(opts, document.WithPDFX3())
+	}
+	if o.pdfx4 {
+		opts = append(opts, document.WithPDFX4())
+	}
+	if o.htmlMetadata {
+		opts = append(opts, document.WithHTMLMetadata())
+	}
+	if o.creationDate != "" {
+		t, err := parseDate(o.creationDate)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, document.WithCreationDate(t))
+	}
+	if o.pageTemplate != "" {
+		opts = append(opts, document.WithPageTemplate(o.pageTemplate, 1))
+	}
+	if o.firstTemplate != "" {
+		opts = append(opts, document.WithFirstPageTemplate(o.firstTemplate, 1))
+	}
+	for _, fn := range o.attach {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		mimetype := mime.TypeByExtension(filepath.Ext(fn))
+		if mimetype == "" {
+			mimetype = "application/octet-stream"
+		}
+		// mime.TypeByExtension may add parameters such as charset.
+		mimetype, _, _ = strings.Cut(mimetype, ";")
+		opts = append(opts, document.WithAttachment(document.Attachment{
+			Name:     filepath.Base(fn),
+			MimeType: mimetype,
+			Data:     data,
+		}))
+	}
+	if o.zugferd != "" {
+		data, err := os.ReadFile(o.zugferd)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, document.WithZUGFeRD(data, o.zugferdProfile))
+	}
+	return opts, nil
+}
+
+// parseDate accepts an RFC 3339 timestamp or Unix seconds.
+func parseDate(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid creation date %q: want RFC 3339 or Unix seconds", s)
+	}
+	return t, nil
+}
+
+// convert renders the HTML file input to the PDF file o.output. The PDF is
+// written to a temporary file next to the output, which replaces the output
+// only if rendering succeeds.
+func convert(input string, o *options, opts []document.Option) error {
+	html, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(o.output), "."+filepath.Base(o.output)+"-*")
+	if err != nil {
+		return err
+	}
+	if err := render(f, string(html), filepath.Dir(input), o, opts); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), o.output); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// render writes the PDF of html to w. Relative references in html are
+// resolved against dir.
+func render(w io.Writer, html, dir string, o *options, opts []document.Option) error {
+	opts = append([]document.Option{document.WithBaseDir(dir)}, opts...)
+	d, err := document.NewWriter(w, opts...)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	d.Title = o.title
+	d.Author = o.author
+	d.Keywords = o.keywords
+	d.Creator = o.creator
+	d.Subject = o.subject
+	d.Language = o.lang
+	d.SetGenerateOutline(o.outline)
+	for _, dir := range o.fontDirs {
+		if err := d.LoadFontsFromDir(dir); err != nil {
+			return err
+		}
+	}
+	for _, fn := range o.css {
+		if err := d.ReadCSSFile(fn); err != nil {
+			return err
+		}
+	}
+	if err := d.RenderPages(html); err != nil {
+		return err
+	}
+	return d.Finish()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	imagepng "image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no input", nil},
+		{"two inputs", []string{"a.html", "b.html"}},
+		{"unknown flag", []string{"-nosuchflag", "a.html"}},
+		{"pdfua and pdfua2", []string{"-pdfua", "-pdfua2", "a.html"}},
+		{"pdfx3 and pdfx4", []string{"-pdfx3", "-pdfx4", "a.html"}},
+		{"bad date", []string{"-creation-date", "yesterday", "a.html"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			if code := run(tt.args, &stderr); code != exitUsage {
+				t.Errorf("expected exit code %d, got %d (%s)", exitUsage, code, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunMissingInput(t *testing.T) {
+	var stderr bytes.Buffer
+	dir := t.TempDir()
+	code := run([]string{"-o", filepath.Join(dir, "out.pdf"), filepath.Join(dir, "missing.html")}, &stderr)
+	if code != exitRender {
+		t.Errorf("expected exit code %d, got %d", exitRender, code)
+	}
+	if stderr.Len() == 0 {
+		t.Error("expected an error message on stderr")
+	}
+}
+
+func TestRunConvert(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.html")
+	css := filepath.Join(dir, "style.css")
+	if err := os.WriteFile(input, []byte("<h1>Hello</h1><p>World</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(css, []byte("body { font-size: 12pt; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	code := run([]string{"-css", css, "-title", "Hello", "-lang", "en", "-outline=false", "-creation-date", "1700000000", input}, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d (%s)", exitOK, code, stderr.String())
+	}
+	info, err := os.Stat(filepath.Join(dir, "in.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 {
+		t.Fatal("PDF file is empty")
+	}
+}
+
+func TestRunFailureKeepsOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.html")
+	output := filepath.Join(dir, "out.pdf")
+	if err := os.WriteFile(input, []byte("<p>Hello</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	code := run([]string{"-o", output, "-page-template", filepath.Join(dir, "missing.pdf"), input}, &stderr)
+	if code != exitRender {
+		t.Fatalf("expected exit code %d, got %d (%s)", exitRender, code, stderr.String())
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != "old" {
+		t.Errorf("expected the old output to be kept, got %q, %v", data, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected no temporary files to be left, got %v", entries)
+	}
+}
+
+func TestRunRelativeImage(t *testing.T) {
+	dir := t.TempDir()
+	png := filepath.Join(dir, "img", "dot.png")
+	if err := os.MkdirAll(filepath.Dir(png), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imagepng.Encode(f, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "in.html")
+	if err := os.WriteFile(input, []byte(`<p><img src="img/dot.png" width="10" height="10"></p>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	var stderr bytes.Buffer
+	if code := run([]string{input}, &stderr); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d (%s)", exitOK, code, stderr.String())
+	}
+}
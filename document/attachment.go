@@ -0,0 +1,130 @@
+package document
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/boxesandglue/bagme/internal/pdfread"
+)
+
+// ExtractAttachments returns the files embedded in the PDF read from r,
+// for example the factur-x.xml of an e-invoice. Files are found in the
+// EmbeddedFiles name tree of the document and in its associated files (AF
+// array); each file is returned once, in the order of the name tree.
+// Encrypted PDFs are not supported.
+func ExtractAttachments(r io.Reader) ([]Attachment, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := pdfread.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := f.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	var specs []any
+	if names, ok := f.Resolve(catalog["Names"]).(pdfread.Dict); ok {
+		specs = nameTreeValues(f, names["EmbeddedFiles"], 0)
+	}
+	if af, ok := f.Resolve(catalog["AF"]).(pdfread.Array); ok {
+		specs = append(specs, af...)
+	}
+	var attachments []Attachment
+	seen := make(map[pdfread.Ref]bool)
+	for _, spec := range specs {
+		if ref, ok := spec.(pdfread.Ref); ok {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+		}
+		fs, ok := f.Resolve(spec).(pdfread.Dict)
+		if !ok {
+			continue
+		}
+		a, err := fileSpecAttachment(f, fs)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// ExtractAttachmentsFile returns the files embedded in the PDF file at the
+// given path. See ExtractAttachments.
+func ExtractAttachmentsFile(filename string) ([]Attachment, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return ExtractAttachments(fd)
+}
+
+// nameTreeValues returns the values of the name tree node in key order.
+func nameTreeValues(f *pdfread.File, node any, depth int) []any {
+	n, ok := f.Resolve(node).(pdfread.Dict)
+	if !ok || depth > 32 {
+		return nil
+	}
+	var values []any
+	if names, ok := f.Resolve(n["Names"]).(pdfread.Array); ok {
+		for i := 1; i < len(names); i += 2 {
+			values = append(values, names[i])
+		}
+	}
+	if kids, ok := f.Resolve(n["Kids"]).(pdfread.Array); ok {
+		for _, kid := range kids {
+			values = append(values, nameTreeValues(f, kid, depth+1)...)
+		}
+	}
+	return values
+}
+
+// fileSpecAttachment reads the embedded file of a file specification.
+func fileSpecAttachment(f *pdfread.File, fs pdfread.Dict) (Attachment, error) {
+	var a Attachment
+	for _, key := range []pdfread.Name{"UF", "F"} {
+		if s, ok := f.Resolve(fs[key]).(string); ok && s != "" {
+			a.Name = pdfread.TextString(s)
+			break
+		}
+	}
+	if s, ok := f.Resolve(fs["Desc"]).(string); ok {
+		a.Description = pdfread.TextString(s)
+	}
+	if rel, ok := f.Resolve(fs["AFRelationship"]).(pdfread.Name); ok {
+		a.AFRelationship = string(rel)
+	}
+	ef, ok := f.Resolve(fs["EF"]).(pdfread.Dict)
+	if !ok {
+		return a, nil
+	}
+	var stream pdfread.Stream
+	for _, key := range []pdfread.Name{"UF", "F"} {
+		if stream, ok = f.Resolve(ef[key]).(pdfread.Stream); ok {
+			break
+		}
+	}
+	if !ok {
+		return a, nil
+	}
+	if mime, ok := f.Resolve(stream.Dict["Subtype"]).(pdfread.Name); ok {
+		a.MimeType = string(mime)
+	}
+	// The length may be an indirect object.
+	if n, ok := f.Resolve(stream.Dict["Length"]).(int); ok && n <= len(stream.Raw) {
+		stream.Raw = stream.Raw[:n]
+	}
+	data, err := stream.Decode()
+	if err != nil {
+		return a, fmt.Errorf("attachment %q: %w", a.Name, err)
+	}
+	a.Data = data
+	return a, nil
+}
@@ -0,0 +1,102 @@
+package document
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// attachmentPDF has one file in the EmbeddedFiles name tree (behind a kid
+// node) that is also listed in the catalog's AF array, and one file that is
+// only an associated file.
+const attachmentPDF = `%PDF-1.7
+1 0 obj
+<< /Type /Catalog /Names << /EmbeddedFiles 2 0 R >> /AF [4 0 R 6 0 R] >>
+endobj
+2 0 obj
+<< /Kids [3 0 R] >>
+endobj
+3 0 obj
+<< /Limits [(invoice.xml) (invoice.xml)] /Names [(invoice.xml) 4 0 R] >>
+endobj
+4 0 obj
+<< /Type /Filespec /F (invoice.xml) /UF <FEFF0069006E0076006F006900630065002E0078006D006C> /Desc (Invoice data) /AFRelationship /Alternative /EF << /F 5 0 R /UF 5 0 R >> >>
+endobj
+5 0 obj
+<< /Type /EmbeddedFile /Subtype /text#2Fxml /Length 9 0 R >>
+stream
+<invoice/>
+endstream
+endobj
+6 0 obj
+<< /Type /Filespec /F (source.txt) /AFRelationship /Source /EF << /F 7 0 R >> >>
+endobj
+7 0 obj
+<< /Type /EmbeddedFile /Filter /ASCIIHexDecode /Length 8 >>
+stream
+6869210A>
+endstream
+endobj
+9 0 obj
+10
+endobj
+trailer
+<< /Root 1 0 R /Size 10 >>
+%%EOF
+`
+
+func TestExtractAttachments(t *testing.T) {
+	attachments, err := ExtractAttachments(bytes.NewReader([]byte(attachmentPDF)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Attachment{
+		{Name: "invoice.xml", Description: "Invoice data", MimeType: "text/xml", AFRelationship: "Alternative", Data: []byte("<invoice/>")},
+		{Name: "source.txt", AFRelationship: "Source", Data: []byte("hi!\n")},
+	}
+	if len(attachments) != len(want) {
+		t.Fatalf("expected %d attachments, got %d", len(want), len(attachments))
+	}
+	for i, w := range want {
+		a := attachments[i]
+		if a.Name != w.Name || a.Description != w.Description || a.MimeType != w.MimeType || a.AFRelationship != w.AFRelationship {
+			t.Errorf("attachment %d: got %q %q %q %q, want %q %q %q %q", i,
+				a.Name, a.Description, a.MimeType, a.AFRelationship,
+				w.Name, w.Description, w.MimeType, w.AFRelationship)
+		}
+		if !bytes.Equal(a.Data, w.Data) {
+			t.Errorf("attachment %d: got data %q, want %q", i, a.Data, w.Data)
+		}
+	}
+}
+
+func TestExtractAttachmentsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "attachments.pdf")
+	if err := os.WriteFile(filename, []byte(attachmentPDF), 0644); err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := ExtractAttachmentsFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 {
+		t.Errorf("expected 2 attachments, got %d", len(attachments))
+	}
+	if _, err := ExtractAttachmentsFile(filepath.Join(t.TempDir(), "missing.pdf")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestExtractAttachmentsNone(t *testing.T) {
+	attachments, err := ExtractAttachments(bytes.NewReader([]byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog >>\nendobj\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 0 {
+		t.Errorf("expected no attachments, got %d", len(attachments))
+	}
+	if _, err := ExtractAttachments(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("expected error for non-PDF data")
+	}
+}
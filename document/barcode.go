@@ -0,0 +1,306 @@
+package document
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/speedata/barcode"
+	"github.com/speedata/barcode/code128"
+	"github.com/speedata/barcode/datamatrix"
+	"github.com/speedata/barcode/ean"
+	"github.com/speedata/barcode/qr"
+)
+
+// encodeBarcode encodes value as a barcode of the given kind: "qr",
+// "datamatrix", "code128", "ean13" or "ean8". The EAN check digit is
+// computed if it is missing.
+func encodeBarcode(kind, value string) (barcode.Barcode, error) {
+	var bc barcode.Barcode
+	var err error
+	switch strings.ToLower(kind) {
+	case "qr", "qrcode":
+		bc, err = qr.Encode(value, qr.M, qr.Auto)
+	case "datamatrix":
+		bc, err = datamatrix.Encode(value)
+	case "code128":
+		bc, err = code128.Encode(value)
+	case "ean13", "ean8", "ean":
+		if err := checkEAN(strings.ToLower(kind), value); err != nil {
+			return nil, fmt.Errorf("barcode %s %q: %w", kind, value, err)
+		}
+		bc, err = ean.Encode(value)
+	default:
+		return nil, fmt.Errorf("barcode: unknown type %q", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("barcode %s %q: %w", kind, value, err)
+	}
+	return bc, nil
+}
+
+// checkEAN checks that value has the length of the EAN kind, with or
+// without check digit, and that a given check digit is correct. "ean"
+// accepts both EAN-13 and EAN-8.
+func checkEAN(kind, value string) error {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("invalid character %q", c)
+		}
+	}
+	n := len(value)
+	switch {
+	case kind != "ean8" && (n == 12 || n == 13), kind != "ean13" && (n == 7 || n == 8):
+	case kind == "ean13":
+		return fmt.Errorf("expected 12 or 13 digits, got %d", n)
+	case kind == "ean8":
+		return fmt.Errorf("expected 7 or 8 digits, got %d", n)
+	default:
+		return fmt.Errorf("expected 7, 8, 12 or 13 digits, got %d", n)
+	}
+	if n == 8 || n == 13 {
+		if want := eanCheckDigit(value[:n-1]); value[n-1] != want {
+			return fmt.Errorf("wrong check digit %c, expected %c", value[n-1], want)
+		}
+	}
+	return nil
+}
+
+// eanCheckDigit returns the EAN check digit for the digits of data.
+func eanCheckDigit(data string) byte {
+	sum := 0
+	for i := len(data) - 1; i >= 0; i-- {
+		d := int(data[i] - '0')
+		if (len(data)-i)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// barcodeSVG returns an inline SVG element with the barcode of the given
+// kind for value. width and height are CSS lengths; an empty height makes
+// two-dimensional codes square and one-dimensional codes a quarter of their
+// width high. Two-dimensional codes include the quiet zone, for
+// one-dimensional codes leave room around the element.
+func barcodeSVG(kind, value, width, height string) (string, error) {
+	bc, err := encodeBarcode(kind, value)
+	if err != nil {
+		return "", err
+	}
+	if width == "" {
+		width = "3cm"
+	}
+	b := bc.Bounds()
+	quiet := 0
+	switch bc.Metadata().CodeKind {
+	case barcode.TypeQR:
+		quiet = 4
+	case barcode.TypeDataMatrix:
+		quiet = 1
+	}
+	twoD := bc.Metadata().Dimensions == 2
+	if height == "" {
+		height = width
+		if !twoD {
+			height = quarterLength(width)
+		}
+	}
+	aspect := ""
+	if !twoD {
+		aspect = ` preserveAspectRatio="none"`
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %d %d"%s><title>%s</title><path fill="black" d="%s"/></svg>`,
+		html.EscapeString(width), html.EscapeString(height), b.Dx()+2*quiet, b.Dy()+2*quiet, aspect, html.EscapeString(value), barcodePath(bc, quiet)), nil
+}
+
+// barcodePath returns SVG path data that draws the dark modules of bc as
+// unit squares, offset by quiet modules.
+func barcodePath(bc barcode.Barcode, quiet int) string {
+	b := bc.Bounds()
+	var path strings.Builder
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; {
+			if !isDark(bc, x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < b.Max.X && isDark(bc, x, y) {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start-b.Min.X+quiet, y-b.Min.Y+quiet, x-start, x-start)
+		}
+	}
+	return path.String()
+}
+
+// isDark reports whether the module at x, y of bc is black.
+func isDark(bc barcode.Barcode, x, y int) bool {
+	r, _, _, _ := bc.At(x, y).RGBA()
+	return r < 0x8000
+}
+
+// quarterLength returns a quarter of the CSS length l, which is a number
+// followed by a unit, or l itself if it cannot be parsed.
+func quarterLength(l string) string {
+	l = strings.TrimSpace(l)
+	i := strings.IndexFunc(l, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 {
+		return l
+	}
+	var n float64
+	if _, err := fmt.Sscan(l[:i], &n); err != nil {
+		return l
+	}
+	return fmt.Sprintf("%g%s", n/4, l[i:])
+}
+
+// setBarcodeAlt replaces the title of the barcode svg, which is the
+// encoded value, with the alternative text alt. An empty alt marks the
+// barcode as decorative: the title is removed and the svg hidden from
+// assistive technology.
+func setBarcodeAlt(svg *html.Node, alt string) {
+	for c := svg.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "title" {
+			continue
+		}
+		if alt == "" {
+			svg.RemoveChild(c)
+			svg.Attr = append(svg.Attr, html.Attribute{Key: "aria-hidden", Val: "true"})
+			return
+		}
+		for c.FirstChild != nil {
+			c.RemoveChild(c.FirstChild)
+		}
+		c.AppendChild(&html.Node{Type: html.TextNode, Data: alt})
+		return
+	}
+}
+
+// expandBarcodes replaces <barcode type="…" value="…"> elements and images
+// with a barcode:type:value source in src by inline SVG. If fragment is
+// true, src is an HTML fragment as passed to OutputAt. The width and height
+// attributes give the size as CSS lengths, numbers are pixels; class, id and
+// style are kept. An alt attribute becomes the title of the svg instead of
+// the encoded value.
+func expandBarcodes(src string, fragment bool) (string, error) {
+	if !strings.Contains(strings.ToLower(src), "barcode") {
+		return src, nil
+	}
+	var nodes []*html.Node
+	if fragment {
+		body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		ns, err := html.ParseFragment(strings.NewReader(src), body)
+		if err != nil {
+			return "", err
+		}
+		nodes = ns
+	} else {
+		doc, err := html.Parse(strings.NewReader(src))
+		if err != nil {
+			return "", err
+		}
+		nodes = []*html.Node{doc}
+	}
+	var barcodes []*html.Node
+	for _, n := range nodes {
+		walkElements(n, func(e *html.Node) {
+			if e.Namespace != "" {
+				return
+			}
+			if e.Data == "barcode" || e.DataAtom == atom.Img && isBarcodeURL(attr(e, "src")) {
+				barcodes = append(barcodes, e)
+			}
+		})
+	}
+	if len(barcodes) == 0 {
+		return src, nil
+	}
+	for _, n := range barcodes {
+		kind, value := attr(n, "type"), attr(n, "value")
+		if n.DataAtom == atom.Img {
+			kind, value = parseBarcodeURL(attr(n, "src"))
+		}
+		svg, err := barcodeSVG(kind, value, cssSize(attr(n, "width")), cssSize(attr(n, "height")))
+		if err != nil {
+			return "", err
+		}
+		parent := n.Parent
+		if parent == nil {
+			parent = &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		}
+		ns, err := html.ParseFragment(strings.NewReader(svg), parent)
+		if err != nil {
+			return "", err
+		}
+		for _, key := range []string{"class", "id", "style"} {
+			if v := attr(n, key); v != "" {
+				ns[0].Attr = append(ns[0].Attr, html.Attribute{Key: key, Val: v})
+			}
+		}
+		if hasAttr(n, "alt") {
+			setBarcodeAlt(ns[0], attr(n, "alt"))
+		}
+		// <barcode/> is not a void element, so the parser may have put the
+		// content that follows it inside.
+		var moved []*html.Node
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			moved = append(moved, c)
+		}
+		for _, c := range moved {
+			n.RemoveChild(c)
+		}
+		replacement := append(ns, moved...)
+		if n.Parent == nil {
+			// A top level node of a fragment.
+			for j, top := range nodes {
+				if top == n {
+					nodes = append(nodes[:j], append(replacement, nodes[j+1:]...)...)
+					break
+				}
+			}
+			continue
+		}
+		for _, r := range replacement {
+			n.Parent.InsertBefore(r, n)
+		}
+		n.Parent.RemoveChild(n)
+	}
+	var sb strings.Builder
+	for _, n := range nodes {
+		if err := html.Render(&sb, n); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// isBarcodeURL reports whether ref is a barcode:type:value image source.
+func isBarcodeURL(ref string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(ref)), "barcode:")
+}
+
+// parseBarcodeURL returns the type and the value of a barcode:type:value
+// image source. The value is percent decoded if it is a valid escape
+// sequence, so that it can contain any character.
+func parseBarcodeURL(ref string) (string, string) {
+	kind, value, _ := strings.Cut(strings.TrimSpace(ref)[len("barcode:"):], ":")
+	if v, err := url.PathUnescape(value); err == nil {
+		value = v
+	}
+	return kind, value
+}
+
+// cssSize returns the width or height attribute s as a CSS length.
+func cssSize(s string) string {
+	s = strings.TrimSpace(s)
+	if s != "" && strings.Trim(s, "0123456789.") == "" {
+		return s + "px"
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBarcodeSVG(t *testing.T) {
+	tests := []struct {
+		kind, value, width, height string
+		want                       []string
+	}{
+		{"qr", "hello", "", "", []string{`width="3cm" height="3cm"`, `viewBox="0 0 29 29"`}},
+		{"ean13", "400638133393", "4cm", "", []string{`width="4cm" height="1cm"`, `viewBox="0 0 95 1"`, `preserveAspectRatio="none"`, "<title>400638133393</title>"}},
+		{"code128", "ABC-123", "5cm", "2cm", []string{`width="5cm" height="2cm"`, `preserveAspectRatio="none"`}},
+		{"datamatrix", "x", "1cm", "", []string{`width="1cm" height="1cm"`}},
+	}
+	for _, tt := range tests {
+		svg, err := barcodeSVG(tt.kind, tt.value, tt.width, tt.height)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.kind, err)
+			continue
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(svg, w) {
+				t.Errorf("%s: expected %q in %s", tt.kind, w, svg)
+			}
+		}
+	}
+	if _, err := barcodeSVG("pdf417", "x", "", ""); err == nil {
+		t.Error("expected error for unknown barcode type")
+	}
+	if _, err := barcodeSVG("ean13", "abc", "", ""); err == nil {
+		t.Error("expected error for invalid EAN")
+	}
+}
+
+func TestCheckEAN(t *testing.T) {
+	tests := []struct {
+		kind, value string
+		ok          bool
+	}{
+		{"ean13", "400638133393", true},
+		{"ean13", "4006381333931", true},
+		{"ean13", "4006381333932", false},
+		{"ean13", "96385074", false},
+		{"ean13", "40063813339", false},
+		{"ean13", "40063813339a", false},
+		{"ean8", "9638507", true},
+		{"ean8", "96385074", true},
+		{"ean8", "96385075", false},
+		{"ean8", "400638133393", false},
+		{"ean", "96385074", true},
+		{"ean", "4006381333931", true},
+		{"ean", "123456", false},
+		{"ean", "", false},
+	}
+	for _, tt := range tests {
+		if err := checkEAN(tt.kind, tt.value); (err == nil) != tt.ok {
+			t.Errorf("%s %q: got %v", tt.kind, tt.value, err)
+		}
+	}
+	if _, err := barcodeSVG("ean13", "4006381333932", "", ""); err == nil || !strings.Contains(err.Error(), "check digit") {
+		t.Errorf("expected a check digit error, got %v", err)
+	}
+}
+
+func TestBarcodeSVGModules(t *testing.T) {
+	// The first bar of an EAN-13 code is the start guard 101.
+	svg, err := barcodeSVG("ean13", "400638133393", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(svg, `d="M0 0h1v1h-1zM2 0h1v1h-1z`) {
+		t.Errorf("unexpected path in %s", svg)
+	}
+}
+
+func TestQuarterLength(t *testing.T) {
+	for in, want := range map[string]string{"4cm": "1cm", "10mm": "2.5mm", "auto": "auto", "3": "3"} {
+		if got := quarterLength(in); got != want {
+			t.Errorf("%s: expected %s, got %s", in, want, got)
+		}
+	}
+}
+
+func TestExpandBarcodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		fragment bool
+		want     []string
+		notWant  []string
+	}{
+		{"element", `<p><barcode type="qr" value="hi" width="2cm" class="code"></barcode></p>`, true,
+			[]string{`<p><svg xmlns="http://www.w3.org/2000/svg" width="2cm" height="2cm"`, `class="code"`, "<title>hi</title>"}, []string{"<barcode"}},
+		{"self closing element keeps following content", `<div><barcode type="code128" value="A1"/><p>after</p></div>`, true,
+			[]string{`</svg><p>after</p></div>`}, []string{"<barcode"}},
+		{"top level element", `<barcode type="datamatrix" value="x"></barcode><p>x</p>`, true,
+			[]string{`<svg`, `</svg><p>x</p>`}, nil},
+		{"image", `<html><body><img src="barcode:ean13:400638133393" width="100" style="margin: 1mm"></body></html>`, false,
+			[]string{`width="100px" height="25px"`, `style="margin: 1mm"`}, []string{"<img"}},
+		{"percent encoded value", `<img src="barcode:qr:a%3Ab%20c">`, true,
+			[]string{"<title>a:b c</title>"}, nil},
+		{"alt text", `<img src="barcode:qr:https%3A%2F%2Fexample.com%2Fpay" alt="Payment link">`, true,
+			[]string{"<title>Payment link</title>"}, []string{"<title>https"}},
+		{"element alt text", `<barcode type="ean13" value="400638133393" alt="EAN 4006381333931"></barcode>`, true,
+			[]string{"<title>EAN 4006381333931</title>"}, nil},
+		{"empty alt text", `<img src="barcode:qr:x" alt="">`, true,
+			[]string{`aria-hidden="true"`}, []string{"<title>"}},
+		{"other images unchanged", `<img src="logo.png"><p>barcode</p>`, true,
+			[]string{`<img src="logo.png"><p>barcode</p>`}, []string{"<svg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandBarcodes(tt.src, tt.fragment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("expected %q in %s", w, got)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(got, w) {
+					t.Errorf("unexpected %q in %s", w, got)
+				}
+			}
+		})
+	}
+	if _, err := expandBarcodes(`<barcode type="ean13" value="abc"></barcode>`, true); err == nil {
+		t.Error("expected error for invalid EAN")
+	}
+}
+
+func TestRenderBarcodes(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<p><barcode type="qr" value="https://example.com"></barcode></p><p><img src="barcode:code128:SHIP-42"></p>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,326 @@
+package document
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// cssBlock is a node of a parsed stylesheet: a style rule, an at-rule with a
+// block (@page, @media, @font-face, …) or, if statement is set, an at-rule
+// without a block such as @import. The parser only understands the
+// structure of CSS; values are kept as written.
+type cssBlock struct {
+	prelude   string // selector list or at-rule including its name
+	line      int    // 1-based line of the prelude
+	statement bool   // at-rule terminated by a semicolon
+	decls     []cssDecl
+	children  []*cssBlock
+}
+
+// cssDecl is a single declaration.
+type cssDecl struct {
+	property string // lower case
+	value    string
+	line     int
+}
+
+// clone returns a deep copy of b.
+func (b *cssBlock) clone() *cssBlock {
+	c := *b
+	c.decls = slices.Clone(b.decls)
+	c.children = cloneBlocks(b.children)
+	return &c
+}
+
+// cloneBlocks returns a deep copy of blocks.
+func cloneBlocks(blocks []*cssBlock) []*cssBlock {
+	if blocks == nil {
+		return nil
+	}
+	c := make([]*cssBlock, len(blocks))
+	for i, b := range blocks {
+		c[i] = b.clone()
+	}
+	return c
+}
+
+// atRule returns the lower case at-rule name without the @ ("page",
+// "media", …) or the empty string for style rules.
+func (b *cssBlock) atRule() string {
+	if !strings.HasPrefix(b.prelude, "@") {
+		return ""
+	}
+	name := b.prelude[1:]
+	if i := strings.IndexAny(name, " \t\r\n({:"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// get returns the value of the last declaration of property.
+func (b *cssBlock) get(property string) (string, bool) {
+	for i := len(b.decls) - 1; i >= 0; i-- {
+		if b.decls[i].property == property {
+			return b.decls[i].value, true
+		}
+	}
+	return "", false
+}
+
+// parseCSS parses a stylesheet into its top level blocks.
+func parseCSS(src string) []*cssBlock {
+	p := cssParser{src: src, line: 1}
+	var root cssBlock
+	p.block(&root)
+	return root.children
+}
+
+type cssParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// block reads declarations and nested blocks into b until the closing brace
+// of b or the end of the input.
+func (p *cssParser) block(b *cssBlock) {
+	var buf strings.Builder
+	startLine := 0
+	parens := 0
+	flush := func() {
+		text := strings.TrimSpace(buf.String())
+		buf.Reset()
+		line := startLine
+		startLine = 0
+		if text == "" {
+			return
+		}
+		if strings.HasPrefix(text, "@") {
+			b.children = append(b.children, &cssBlock{prelude: text, line: line, statement: true})
+			return
+		}
+		prop, val, ok := strings.Cut(text, ":")
+		if !ok {
+			return
+		}
+		b.decls = append(b.decls, cssDecl{
+			property: strings.ToLower(strings.TrimSpace(prop)),
+			value:    strings.TrimSpace(val),
+			line:     line,
+		})
+	}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if startLine == 0 && !isCSSSpace(c) && c != '/' {
+			startLine = p.line
+		}
+		switch {
+		case c == '/' && strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				end = len(p.src) - p.pos - 2
+			}
+			comment := p.src[p.pos : p.pos+2+end]
+			p.line += strings.Count(comment, "\n")
+			p.pos += 2 + end + 2
+			buf.WriteByte(' ')
+			continue
+		case c == '"' || c == '\'':
+			start := p.pos
+			p.pos++
+			for p.pos < len(p.src) && p.src[p.pos] != c {
+				if p.src[p.pos] == '\\' {
+					p.pos++
+				}
+				if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+					p.line++
+				}
+				p.pos++
+			}
+			p.pos++
+			if p.pos > len(p.src) {
+				p.pos = len(p.src)
+			}
+			buf.WriteString(p.src[start:p.pos])
+			continue
+		case c == '(':
+			parens++
+		case c == ')':
+			if parens > 0 {
+				parens--
+			}
+		case c == '{' && parens == 0:
+			child := &cssBlock{prelude: strings.TrimSpace(buf.String()), line: startLine}
+			buf.Reset()
+			startLine = 0
+			p.pos++
+			p.block(child)
+			b.children = append(b.children, child)
+			continue
+		case c == ';' && parens == 0:
+			flush()
+			p.pos++
+			continue
+		case c == '}' && parens == 0:
+			flush()
+			p.pos++
+			return
+		case c == '\n':
+			p.line++
+		}
+		buf.WriteByte(c)
+		p.pos++
+	}
+	flush()
+}
+
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// writeCSS serializes blocks back to CSS.
+func writeCSS(blocks []*cssBlock) string {
+	var sb strings.Builder
+	for _, b := range blocks {
+		b.write(&sb, "")
+	}
+	return sb.String()
+}
+
+func (b *cssBlock) write(sb *strings.Builder, indent string) {
+	sb.WriteString(indent)
+	sb.WriteString(b.prelude)
+	if b.statement {
+		sb.WriteString(";\n")
+		return
+	}
+	sb.WriteString(" {\n")
+	for _, d := range b.decls {
+		sb.WriteString(indent + "  " + d.property + ": " + d.value + ";\n")
+	}
+	for _, c := range b.children {
+		c.write(sb, indent+"  ")
+	}
+	sb.WriteString(indent + "}\n")
+}
+
+// rewriteBlockURLs applies rewriteURLs to all declarations and at-rule
+// statements such as @import.
+func rewriteBlockURLs(blocks []*cssBlock, dir string) {
+	for _, b := range blocks {
+		if b.statement {
+			b.prelude = rewriteURLs(b.prelude, dir)
+		}
+		for i := range b.decls {
+			b.decls[i].value = rewriteURLs(b.decls[i].value, dir)
+		}
+		rewriteBlockURLs(b.children, dir)
+	}
+}
+
+// rewriteURLs makes every relative url() in value relative to dir, so the
+// stylesheet can be used independently of the location it was read from.
+// Absolute paths, data: URIs and URLs with a scheme are left alone.
+func rewriteURLs(value, dir string) string {
+	if dir == "" {
+		return value
+	}
+	value, _ = mapURLs(value, func(ref string) (string, error) {
+		if !isAbsoluteURL(ref) {
+			ref = joinURL(dir, ref)
+		}
+		return ref, nil
+	})
+	return value
+}
+
+// joinURL resolves the relative reference ref against dir, which is a
+// directory path or a URL such as https://example.com/css.
+func joinURL(dir, ref string) string {
+	if hasScheme(dir) {
+		base, err := url.Parse(strings.TrimSuffix(dir, "/") + "/")
+		if err == nil {
+			if r, err := url.Parse(ref); err == nil {
+				return base.ResolveReference(r).String()
+			}
+		}
+	}
+	return filepath.ToSlash(filepath.Join(dir, ref))
+}
+
+// urlDir returns the directory part of a path or URL.
+func urlDir(ref string) string {
+	if hasScheme(ref) {
+		if i := strings.IndexAny(ref, "?#"); i >= 0 {
+			ref = ref[:i]
+		}
+		if i := strings.LastIndexByte(ref, '/'); i > strings.Index(ref, "//")+1 {
+			return ref[:i]
+		}
+		return ref
+	}
+	return path.Dir(filepath.ToSlash(ref))
+}
+
+// hasScheme reports whether ref is a URL with a scheme, as opposed to a
+// path.
+func hasScheme(ref string) bool {
+	return ref != "" && isAbsoluteURL(ref) && !strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "#") && !filepath.IsAbs(ref)
+}
+
+// mapURLs replaces the argument of every url() in value with the result of
+// fn. Quotes around the argument are kept.
+func mapURLs(value string, fn func(ref string) (string, error)) (string, error) {
+	if !strings.Contains(value, "url(") {
+		return value, nil
+	}
+	var sb strings.Builder
+	rest := value
+	for {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			sb.WriteString(rest)
+			break
+		}
+		sb.WriteString(rest[:i+4])
+		rest = rest[i+4:]
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			sb.WriteString(rest)
+			break
+		}
+		arg := strings.TrimSpace(rest[:end])
+		quote := ""
+		if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+			quote = arg[:1]
+			arg = arg[1 : len(arg)-1]
+		}
+		arg, err := fn(arg)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(quote + arg + quote + ")")
+		rest = rest[end+1:]
+	}
+	return sb.String(), nil
+}
+
+// isAbsoluteURL reports whether ref is an absolute path, a data: URI or a
+// URL with a scheme such as https: or file:.
+func isAbsoluteURL(ref string) bool {
+	if ref == "" || strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "#") || filepath.IsAbs(ref) {
+		return true
+	}
+	if i := strings.IndexByte(ref, ':'); i > 1 {
+		for _, r := range ref[:i] {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.') {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCSS(t *testing.T) {
+	src := `/* comment; with { braces } */
+@import url("base.css");
+h1, h2 { color: red; font: 12pt/1.4 serif }
+@media print {
+  p { margin: 0 }
+}
+@page :first {
+  margin: 2cm;
+  @top-center { content: "a;b{c}" }
+}
+img { background: url(data:image/png;base64,AAAA) }
+`
+	blocks := parseCSS(src)
+	if len(blocks) != 5 {
+		t.Fatalf("expected 5 blocks, got %d", len(blocks))
+	}
+	if !blocks[0].statement || blocks[0].atRule() != "import" {
+		t.Errorf("expected @import statement, got %+v", blocks[0])
+	}
+	h := blocks[1]
+	if h.prelude != "h1, h2" || h.line != 3 {
+		t.Errorf("unexpected rule %q on line %d", h.prelude, h.line)
+	}
+	if v, _ := h.get("font"); v != "12pt/1.4 serif" {
+		t.Errorf("unexpected font value %q", v)
+	}
+	if blocks[2].atRule() != "media" || len(blocks[2].children) != 1 {
+		t.Errorf("expected @media with one rule, got %+v", blocks[2])
+	}
+	page := blocks[3]
+	if page.atRule() != "page" || len(page.decls) != 1 || len(page.children) != 1 {
+		t.Fatalf("unexpected @page block %+v", page)
+	}
+	if v, _ := page.children[0].get("content"); v != `"a;b{c}"` {
+		t.Errorf("unexpected margin box content %q", v)
+	}
+	if v, _ := blocks[4].get("background"); v != "url(data:image/png;base64,AAAA)" {
+		t.Errorf("unexpected background %q", v)
+	}
+	// Serializing and parsing again must not change the structure.
+	again := parseCSS(writeCSS(blocks))
+	if writeCSS(again) != writeCSS(blocks) {
+		t.Errorf("round trip changed the stylesheet:\n%s", writeCSS(again))
+	}
+}
+
+func TestRewriteURLs(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`url(img/a.png)`, `url(css/img/a.png)`},
+		{`url("b.png") no-repeat`, `url("css/b.png") no-repeat`},
+		{`url('/abs/c.png')`, `url('/abs/c.png')`},
+		{`url(data:image/png;base64,AAAA)`, `url(data:image/png;base64,AAAA)`},
+		{`url(https://example.com/d.png)`, `url(https://example.com/d.png)`},
+		{`red`, `red`},
+	}
+	for _, tt := range tests {
+		if got := rewriteURLs(tt.in, "css"); got != tt.want {
+			t.Errorf("rewriteURLs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := writeCSS(parseCSS(`@import "x.css"; p { color: red }`)); !strings.Contains(got, "@import \"x.css\";") {
+		t.Errorf("unexpected serialization %q", got)
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		dir, ref, want string
+	}{
+		{"css", "img/a.png", "css/img/a.png"},
+		{"css/parts", "../a.png", "css/a.png"},
+		{".", "a.png", "a.png"},
+		{"https://example.com/css", "../img/a.png", "https://example.com/img/a.png"},
+		{"https://example.com/css/", "a.png", "https://example.com/css/a.png"},
+	}
+	for _, tt := range tests {
+		if got := joinURL(tt.dir, tt.ref); got != tt.want {
+			t.Errorf("joinURL(%q, %q) = %q, want %q", tt.dir, tt.ref, got, tt.want)
+		}
+	}
+	for ref, want := range map[string]string{
+		"main.css":                        ".",
+		"css/parts/base.css":              "css/parts",
+		"https://example.com/css/a.css?v": "https://example.com/css",
+		"https://example.com":             "https://example.com",
+	} {
+		if got := urlDir(ref); got != want {
+			t.Errorf("urlDir(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
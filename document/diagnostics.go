@@ -0,0 +1,352 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Severity classifies a Diagnostic.
+type Severity int
+
+const (
+	// SeverityInfo marks input that is ignored without changing the layout,
+	// for example vendor prefixed or screen-only CSS properties.
+	SeverityInfo Severity = iota
+	// SeverityWarning marks input that the renderer does not support or
+	// replaces, so the output may differ from a browser.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "info"
+}
+
+// Diagnostic describes a problem found in the CSS or HTML of a document.
+type Diagnostic struct {
+	Severity Severity
+	// Message is a human readable description of the problem.
+	Message string
+	// Selector and Property locate a problem in CSS. Selector is the
+	// selector or at-rule of the enclosing rule.
+	Selector string
+	Property string
+	// Element is the path of the HTML element, such as "body > div > p".
+	Element string
+	// Source is the file name of the stylesheet. It is empty for CSS added
+	// with AddCSS and for HTML, including its <style> elements.
+	Source string
+	// Line is the line in the stylesheet or, if Source is empty, in the
+	// HTML or the CSS added with AddCSS. It is 0 if unknown, for example for
+	// diagnostics of the table of contents, which is generated from the
+	// parsed HTML.
+	Line int
+}
+
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	if d.Source != "" {
+		sb.WriteString(d.Source + ":")
+	}
+	if d.Line > 0 {
+		fmt.Fprintf(&sb, "%d:", d.Line)
+	}
+	if sb.Len() > 0 {
+		sb.WriteString(" ")
+	}
+	sb.WriteString(d.Severity.String() + ": ")
+	switch {
+	case d.Element != "":
+		sb.WriteString(d.Element + ": ")
+	case d.Selector != "" && d.Property != "":
+		sb.WriteString(d.Selector + " { " + d.Property + " }: ")
+	case d.Selector != "":
+		sb.WriteString(d.Selector + ": ")
+	}
+	sb.WriteString(d.Message)
+	return sb.String()
+}
+
+// DiagnosticsError is returned in strict mode when CSS or HTML produces
+// warnings. It lists the warnings of the failing call.
+type DiagnosticsError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *DiagnosticsError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+	return fmt.Sprintf("%d warning(s): %s", len(e.Diagnostics), strings.Join(msgs, "; "))
+}
+
+// WithStrict turns warnings into errors: AddCSS, ReadCSSFile, RenderPages,
+// OutputAt and OutputAtPage return a *DiagnosticsError instead of
+// rendering input that produces a diagnostic of SeverityWarning. Use this
+// for CI builds of templates.
+func WithStrict() Option {
+	return func(c *config) { c.strict = true }
+}
+
+// Warnings returns the diagnostics collected by AddCSS, ReadCSSFile,
+// RenderPages, OutputAt and OutputAtPage so far, including those of
+// SeverityInfo. Each problem is reported once.
+func (d *Document) Warnings() []Diagnostic {
+	return d.diagnostics
+}
+
+// report records the diagnostics that have not been reported before. In
+// strict mode it returns a *DiagnosticsError if one of them is a warning.
+func (d *Document) report(diags []Diagnostic) error {
+	var warnings []Diagnostic
+	for _, diag := range diags {
+		if d.reported == nil {
+			d.reported = make(map[Diagnostic]bool)
+		}
+		if d.reported[diag] {
+			continue
+		}
+		d.reported[diag] = true
+		d.diagnostics = append(d.diagnostics, diag)
+		if diag.Severity == SeverityWarning {
+			warnings = append(warnings, diag)
+		}
+	}
+	if d.cfg.strict && len(warnings) > 0 {
+		return &DiagnosticsError{Diagnostics: warnings}
+	}
+	return nil
+}
+
+// unsupportedProperties lists CSS properties that the renderer ignores. The
+// severity tells whether ignoring them changes the layout.
+var unsupportedProperties = map[string]Severity{
+	"float":          SeverityWarning,
+	"clear":          SeverityWarning,
+	"flex":           SeverityWarning,
+	"grid":           SeverityWarning,
+	"transform":      SeverityWarning,
+	"box-shadow":     SeverityWarning,
+	"text-shadow":    SeverityWarning,
+	"filter":         SeverityWarning,
+	"z-index":        SeverityWarning,
+	"transition":     SeverityInfo,
+	"animation":      SeverityInfo,
+	"cursor":         SeverityInfo,
+	"pointer-events": SeverityInfo,
+	"user-select":    SeverityInfo,
+}
+
+// propertyDiagnostic returns the diagnostic for an unsupported property or
+// value, or ok == false if the declaration is supported.
+func propertyDiagnostic(property, value string) (Diagnostic, bool) {
+	property = strings.ToLower(property)
+	if strings.HasPrefix(property, "-") && !strings.HasPrefix(property, "--") && !strings.HasPrefix(property, "-bag-") {
+		return Diagnostic{Severity: SeverityInfo, Property: property, Message: "vendor prefixed property is ignored"}, true
+	}
+	name := property
+	for _, prefix := range []string{"flex-", "grid-", "transition-", "animation-"} {
+		if strings.HasPrefix(property, prefix) {
+			name = strings.TrimSuffix(prefix, "-")
+		}
+	}
+	if sev, ok := unsupportedProperties[name]; ok {
+		return Diagnostic{Severity: sev, Property: property, Message: "property is not supported"}, true
+	}
+	if property == "display" {
+		switch v := strings.ToLower(strings.TrimSpace(value)); v {
+		case "flex", "inline-flex", "grid", "inline-grid":
+			return Diagnostic{Severity: SeverityWarning, Property: property, Message: fmt.Sprintf("display: %s is not supported", v)}, true
+		}
+	}
+	return Diagnostic{}, false
+}
+
+// ignoredAtRules are at-rules that the renderer drops with their content.
+// Animations have no effect in print, so @keyframes is only noted.
+var ignoredAtRules = map[string]Severity{
+	"keyframes": SeverityInfo, "supports": SeverityWarning, "container": SeverityWarning,
+	"layer": SeverityWarning, "document": SeverityWarning,
+}
+
+// checkCSS returns the diagnostics for unsupported properties and at-rules
+// in blocks. source is the file name of the stylesheet.
+func checkCSS(blocks []*cssBlock, source string) []Diagnostic {
+	var diags []Diagnostic
+	for _, b := range blocks {
+		rule := b.atRule()
+		if strings.HasSuffix(rule, "-keyframes") {
+			rule = "keyframes"
+		}
+		if sev, ok := ignoredAtRules[rule]; ok {
+			diags = append(diags, Diagnostic{Severity: sev, Selector: "@" + b.atRule(), Source: source, Line: b.line, Message: "at-rule is not supported"})
+			continue
+		}
+		if rule == "font-face" {
+			continue
+		}
+		for _, decl := range b.decls {
+			if diag, ok := propertyDiagnostic(decl.property, decl.value); ok {
+				diag.Selector, diag.Source, diag.Line = b.prelude, source, decl.line
+				diags = append(diags, diag)
+			}
+		}
+		diags = append(diags, checkCSS(b.children, source)...)
+	}
+	return diags
+}
+
+// unsupportedElements lists HTML elements whose content is not rendered.
+var unsupportedElements = map[string]bool{
+	"iframe": true, "video": true, "audio": true, "canvas": true, "object": true, "embed": true,
+	"input": true, "select": true, "textarea": true, "button": true,
+}
+
+// voidElements have no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// checkHTML returns the diagnostics for unsupported elements, images
+// without source and unsupported CSS in style attributes of src. Element
+// paths are built from the tags in src and do not contain elements that the
+// parser would add, such as a missing tbody.
+func checkHTML(src string) ([]Diagnostic, error) {
+	var diags []Diagnostic
+	var stack []string
+	line := 1
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if errors.Is(z.Err(), io.EOF) {
+				return diags, nil
+			}
+			return nil, z.Err()
+		}
+		tokenLine := line
+		line += strings.Count(string(z.Raw()), "\n")
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			name := tok.Data
+			path := strings.Join(append(stack, name), " > ")
+			if unsupportedElements[name] {
+				diags = append(diags, Diagnostic{Severity: SeverityWarning, Element: path, Line: tokenLine, Message: fmt.Sprintf("<%s> is not supported", name)})
+			}
+			if name == "img" && strings.TrimSpace(tokenAttr(tok, "src")) == "" {
+				diags = append(diags, Diagnostic{Severity: SeverityWarning, Element: path, Line: tokenLine, Message: "image without source"})
+			}
+			if style := tokenAttr(tok, "style"); style != "" {
+				for _, b := range parseCSS("x {" + style + "}") {
+					for _, decl := range b.decls {
+						if diag, ok := propertyDiagnostic(decl.property, decl.value); ok {
+							diag.Element, diag.Line = path, tokenLine
+							diags = append(diags, diag)
+						}
+					}
+				}
+			}
+			if tt == html.StartTagToken && !voidElements[name] {
+				stack = append(stack, name)
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == string(name) {
+					stack = stack[:i]
+					break
+				}
+			}
+		}
+	}
+}
+
+// tokenAttr returns the value of the attribute key of tok.
+func tokenAttr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// genericFontFamilies are the CSS generic families, which always resolve
+// to a font.
+var genericFontFamilies = map[string]bool{
+	"serif": true, "sans-serif": true, "sans": true, "monospace": true,
+	"cursive": true, "fantasy": true, "system-ui": true,
+}
+
+// fontDecl is a font-family declaration of a stylesheet, checked by
+// checkFonts when a document is rendered.
+type fontDecl struct {
+	family   string // first family of the declaration
+	selector string
+	source   string
+	line     int
+}
+
+// fontDecls returns the font-family declarations of blocks. source is the
+// file name of the stylesheet.
+func fontDecls(blocks []*cssBlock, source string) []fontDecl {
+	var decls []fontDecl
+	for _, b := range blocks {
+		if b.atRule() == "font-face" {
+			continue
+		}
+		for _, decl := range b.decls {
+			if decl.property != "font-family" {
+				continue
+			}
+			decls = append(decls, fontDecl{
+				family:   unquoteCSS(splitCSSList(decl.value)[0]),
+				selector: b.prelude,
+				source:   source,
+				line:     decl.line,
+			})
+		}
+		decls = append(decls, fontDecls(b.children, source)...)
+	}
+	return decls
+}
+
+// checkFonts returns a warning for every font-family declaration in the
+// stylesheets of d whose first family is not available, so that the
+// renderer falls back to another font.
+func (d *Document) checkFonts() []Diagnostic {
+	var diags []Diagnostic
+	for _, f := range d.fontDecls {
+		if f.family == "" || genericFontFamilies[strings.ToLower(f.family)] || d.Frontend.FindFontFamily(f.family) != nil {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning,
+			Selector: f.selector,
+			Property: "font-family",
+			Source:   f.source,
+			Line:     f.line,
+			Message:  fmt.Sprintf("font family %q is not available, the renderer falls back to another font", f.family),
+		})
+	}
+	return diags
+}
+
+// checkDocument reports the diagnostics of the HTML src and of the fonts in
+// the stylesheets before src is rendered.
+func (d *Document) checkDocument(src string) error {
+	diags, err := checkHTML(src)
+	if err != nil {
+		return err
+	}
+	return d.report(append(d.checkFonts(), diags...))
+}
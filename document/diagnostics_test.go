@@ -0,0 +1,200 @@
+package document
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCSS(t *testing.T) {
+	css := `p { color: red; float: left }
+@media print {
+  div {
+    display: flex;
+    -webkit-print-color-adjust: exact;
+    --accent: blue;
+    -bag-font-expansion: 10%;
+  }
+}
+@keyframes spin { from { opacity: 0 } }
+@supports (display: grid) { p { color: blue } }
+@font-face { font-family: X; src: url(x.woff) }
+`
+	diags := checkCSS(parseCSS(css), "style.css")
+	want := []Diagnostic{
+		{Severity: SeverityWarning, Selector: "p", Property: "float", Source: "style.css", Line: 1, Message: "property is not supported"},
+		{Severity: SeverityWarning, Selector: "div", Property: "display", Source: "style.css", Line: 4, Message: "display: flex is not supported"},
+		{Severity: SeverityInfo, Selector: "div", Property: "-webkit-print-color-adjust", Source: "style.css", Line: 5, Message: "vendor prefixed property is ignored"},
+		{Severity: SeverityInfo, Selector: "@keyframes", Source: "style.css", Line: 10, Message: "at-rule is not supported"},
+		{Severity: SeverityWarning, Selector: "@supports", Source: "style.css", Line: 11, Message: "at-rule is not supported"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(want), len(diags), diags)
+	}
+	for i := range want {
+		if diags[i] != want[i] {
+			t.Errorf("diagnostic %d: expected %v, got %v", i, want[i], diags[i])
+		}
+	}
+}
+
+func TestPropertyDiagnostic(t *testing.T) {
+	tests := []struct {
+		property, value string
+		want            bool
+		severity        Severity
+	}{
+		{"color", "red", false, 0},
+		{"display", "block", false, 0},
+		{"display", " Grid", true, SeverityWarning},
+		{"flex-direction", "row", true, SeverityWarning},
+		{"grid-template-columns", "1fr", true, SeverityWarning},
+		{"transition-duration", "1s", true, SeverityInfo},
+		{"Z-Index", "2", true, SeverityWarning},
+		{"-moz-box-sizing", "border-box", true, SeverityInfo},
+		{"--custom", "1", false, 0},
+	}
+	for _, tt := range tests {
+		diag, ok := propertyDiagnostic(tt.property, tt.value)
+		if ok != tt.want || ok && diag.Severity != tt.severity {
+			t.Errorf("%s: %s: expected %v/%v, got %v/%v", tt.property, tt.value, tt.want, tt.severity, ok, diag.Severity)
+		}
+	}
+}
+
+func TestCheckHTML(t *testing.T) {
+	src := `<html><body>
+<div class="box">
+  <p>Text<br>more</p>
+  <img src="">
+  <video src="a.mp4"></video>
+</div>
+<p style="box-shadow: 1px 1px black">x</p>
+</body></html>`
+	diags, err := checkHTML(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Diagnostic{
+		{Severity: SeverityWarning, Element: "html > body > div > img", Line: 4, Message: "image without source"},
+		{Severity: SeverityWarning, Element: "html > body > div > video", Line: 5, Message: "<video> is not supported"},
+		{Severity: SeverityWarning, Element: "html > body > p", Property: "box-shadow", Line: 7, Message: "property is not supported"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(want), len(diags), diags)
+	}
+	for i := range want {
+		if diags[i] != want[i] {
+			t.Errorf("diagnostic %d: expected %v, got %v", i, want[i], diags[i])
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	info := Diagnostic{Severity: SeverityInfo, Property: "-webkit-x", Message: "vendor prefixed property is ignored"}
+	warning := Diagnostic{Severity: SeverityWarning, Property: "float", Selector: "p", Message: "property is not supported"}
+
+	d := &Document{}
+	if err := d.report([]Diagnostic{info, warning, warning}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := d.Warnings(); len(got) != 2 {
+		t.Errorf("expected 2 diagnostics, got %v", got)
+	}
+
+	d = &Document{cfg: config{strict: true}}
+	if err := d.report([]Diagnostic{info}); err != nil {
+		t.Errorf("info must not fail in strict mode, got %v", err)
+	}
+	err := d.report([]Diagnostic{warning})
+	var de *DiagnosticsError
+	if !errors.As(err, &de) || len(de.Diagnostics) != 1 {
+		t.Fatalf("expected *DiagnosticsError with one warning, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "p { float }: property is not supported") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if err := d.report([]Diagnostic{warning}); err != nil {
+		t.Errorf("reported warnings must not fail again, got %v", err)
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	tests := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Diagnostic{Severity: SeverityWarning, Source: "a.css", Line: 3, Selector: "p", Property: "float", Message: "m"}, "a.css:3: warning: p { float }: m"},
+		{Diagnostic{Severity: SeverityWarning, Line: 2, Element: "body > video", Message: "m"}, "2: warning: body > video: m"},
+		{Diagnostic{Severity: SeverityInfo, Selector: "@keyframes", Message: "m"}, "info: @keyframes: m"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestStrictAddCSS(t *testing.T) {
+	d, err := New(tempPDF(t), WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Finish()
+	if err := d.AddCSS("p { color: red; -webkit-hyphens: auto }"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	var de *DiagnosticsError
+	if err := d.AddCSS("p {\n  float: left }"); !errors.As(err, &de) {
+		t.Fatalf("expected *DiagnosticsError, got %v", err)
+	}
+	if de.Diagnostics[0].Line != 2 {
+		t.Errorf("expected line 2, got %d", de.Diagnostics[0].Line)
+	}
+	if n := len(d.Warnings()); n != 2 {
+		t.Errorf("expected 2 diagnostics, got %d", n)
+	}
+}
+
+func TestCheckFontsLocation(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Finish()
+	filename := filepath.Join(t.TempDir(), "fonts.css")
+	if err := os.WriteFile(filename, []byte("body { font-family: sans-serif }\n\nh1 {\n  font-family: \"No Such Font\", serif }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReadCSSFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	diags := d.checkFonts()
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %+v", diags)
+	}
+	if got := diags[0]; got.Source != filename || got.Line != 4 || got.Selector != "h1" {
+		t.Errorf("unexpected location %+v", got)
+	}
+}
+
+func TestStyleElementLines(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Finish()
+	src := "<html>\n<head>\n<style>p { color: red }</style>\n<style>\n\nh1 {\n  float: left }\n</style>\n</head>\n<body><p>Text</p></body></html>"
+	if got := styleLines(src); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Errorf("unexpected style lines %v", got)
+	}
+	if _, err := d.addDocumentCSS(src); err != nil {
+		t.Fatal(err)
+	}
+	w := d.Warnings()
+	if len(w) != 1 || w[0].Line != 7 || w[0].Source != "" {
+		t.Errorf("expected a warning for line 7 of the HTML, got %+v", w)
+	}
+}
@@ -1,8 +1,14 @@
 package document
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"time"
 
@@ -21,10 +27,43 @@ type Attachment = document.Attachment
 type Option func(*config)
 
 type config struct {
-	format        document.Format
-	attachments   []document.Attachment
-	xmpExtensions []document.XMPExtension
-	creationDate  *time.Time
+	format            document.Format
+	attachments       []document.Attachment
+	xmpExtensions     []document.XMPExtension
+	creationDate      *time.Time
+	htmlMetadata      bool
+	baseDir           string // for relative URLs in AddCSS
+	baseFS            fs.FS  // for relative @font-face sources in AddCSS
+	loader            ResourceLoader
+	cache             *resourceCache // resources fetched by loader, shared by a Template
+	sandbox           bool
+	limits            limits
+	strict            bool
+	einvoice          *xmlInvoice // set by WithEInvoice
+	einvoiceTotal     string
+	pageTemplate      *pageTemplate // WithPageTemplate
+	firstPageTemplate *pageTemplate // WithFirstPageTemplate
+	err               error         // first error of an option, returned by New
+}
+
+// setErr records the first error of an option.
+func (c *config) setErr(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// applyOptions returns the configuration of opts or the first error of an
+// option.
+func applyOptions(opts []Option) (config, error) {
+	var cfg config
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.err != nil {
+		return cfg, cfg.err
+	}
+	return cfg, cfg.checkEInvoiceTotal()
 }
 
 // WithPDFUA enables PDF/UA-1 (ISO 14289-1, on PDF 1.7) output. All HTML
@@ -114,48 +153,23 @@ func formatPDFDate(t time.Time) string {
 }
 
 // WithZUGFeRD creates a ZUGFeRD/Factur-X compliant PDF. It sets the format to
-// PDF/A-3b, attaches the XML invoice data as "factur-x.xml", and adds the
-// required XMP extension schema metadata.
+// PDF/A-3b, attaches the XML invoice data, and adds the required XMP
+// extension schema metadata.
 //
 // The profile parameter specifies the Factur-X conformance level:
 // "MINIMUM", "BASIC WL", "BASIC", "EN 16931" (or "EN16931"), "EXTENDED", "XRECHNUNG".
+// The standard is detected from the XML: ZUGFeRD 1.0 and 2.0 invoices and
+// XRechnung are embedded as described for the EInvoiceStandard constants,
+// everything else as Factur-X ("factur-x.xml"). Use WithEInvoice to choose
+// the standard or the AFRelationship explicitly.
+//
+// New and NewWriter return an error wrapping ErrInvalidEInvoice if the
+// profile is unknown, if xmlData is not a well-formed invoice of the
+// standard or if its GuidelineSpecifiedDocumentContextParameter does not
+// belong to the profile. Use WithZUGFeRDTotal to check the invoice total as
+// well.
 func WithZUGFeRD(xmlData []byte, profile string) Option {
-	// Normalize common profile shortcuts to official XMP conformance level values.
-	switch profile {
-	case "EN16931", "en16931", "COMFORT", "comfort":
-		profile = "EN 16931"
-	case "BASICWL", "BASIC WL":
-		profile = "BASIC WL"
-	}
-	return func(c *config) {
-		// Additive: only the PDF/A sub-conformance is set. If the caller
-		// also passed WithPDFUA()/WithPDFUA2(), that PDFUA setting is
-		// preserved — the resulting PDF declares both standards.
-		c.format.PDFA = &document.PDFAConf{Part: 3, Level: document.PDFALevelB}
-		c.attachments = append(c.attachments, document.Attachment{
-			Name:        "factur-x.xml",
-			Description: "Factur-X/ZUGFeRD invoice",
-			MimeType:    "text/xml",
-			Data:        xmlData,
-		})
-		c.xmpExtensions = append(c.xmpExtensions, document.XMPExtension{
-			Schema:       "ZUGFeRD PDFA Extension Schema",
-			NamespaceURI: "urn:ferd:pdfa:CrossIndustryDocument:invoice:1p0#",
-			Prefix:       "zf",
-			Properties: []document.XMPExtensionProperty{
-				{Name: "DocumentFileName", ValueType: "Text", Category: "external", Description: "name of the embedded XML invoice file"},
-				{Name: "DocumentType", ValueType: "Text", Category: "external", Description: "INVOICE"},
-				{Name: "Version", ValueType: "Text", Category: "external", Description: "The actual version of the ZUGFeRD data"},
-				{Name: "ConformanceLevel", ValueType: "Text", Category: "external", Description: "The conformance level of the ZUGFeRD data"},
-			},
-			Values: map[string]string{
-				"ConformanceLevel": profile,
-				"DocumentFileName": "factur-x.xml",
-				"DocumentType":     "INVOICE",
-				"Version":          "1.0",
-			},
-		})
-	}
+	return WithEInvoice(EInvoice{XML: xmlData, Standard: detectEInvoiceStandard(xmlData, profile), Profile: profile})
 }
 
 // Document is the main starting point of the PDF generation.
@@ -169,7 +183,9 @@ type Document struct {
 	Frontend *frontend.Document
 
 	// PageInitCallback is called after each new page is initialized.
-	// Use this for running headers/footers or page-level decorations.
+	// Use this for page-level decorations; PageNumber returns the number
+	// of the page. For running headers and footers, margin boxes with
+	// string(), element() and counter(page) are usually simpler.
 	PageInitCallback func()
 
 	// ElementCallback is called after each block element is rendered.
@@ -177,7 +193,38 @@ type Document struct {
 	ElementCallback htmlbag.ElementCallbackFunc
 
 	cssbuilder    *htmlbag.CSSBuilder
-	pagesRendered bool // true after RenderPages has been called
+	pagesRendered bool      // true after RenderPages or InsertPDF has been called
+	w             io.Writer // destination for NewWriter, nil for files
+	cfg           config
+	css           *csshtml.CSS // of cssbuilder, holds the page master
+	sources       []string     // HTML passed to RenderPages and OutputAt
+	attachments   []Attachment // embedded files, for Preflight
+	stylesheets   []stylesheet // CSS passed to the builder, for layout passes
+	fontDecls     []fontDecl   // font-family declarations, for diagnostics
+	tocCSSAdded   bool
+	xrefs         []xrefRule        // target-counter() and target-text() rules
+	anchors       map[string]int    // element id → page number
+	fontFamilies  map[string]bool   // lower case names registered with AddFontFamily
+	fonts         []fontFamily      // registered font families, for layout passes
+	tempDir       string            // resources fetched by the resource loader
+	localFiles    map[string]string // resource URL → file in tempDir
+	tempFiles     int               // files created in tempDir
+	importing     map[string]bool   // @import URLs being processed
+	ctx           context.Context   // of the running RenderPagesContext or FinishContext
+	aborted       error             // set when rendering was cancelled or the document closed
+	finished      bool              // true after FinishContext
+	pageCount     int               // pages started by RenderPages or inserted by InsertPDF
+	manualPages   int               // pages started by NewPage
+	overlays      []overlay         // OutputAtPage content
+	lastPage      int               // last page of the running RenderPages, if needed by overlays
+	overlayPage   *document.Page    // page started by RenderPages that pageOverlays belong to
+	pageOverlays  []int             // indexes of the overlays drawn when overlayPage is shipped out
+	diagnostics   []Diagnostic      // returned by Warnings
+	reported      map[Diagnostic]bool
+	templatePage  *document.Page // last page checked for a page template
+	pages         pageStyles     // @page rules with selectors, page properties
+	pageName      string         // page name of the pages planPages did not plan
+	running       runningContent // named strings and running elements of RenderPages
 }
 
 // PageDimensions re-exports the htmlbag type for convenience.
@@ -187,18 +234,139 @@ type PageDimensions = htmlbag.PageDimensions
 type HeadingEntry = htmlbag.HeadingEntry
 
 // PageSize returns the dimensions of the current page (width, height, margins).
+// Use PageSizeFor for the dimensions of a page type.
 func (d *Document) PageSize() (PageDimensions, error) {
 	return d.cssbuilder.PageSize()
 }
 
-// ReadCSSFile parses the CSS file at the given path.
+// ReadCSSFile parses the CSS file at the given path. Relative URLs in the
+// file are resolved against the directory of the file.
 func (d *Document) ReadCSSFile(filename string) error {
-	return d.cssbuilder.ReadCSSFile(filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	css := string(data)
+	return d.addParsedCSS(parseCSS(css), stylesheet{css: css, dir: filepath.Dir(filename), name: filename})
 }
 
-// AddCSS reads CSS instructions from a string.
+// AddCSS reads CSS instructions from a string. Relative URLs are resolved
+// against the directory given with WithBaseDir or the file system given
+// with WithBaseFS.
 func (d *Document) AddCSS(css string) error {
-	return d.cssbuilder.AddCSS(css)
+	return d.addCSS(css, "")
+}
+
+// addCSS handles the parts of the stylesheet that bagme implements itself
+// and passes the rest to the CSS builder. Relative URLs are resolved against
+// dir unless it is empty.
+func (d *Document) addCSS(css, dir string) error {
+	return d.addParsedCSS(parseCSS(css), stylesheet{css: css, dir: dir})
+}
+
+// addParsedCSS is addCSS for a stylesheet that has already been parsed into
+// blocks. The blocks are modified.
+func (d *Document) addParsedCSS(blocks []*cssBlock, ss stylesheet) error {
+	p, err := prepareCSS(d.context(), &d.cfg, blocks, ss)
+	if err != nil {
+		return err
+	}
+	return d.applyCSS(p)
+}
+
+// preparedCSS is a stylesheet after bagme has taken out the parts it
+// implements itself. A Template prepares its stylesheets once and applies
+// them to every document; the fields are not modified by applyCSS.
+type preparedCSS struct {
+	diagnostics []Diagnostic
+	fontDecls   []fontDecl
+	families    []fontFamily // from @font-face rules, with the font data
+	xrefs       []xrefRule
+	pages       pageStyles
+	blocks      []*cssBlock // for the CSS builder, url() references not localized
+	css         string      // blocks written as CSS
+}
+
+// prepareCSS checks the stylesheet, resolves its @import rules (with a
+// resource loader or in sandbox mode), loads the fonts of its @font-face
+// rules, resolves its relative URLs and extracts the rules that bagme
+// implements itself. The blocks are modified.
+func prepareCSS(ctx context.Context, cfg *config, blocks []*cssBlock, ss stylesheet) (*preparedCSS, error) {
+	if ss.dir == "" {
+		ss.dir = cfg.baseDir
+	}
+	// importCSS, extractXrefs and extractPageRules record their state in a
+	// document.
+	scratch := &Document{cfg: *cfg, ctx: ctx}
+	p := &preparedCSS{
+		diagnostics: checkCSS(blocks, ss.name),
+		fontDecls:   fontDecls(blocks, ss.name),
+	}
+	var err error
+	if cfg.localResources() {
+		if blocks, err = scratch.importCSS(blocks, ss.dir, ""); err != nil {
+			return nil, err
+		}
+	}
+	if p.families, blocks, err = extractFontFaces(ctx, blocks, ss.dir, cfg); err != nil {
+		return nil, err
+	}
+	scratch.extractXrefs(blocks)
+	if ss.dir != "" {
+		rewriteBlockURLs(blocks, ss.dir)
+	}
+	p.blocks = scratch.extractPageRules(blocks)
+	p.xrefs = scratch.xrefs
+	p.pages = scratch.pages
+	p.css = writeCSS(p.blocks)
+	return p, nil
+}
+
+// applyCSS adds a prepared stylesheet to the document and passes the rest to
+// the CSS builder. url() references are localized first if the document
+// fetches its resources itself.
+func (d *Document) applyCSS(p *preparedCSS) error {
+	if err := d.report(p.diagnostics); err != nil {
+		return err
+	}
+	d.fontDecls = append(d.fontDecls, p.fontDecls...)
+	for _, ff := range p.families {
+		if err := d.registerFontFamily(ff); err != nil {
+			return err
+		}
+	}
+	d.xrefs = append(d.xrefs, p.xrefs...)
+	pages, css := p.pages, p.css
+	if d.cfg.localResources() {
+		blocks := cloneBlocks(p.blocks)
+		if err := d.localizeBlockURLs(blocks); err != nil {
+			return err
+		}
+		css = writeCSS(blocks)
+		pages.rules = slices.Clone(pages.rules)
+		for i, r := range pages.rules {
+			r.block = r.block.clone()
+			if err := d.localizeBlockURLs([]*cssBlock{r.block}); err != nil {
+				return err
+			}
+			pages.rules[i] = r
+		}
+	} else if max := d.cfg.limits.maxImageBytes; max > 0 {
+		if err := checkBlockImages(p.blocks, max); err != nil {
+			return err
+		}
+		for _, r := range pages.rules {
+			if err := checkBlockImages([]*cssBlock{r.block}, max); err != nil {
+				return err
+			}
+		}
+	}
+	d.pages.add(&pages)
+	if err := d.cssbuilder.AddCSS(css); err != nil {
+		return err
+	}
+	d.stylesheets = append(d.stylesheets, stylesheet{css: css})
+	return nil
 }
 
 // OutputAt renders an HTML fragment at an absolute position (x, y) on the
@@ -207,9 +375,29 @@ func (d *Document) AddCSS(css string) error {
 //
 // For full-page rendering with automatic page breaks, use RenderPages instead.
 func (d *Document) OutputAt(html string, width, x, y bag.ScaledPoint) error {
+	if d.aborted != nil {
+		return d.aborted
+	}
+	if err := d.checkInput(html, true); err != nil {
+		return err
+	}
+	if err := d.checkDocument(html); err != nil {
+		return err
+	}
+	html, err := expandBarcodes(html, true)
+	if err != nil {
+		return err
+	}
+	if html, err = d.prepareResources(html, true); err != nil {
+		return err
+	}
 	if err := d.cssbuilder.InitPage(); err != nil {
 		return err
 	}
+	if err := d.placePageTemplate(d.manualPages + 1); err != nil {
+		return err
+	}
+	d.sources = append(d.sources, html)
 	te, err := d.cssbuilder.HTMLToText(html)
 	if err != nil {
 		return err
@@ -227,10 +415,152 @@ func (d *Document) OutputAt(html string, width, x, y bag.ScaledPoint) error {
 // margins). Content is distributed across pages automatically. Forced page
 // breaks (page-break-before, page-break-after) are respected.
 //
+// @page rules may select pages with :first, :left, :right and :blank and
+// by name, for example @page chapter:first. Children of body choose a named
+// page with the page property (page: chapter); a change of the page name
+// starts a new page, which may have another size or orientation. A break
+// to a left or right page (break-before: right) inserts a blank page if
+// needed. Page numbers decide about left and right pages: the first page is
+// a right page. :first without a name selects the first page of the
+// document, with a name the first page of every run of these pages. Stylesheets
+// in <style> and <link rel="stylesheet"> elements of html are added after
+// the stylesheets given by AddCSS and ReadCSSFile, unless their media
+// attribute excludes print.
+//
+// Barcodes are drawn as vector graphics for <barcode type="qr"
+// value="…"> elements and for images with a source such as
+// "barcode:ean13:4006381333931". The types are qr, datamatrix, code128,
+// ean13 and ean8; the width and height attributes set the size. The same
+// applies to OutputAt and OutputAtPage.
+//
 // After calling RenderPages, call Finish to write the PDF.
-// Do not mix RenderPages and OutputAt in the same document.
+// Do not mix RenderPages and OutputAt in the same document; use
+// OutputAtPage to place content at fixed positions on the pages.
 func (d *Document) RenderPages(html string) error {
+	return d.RenderPagesContext(context.Background(), html)
+}
+
+// RenderPagesContext is RenderPages with a context. Cancellation is checked
+// before every page and element, between the layout passes and while
+// resources are loaded. When ctx is done, RenderPagesContext returns
+// ctx.Err() and the document is aborted: all later calls to RenderPages,
+// OutputAt and Finish return the same error, temporary files are removed and
+// the output written so far is incomplete and should be discarded. An error
+// while a new page is set up, for example from a page template, aborts the
+// document the same way.
+func (d *Document) RenderPagesContext(ctx context.Context, html string) error {
+	if d.aborted != nil {
+		return d.aborted
+	}
+	d.ctx = ctx
+	defer func() { d.ctx = nil }()
+	if err := d.renderDocument(html); err != nil {
+		if d.aborted != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return d.abort(ctx.Err())
+		}
+		if errors.Is(err, ErrPageLimitExceeded) {
+			return d.abort(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// renderDocument runs the rendering steps of RenderPages.
+func (d *Document) renderDocument(html string) error {
+	if err := d.context().Err(); err != nil {
+		return err
+	}
+	if err := d.checkInput(html, false); err != nil {
+		return err
+	}
+	if d.cfg.htmlMetadata {
+		if err := d.applyHTMLMeta(html); err != nil {
+			return err
+		}
+	}
+	src := html
+	html, err := d.addDocumentCSS(html)
+	if err != nil {
+		return err
+	}
+	if err := d.checkDocument(src); err != nil {
+		return err
+	}
+	if html, err = expandBarcodes(html, false); err != nil {
+		return err
+	}
+	if html, err = d.prepareResources(html, false); err != nil {
+		return err
+	}
+	if html, err = d.extractRunningElements(html); err != nil {
+		return err
+	}
+	if html, err = d.insertGenerated(html); err != nil {
+		return err
+	}
+	if d.pages.running {
+		if err := d.prepareRunning(html); err != nil {
+			return err
+		}
+	}
+	if len(d.overlays) > 0 {
+		if err := d.prepareOverlays(html); err != nil {
+			return err
+		}
+	}
+	first := d.pageCount
+	if err := d.renderPages(html); err != nil {
+		return err
+	}
+	return d.checkRunning(first)
+}
+
+// renderPages flows the prepared HTML onto pages. It stops with the error of
+// the context when the context is done.
+func (d *Document) renderPages(html string) (err error) {
+	if err := d.context().Err(); err != nil {
+		return err
+	}
+	// The callbacks installed by syncCallbacks panic with abortRendering,
+	// which only renderPages recovers. Restore the previous callbacks for
+	// pages started later by OutputAt, NewPage or Finish.
+	pageInit, element := d.cssbuilder.PageInitCallback, d.cssbuilder.ElementCallback
+	defer func() {
+		d.cssbuilder.PageInitCallback, d.cssbuilder.ElementCallback = pageInit, element
+	}()
 	d.syncCallbacks()
+	defer func() {
+		if r := recover(); r != nil {
+			a, ok := r.(abortRendering)
+			if !ok {
+				panic(r)
+			}
+			err = d.abort(a.err)
+		}
+	}()
+	d.sources = append(d.sources, html)
+	src := html
+	if !d.pages.planned {
+		d.pageName, d.pages.plan = "", nil
+		if d.pages.active() || mayChoosePages(html) {
+			if html, err = d.planPages(html); err != nil {
+				return err
+			}
+		}
+	}
+	d.pages.paging = d.pages.planned || d.pages.active() || d.pages.plan != nil || d.pageName != ""
+	defer func() { d.pages.paging = false }()
+	d.pagesRendered = true
+	known := len(d.cssbuilder.Headings)
+	if d.pages.paging {
+		if err := d.startPages(); err != nil {
+			return err
+		}
+	}
 	if err := d.cssbuilder.InitPage(); err != nil {
 		return err
 	}
@@ -238,8 +568,14 @@ func (d *Document) RenderPages(html string) error {
 	if err != nil {
 		return err
 	}
-	d.pagesRendered = true
-	return d.cssbuilder.OutputPagesFromText(te)
+	if err := d.cssbuilder.OutputPagesFromText(te); err != nil {
+		return err
+	}
+	if known > len(d.cssbuilder.Headings) {
+		known = 0
+	}
+	d.recordHeadingAnchors(src, d.cssbuilder.Headings[known:])
+	return nil
 }
 
 // Headings returns all headings (h1–h6) found during rendering, with their
@@ -259,24 +595,37 @@ func (d *Document) SetGenerateOutline(on bool) {
 // NewPage starts a new page. Only needed in OutputAt mode for manual
 // multi-page documents.
 func (d *Document) NewPage() error {
-	return d.cssbuilder.NewPage()
+	if max := d.cfg.limits.maxPages; max > 0 && d.manualPages+1 >= max {
+		return pageLimitError(max)
+	}
+	if err := d.cssbuilder.NewPage(); err != nil {
+		return err
+	}
+	d.manualPages++
+	return d.placePageTemplate(d.manualPages + 1)
 }
 
 // AttachFile embeds a file in the PDF document.
 func (d *Document) AttachFile(a Attachment) {
+	d.attachments = append(d.attachments, a)
 	d.Frontend.Doc.AttachFile(a)
 }
 
 // NewWithFrontend creates a document from an existing boxes and glue frontend
 // document and CSS parser. The default fonts (monospace, sans, serif) are loaded.
 func NewWithFrontend(fe *frontend.Document, cssparser *csshtml.CSS) (*Document, error) {
-	d := &Document{}
+	d := &Document{
+		anchors:      make(map[string]int),
+		fontFamilies: make(map[string]bool),
+	}
 	var err error
 	d.cssbuilder, err = htmlbag.New(fe, cssparser)
 	if err != nil {
 		return nil, err
 	}
+	d.css = cssparser
 	d.Frontend = fe
+	fe.Doc.RegisterCallback(document.CallbackPreShipout, document.CallbackShipout(d.shipoutOverlays))
 	return d, nil
 }
 
@@ -284,14 +633,47 @@ func NewWithFrontend(fe *frontend.Document, cssparser *csshtml.CSS) (*Document,
 // frontend document and loads a default CSS stylesheet. Options can be passed
 // to select a specific PDF format (e.g. WithPDFUA(), WithPDFA3b()).
 func New(filename string, opts ...Option) (*Document, error) {
-	var cfg config
-	for _, o := range opts {
-		o(&cfg)
+	cfg, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
 	}
 	fe, err := frontend.New(filename)
 	if err != nil {
 		return nil, err
 	}
+	return newDocument(fe, cfg)
+}
+
+// NewWriter creates a document that streams the finished PDF to w instead of
+// a file. Finish flushes w if it has a Flush() error method (for example a
+// *bufio.Writer) but never closes it; closing remains the caller's
+// responsibility.
+func NewWriter(w io.Writer, opts ...Option) (*Document, error) {
+	cfg, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	fe, err := frontend.NewForWriter(writerOnly{w})
+	if err != nil {
+		return nil, err
+	}
+	d, err := newDocument(fe, cfg)
+	if err != nil {
+		return nil, err
+	}
+	d.w = w
+	return d, nil
+}
+
+// writerOnly hides every method of the wrapped writer except Write, so the
+// backend cannot close a writer that belongs to the caller.
+type writerOnly struct {
+	io.Writer
+}
+
+// newDocument applies the configuration to a freshly created frontend
+// document and wraps it in a Document.
+func newDocument(fe *frontend.Document, cfg config) (*Document, error) {
 	if t, ok := resolveCreationDate(&cfg); ok {
 		// Three sources of non-determinism in the PDF: the InfoDict
 		// CreationDate (defaulted from d.CreationDate in Finish), the
@@ -317,11 +699,50 @@ func New(filename string, opts ...Option) (*Document, error) {
 		fe.Doc.AddXMPExtension(ext)
 	}
 	cs := csshtml.NewCSSParserWithDefaults()
-	return NewWithFrontend(fe, cs)
+	d, err := NewWithFrontend(fe, cs)
+	if err != nil {
+		return nil, err
+	}
+	d.cfg = cfg
+	d.attachments = append(d.attachments, cfg.attachments...)
+	if err := d.loadPageTemplates(); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
-// Finish writes and closes the PDF file.
+// Finish writes and closes the PDF file. For documents created with
+// NewWriter, the PDF is written to the writer, which is flushed but not
+// closed. If a PDF/UA, PDF/A or PDF/X format was requested, Finish runs
+// Preflight first and returns its *PreflightError without writing the PDF.
 func (d *Document) Finish() error {
+	return d.FinishContext(context.Background())
+}
+
+// FinishContext is Finish with a context. The context is checked before the
+// PDF is written; writing itself is not interrupted. If ctx is done, the
+// document is aborted as described for RenderPagesContext.
+func (d *Document) FinishContext(ctx context.Context) (err error) {
+	if d.aborted != nil {
+		return d.aborted
+	}
+	if err := ctx.Err(); err != nil {
+		return d.abort(err)
+	}
+	d.finished = true
+	defer func() {
+		if rerr := d.removeLocalFiles(); err == nil {
+			err = rerr
+		}
+	}()
+	if err := d.report(d.unplacedOverlays()); err != nil {
+		return err
+	}
+	if f := d.Frontend.Doc.Format; f.IsPDFUA() || f.IsPDFA() || f.PDFX != nil {
+		if err := d.Preflight(); err != nil {
+			return err
+		}
+	}
 	pdfDoc := d.Frontend.Doc
 	pdfDoc.Title = d.Title
 	pdfDoc.Author = d.Author
@@ -334,15 +755,88 @@ func (d *Document) Finish() error {
 		// ship out the current page.
 		pdfDoc.CurrentPage.Shipout()
 	}
-	return pdfDoc.Finish()
+	if err := pdfDoc.Finish(); err != nil {
+		return err
+	}
+	if f, ok := d.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
 }
 
-// syncCallbacks propagates Document-level callbacks to the CSSBuilder.
+// ErrClosed is returned by the methods of a document after Close.
+var ErrClosed = errors.New("document closed")
+
+// Close releases the resources of a document that is not going to be
+// finished, such as the temporary files of resources fetched by the resource
+// loader. Defer it after New or NewWriter so that these are removed when
+// rendering fails; after Finish, Close does nothing. Close does not write the
+// PDF, and later calls of RenderPages, OutputAt and Finish return ErrClosed.
+func (d *Document) Close() error {
+	if d.finished {
+		return nil
+	}
+	if d.aborted == nil {
+		d.aborted = ErrClosed
+	}
+	return d.removeLocalFiles()
+}
+
+// syncCallbacks propagates Document-level callbacks to the CSSBuilder. The
+// page and element callbacks also stop rendering when the context is done.
 func (d *Document) syncCallbacks() {
-	if d.PageInitCallback != nil {
-		d.cssbuilder.PageInitCallback = d.PageInitCallback
+	d.cssbuilder.PageInitCallback = func() {
+		if err := d.context().Err(); err != nil {
+			panic(abortRendering{err})
+		}
+		d.pageCount++
+		if max := d.cfg.limits.maxPages; max > 0 && d.pageCount > max {
+			panic(abortRendering{pageLimitError(max)})
+		}
+		if err := d.placePageTemplate(d.pageCount); err != nil {
+			panic(abortRendering{err})
+		}
+		if d.pages.paging {
+			d.placeMarginBoxes()
+			if err := d.prepareNextPage(); err != nil {
+				panic(abortRendering{err})
+			}
+		}
+		if d.PageInitCallback != nil {
+			d.PageInitCallback()
+		}
+		d.selectOverlays()
+	}
+	d.cssbuilder.ElementCallback = func() {
+		if err := d.context().Err(); err != nil {
+			panic(abortRendering{err})
+		}
+		if d.ElementCallback != nil {
+			d.ElementCallback()
+		}
 	}
-	if d.ElementCallback != nil {
-		d.cssbuilder.ElementCallback = d.ElementCallback
+}
+
+// abortRendering is the panic value that unwinds the renderer when the
+// context is done or a page cannot be set up. renderPages recovers it and
+// aborts the document, since the pages rendered so far are incomplete.
+type abortRendering struct {
+	err error
+}
+
+// context returns the context of the running operation.
+func (d *Document) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// abort puts the document into the aborted state and returns err.
+func (d *Document) abort(err error) error {
+	d.aborted = err
+	if rerr := d.removeLocalFiles(); rerr != nil {
+		return errors.Join(err, rerr)
 	}
+	return err
 }
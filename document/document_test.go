@@ -1,9 +1,15 @@
 package document
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/boxesandglue/boxesandglue/backend/bag"
 	"github.com/boxesandglue/boxesandglue/backend/document"
@@ -45,12 +51,97 @@ func TestNewInvalidPath(t *testing.T) {
 	}
 }
 
+// renderSample renders the same small document through d so that file and
+// writer output can be compared byte for byte.
+func renderSample(t *testing.T, d *Document) {
+	t.Helper()
+	d.SetGenerateOutline(false)
+	if err := d.RenderPages("<h1>Invoice</h1><p>Streaming output</p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	filename := tempPDF(t)
+	fd, err := New(filename, WithCreationDate(created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderSample(t, fd)
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	wd, err := NewWriter(&buf, WithCreationDate(created))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderSample(t, wd)
+	if buf.Len() == 0 {
+		t.Fatal("no PDF written to buffer")
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("writer output (%d bytes) differs from file output (%d bytes)", buf.Len(), len(want))
+	}
+}
+
+// closeRecorder records whether the document tried to close it.
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewWriterDoesNotClose(t *testing.T) {
+	var w closeRecorder
+	d, err := NewWriter(&w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderSample(t, d)
+	if w.closed {
+		t.Error("Finish closed the caller's writer")
+	}
+	if !bytes.HasPrefix(w.Bytes(), []byte("%PDF-")) {
+		t.Error("expected PDF header in writer output")
+	}
+}
+
+func TestNewWriterFlushes(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriterSize(&buf, 1<<20)
+	d, err := NewWriter(bw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderSample(t, d)
+	if bw.Buffered() != 0 {
+		t.Errorf("expected Finish to flush, %d bytes still buffered", bw.Buffered())
+	}
+	if buf.Len() == 0 {
+		t.Error("no PDF written through bufio.Writer")
+	}
+}
+
 func TestWithPDFUA(t *testing.T) {
 	filename := tempPDF(t)
 	d, err := New(filename, WithPDFUA())
 	if err != nil {
 		t.Fatal(err)
 	}
+	d.Title = "Accessible PDF"
+	d.Language = "en"
 	if err := d.RenderPages("<p>Accessible PDF</p>"); err != nil {
 		t.Fatal(err)
 	}
@@ -86,6 +177,7 @@ func TestWithPDFX3(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	d.Title = "Print PDF"
 	if err := d.RenderPages("<p>Print PDF</p>"); err != nil {
 		t.Fatal(err)
 	}
@@ -100,6 +192,7 @@ func TestWithPDFX4(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	d.Title = "Print PDF"
 	if err := d.RenderPages("<p>Print PDF X4</p>"); err != nil {
 		t.Fatal(err)
 	}
@@ -384,8 +477,8 @@ func TestWithAttachmentOption(t *testing.T) {
 
 func TestWithZUGFeRD(t *testing.T) {
 	filename := tempPDF(t)
-	xmlData := []byte(`<?xml version="1.0"?><invoice/>`)
-	d, err := New(filename, WithZUGFeRD(xmlData, "EN 16931"))
+	xmlData := testCII("urn:cen.eu:en16931:2017", "119.00")
+	d, err := New(filename, WithZUGFeRD(xmlData, "EN 16931"), WithZUGFeRDTotal("119"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -395,6 +488,20 @@ func TestWithZUGFeRD(t *testing.T) {
 	if err := d.Finish(); err != nil {
 		t.Fatal(err)
 	}
+	attachments, err := ExtractAttachmentsFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 {
+		t.Fatalf("expected 1 embedded file, got %d", len(attachments))
+	}
+	a := attachments[0]
+	if a.Name != "factur-x.xml" || a.MimeType != "text/xml" || a.AFRelationship != AFRelationshipAlternative {
+		t.Errorf("unexpected embedded file %q (%s, %s)", a.Name, a.MimeType, a.AFRelationship)
+	}
+	if !bytes.Equal(a.Data, xmlData) {
+		t.Error("embedded XML differs from the input")
+	}
 }
 
 func TestWithZUGFeRDProfileNormalization(t *testing.T) {
@@ -416,8 +523,11 @@ func TestWithZUGFeRDProfileNormalization(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
 			var cfg config
-			opt := WithZUGFeRD([]byte("<xml/>"), tt.input)
+			opt := WithZUGFeRD(testCII(testGuidelines[tt.expected], "1"), tt.input)
 			opt(&cfg)
+			if cfg.err != nil {
+				t.Fatal(cfg.err)
+			}
 			if len(cfg.xmpExtensions) == 0 {
 				t.Fatal("expected XMP extension")
 			}
@@ -431,7 +541,7 @@ func TestWithZUGFeRDProfileNormalization(t *testing.T) {
 
 func TestWithZUGFeRDSetsFormat(t *testing.T) {
 	var cfg config
-	opt := WithZUGFeRD([]byte("<xml/>"), "BASIC")
+	opt := WithZUGFeRD(testCII(testGuidelines["BASIC"], "1"), "BASIC")
 	opt(&cfg)
 	if !cfg.format.IsPDFA() || cfg.format.PDFA.Part != 3 || cfg.format.PDFA.Level != document.PDFALevelB {
 		t.Errorf("WithZUGFeRD should set PDF/A-3b sub-conformance, got %+v", cfg.format)
@@ -449,7 +559,7 @@ func TestWithZUGFeRDSetsFormat(t *testing.T) {
 func TestWithZUGFeRDComposesWithPDFUA(t *testing.T) {
 	var cfg config
 	WithPDFUA()(&cfg)
-	WithZUGFeRD([]byte("<xml/>"), "BASIC")(&cfg)
+	WithZUGFeRD(testCII(testGuidelines["BASIC"], "1"), "BASIC")(&cfg)
 	if !cfg.format.IsPDFA() {
 		t.Errorf("PDF/A sub-conformance lost after composing with WithPDFUA")
 	}
@@ -547,3 +657,157 @@ func TestMultipleOptionsChaining(t *testing.T) {
 		t.Errorf("expected 2 attachments, got %d", len(cfg.attachments))
 	}
 }
+
+func TestRenderPagesContextExpired(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if err := d.RenderPagesContext(ctx, "<p>Hello</p>"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if err := d.RenderPages("<p>Hello</p>"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected aborted document to refuse RenderPages, got %v", err)
+	}
+	if err := d.Finish(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected aborted document to refuse Finish, got %v", err)
+	}
+}
+
+func TestRenderPagesContextDeadline(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	html := strings.Repeat("<p>Endless content that keeps the renderer busy for a long time.</p>\n", 20000)
+	start := time.Now()
+	if err := d.RenderPagesContext(ctx, html); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	t.Logf("aborted after %v", time.Since(start))
+}
+
+func TestRenderPagesContextCancelBetweenPages(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := 0
+	d.PageInitCallback = func() {
+		pages++
+		if pages == 2 {
+			cancel()
+		}
+	}
+	html := strings.Repeat(`<p style="page-break-after: always">Page</p>`, 10)
+	if err := d.RenderPagesContext(ctx, html); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if pages != 2 {
+		t.Errorf("expected rendering to stop after 2 pages, got %d", pages)
+	}
+	if err := d.FinishContext(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected aborted document to refuse Finish, got %v", err)
+	}
+}
+
+func TestRenderPagesContextCancelInElement(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := 0
+	d.PageInitCallback = func() { pages++ }
+	d.ElementCallback = func() { cancel() }
+	html := strings.Repeat(`<p style="page-break-after: always">Page</p>`, 10)
+	if err := d.RenderPagesContext(ctx, html); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if pages != 1 {
+		t.Errorf("expected rendering to stop on the first page, got %d pages", pages)
+	}
+	if err := d.Finish(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected aborted document to refuse Finish, got %v", err)
+	}
+}
+
+func TestFinishContext(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>Hello</p>"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.FinishContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	d, err := NewWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, err := d.tempFile([]byte("png"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fn); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected Close to remove the temporary files")
+	}
+	if err := d.RenderPages("<p>Hello</p>"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from RenderPages, got %v", err)
+	}
+	if err := d.Finish(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Finish, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if d, err = NewWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>Hello</p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("expected Close after Finish to do nothing, got %v", err)
+	}
+}
+
+func TestCallbacksRestoredAfterRenderPages(t *testing.T) {
+	d, err := New(tempPDF(t), WithMaxPages(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>One</p>"); err != nil {
+		t.Fatal(err)
+	}
+	d.pageCount = 1
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("expected no panic after RenderPages returned, got %v", r)
+		}
+	}()
+	if f := d.cssbuilder.PageInitCallback; f != nil {
+		f()
+	}
+	// OutputAt may start a page; whether it succeeds does not matter here.
+	d.OutputAt("<p>Two</p>", bag.MustSP("5cm"), bag.MustSP("1cm"), bag.MustSP("5cm"))
+}
@@ -0,0 +1,200 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/bagme/internal/woff"
+)
+
+// WithBaseDir resolves relative URLs against dir: in stylesheets added with
+// AddCSS, for example the sources of @font-face rules, in <link> elements,
+// and in image sources and style attributes of the HTML. Stylesheets read
+// with ReadCSSFile always use the directory of the file.
+func WithBaseDir(dir string) Option {
+	return func(c *config) {
+		c.baseDir = dir
+		c.baseFS = os.DirFS(dir)
+	}
+}
+
+// WithBaseFS resolves relative @font-face sources in stylesheets added with
+// AddCSS against fsys, for example an embed.FS that holds the fonts next to
+// the stylesheet.
+func WithBaseFS(fsys fs.FS) Option {
+	return func(c *config) {
+		c.baseDir = ""
+		c.baseFS = fsys
+	}
+}
+
+// extractFontFaces removes the top level @font-face rules from blocks and
+// loads their fonts with cfg.readResource. Relative sources are resolved
+// against dir. The families are returned in the order they first appear.
+func extractFontFaces(ctx context.Context, blocks []*cssBlock, dir string, cfg *config) ([]fontFamily, []*cssBlock, error) {
+	var families []fontFamily
+	index := make(map[string]int)
+	rest := blocks[:0:0]
+	for _, b := range blocks {
+		if b.atRule() != "font-face" {
+			rest = append(rest, b)
+			continue
+		}
+		name, face, err := loadFontFace(ctx, b, dir, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := strings.ToLower(name)
+		i, ok := index[key]
+		if !ok {
+			i = len(families)
+			index[key] = i
+			families = append(families, fontFamily{name: name, css: true})
+		}
+		families[i].faces = append(families[i].faces, face)
+	}
+	return families, rest, nil
+}
+
+// loadFontFace reads the descriptors of a @font-face rule and loads the
+// first source that can be read. WOFF and WOFF2 fonts are converted to
+// OpenType.
+func loadFontFace(ctx context.Context, b *cssBlock, dir string, cfg *config) (string, FontFace, error) {
+	var face FontFace
+	family, _ := b.get("font-family")
+	name := unquoteCSS(family)
+	if name == "" {
+		return "", face, errors.New("@font-face without font-family")
+	}
+	if v, ok := b.get("font-weight"); ok {
+		w, variable, err := parseFontWeight(v)
+		if err != nil {
+			return "", face, fmt.Errorf("@font-face %q: %w", name, err)
+		}
+		face.Weight, face.Variable = w, variable
+	}
+	if v, ok := b.get("font-style"); ok {
+		v = strings.ToLower(strings.TrimSpace(v))
+		face.Italic = v == "italic" || strings.HasPrefix(v, "oblique")
+	}
+	src, _ := b.get("src")
+	var lastErr error
+	for _, s := range splitCSSList(src) {
+		ref, format, ok := fontSource(s)
+		if !ok {
+			continue
+		}
+		switch format {
+		case "", "woff", "woff2", "truetype", "opentype", "collection":
+		default:
+			continue
+		}
+		data, _, err := cfg.readResource(ctx, ref, dir)
+		if err == nil {
+			data, err = woff.Decode(data)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		face.Data = data
+		return name, face, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no usable url() in src")
+	}
+	return "", face, fmt.Errorf("@font-face %q: %w", name, lastErr)
+}
+
+// parseFontWeight interprets the font-weight descriptor. A range such as
+// "100 900" denotes a variable font.
+func parseFontWeight(v string) (int, bool, error) {
+	fields := strings.Fields(strings.ToLower(v))
+	weights := make([]int, 0, 2)
+	for _, f := range fields {
+		switch f {
+		case "normal":
+			weights = append(weights, 400)
+		case "bold":
+			weights = append(weights, 700)
+		default:
+			w, err := strconv.ParseFloat(f, 64)
+			if err != nil || w < 1 || w > 1000 {
+				return 0, false, fmt.Errorf("invalid font-weight %q", v)
+			}
+			weights = append(weights, min(max(int(w+50)/100*100, 100), 900))
+		}
+	}
+	switch {
+	case len(weights) == 1:
+		return weights[0], false, nil
+	case len(weights) == 2 && weights[0] != weights[1]:
+		return 400, true, nil
+	case len(weights) == 2:
+		return weights[0], false, nil
+	}
+	return 0, false, fmt.Errorf("invalid font-weight %q", v)
+}
+
+// fontSource splits an entry of the src descriptor such as
+// url(fonts/a.woff2) format("woff2") into the URL and the lower case
+// format. local() entries are not supported and yield ok == false.
+func fontSource(s string) (ref, format string, ok bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToLower(s), "url(") {
+		return "", "", false
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return "", "", false
+	}
+	ref = unquoteCSS(s[4:end])
+	rest := strings.TrimSpace(s[end+1:])
+	if strings.HasPrefix(strings.ToLower(rest), "format(") {
+		if e := strings.IndexByte(rest, ')'); e >= 0 {
+			format = strings.ToLower(unquoteCSS(rest[7:e]))
+		}
+	}
+	return ref, format, ref != ""
+}
+
+// unquoteCSS removes surrounding white space and quotes.
+func unquoteCSS(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
+
+// splitCSSList splits a comma separated value at the top level, leaving
+// commas in parentheses and strings alone.
+func splitCSSList(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,155 @@
+package document
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFontWeight(t *testing.T) {
+	tests := []struct {
+		value    string
+		weight   int
+		variable bool
+	}{
+		{"normal", 400, false},
+		{"bold", 700, false},
+		{"600", 600, false},
+		{"100 900", 400, true},
+		{"300 300", 300, false},
+	}
+	for _, tt := range tests {
+		w, variable, err := parseFontWeight(tt.value)
+		if err != nil {
+			t.Errorf("%q: %v", tt.value, err)
+			continue
+		}
+		if w != tt.weight || variable != tt.variable {
+			t.Errorf("%q: expected (%d, %t), got (%d, %t)", tt.value, tt.weight, tt.variable, w, variable)
+		}
+	}
+	if _, _, err := parseFontWeight("heavy"); err == nil {
+		t.Error("expected error for invalid weight")
+	}
+}
+
+func TestExtractFontFaces(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "fonts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fonts", "Corp.ttf"), []byte("regular"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bold := base64.StdEncoding.EncodeToString([]byte("bold"))
+	css := `@font-face { font-family: "Corp"; src: local("Corp"), url(fonts/Corp.eot) format("embedded-opentype"), url("fonts/Corp.ttf?v=2") format("truetype"); }
+@font-face { font-family: Corp; font-weight: bold; font-style: italic; src: url(data:font/ttf;base64,` + bold + `); }
+@font-face { font-family: Other; src: url(other.ttf); }
+body { font-family: Corp; }`
+	families, rest, err := extractFontFaces(context.Background(), parseCSS(css), "", &config{baseFS: fstest.MapFS{
+		"fonts/Corp.ttf": {Data: []byte("regular")},
+		"other.ttf":      {Data: []byte("other")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].prelude != "body" {
+		t.Errorf("expected only the body rule to remain, got %q", writeCSS(rest))
+	}
+	if len(families) != 2 || families[0].name != "Corp" || families[1].name != "Other" {
+		t.Fatalf("unexpected families %+v", families)
+	}
+	corp := families[0]
+	if !corp.css || len(corp.faces) != 2 {
+		t.Fatalf("unexpected faces %+v", corp)
+	}
+	if string(corp.faces[0].Data) != "regular" || corp.faces[0].Italic {
+		t.Errorf("unexpected regular face %+v", corp.faces[0])
+	}
+	if f := corp.faces[1]; string(f.Data) != "bold" || f.Weight != 700 || !f.Italic {
+		t.Errorf("unexpected bold italic face %+v", f)
+	}
+
+	// A stylesheet read from a file resolves sources against its directory.
+	families, _, err = extractFontFaces(context.Background(), parseCSS(css), dir, &config{})
+	if err == nil || !strings.Contains(err.Error(), "other.ttf") {
+		t.Errorf("expected other.ttf to be missing in %s, got %v", dir, err)
+	}
+	families, _, err = extractFontFaces(context.Background(), parseCSS(`@font-face { font-family: Corp; src: url(fonts/Corp.ttf); }`), dir, &config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(families[0].faces[0].Data) != "regular" {
+		t.Errorf("unexpected font data %q", families[0].faces[0].Data)
+	}
+}
+
+func TestExtractFontFacesErrors(t *testing.T) {
+	tests := []struct {
+		css  string
+		want string
+	}{
+		{`@font-face { src: url(a.ttf); }`, "without font-family"},
+		{`@font-face { font-family: A; src: url(https://example.com/a.woff2); }`, "unsupported URL scheme"},
+		{`@font-face { font-family: A; src: local(A); }`, "no usable url()"},
+		{`@font-face { font-family: A; src: url(missing.ttf); }`, "missing.ttf"},
+		{`@font-face { font-family: A; font-weight: heavy; src: url(a.ttf); }`, "invalid font-weight"},
+	}
+	for _, tt := range tests {
+		_, _, err := extractFontFaces(context.Background(), parseCSS(tt.css), "", &config{baseFS: fstest.MapFS{}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.css, tt.want, err)
+		}
+	}
+}
+
+func TestFontFace(t *testing.T) {
+	font := systemFont(t)
+	data, err := os.ReadFile(font)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Corp.ttf"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cssFile := filepath.Join(dir, "fonts.css")
+	css := `@font-face { font-family: Corp; src: url(Corp.ttf); }
+@font-face { font-family: Corp; font-weight: bold; src: url(data:font/ttf;base64,` + base64.StdEncoding.EncodeToString(data) + `); }
+body { font-family: Corp; }`
+	if err := os.WriteFile(cssFile, []byte(css), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReadCSSFile(cssFile); err != nil {
+		t.Fatal(err)
+	}
+	if !d.fontFamilies["corp"] {
+		t.Error("expected font family Corp to be registered")
+	}
+	if err := d.RenderPages("<p>Regular and <b>bold</b></p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// AddCSS resolves against WithBaseDir.
+	d, err = New(tempPDF(t), WithBaseDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(css); err != nil {
+		t.Fatal(err)
+	}
+	if !d.fontFamilies["corp"] {
+		t.Error("expected font family Corp to be registered with WithBaseDir")
+	}
+}
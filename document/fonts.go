@@ -0,0 +1,344 @@
+package document
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// FontFace is one file of a font family.
+type FontFace struct {
+	// Filename is the path of an OpenType or TrueType font file. Set either
+	// Filename or Data.
+	Filename string
+	// Data holds the contents of the font file.
+	Data []byte
+	// Weight is the CSS font weight (100–900). Zero means 400.
+	Weight int
+	// Italic selects font-style: italic.
+	Italic bool
+	// Variable marks a variable font. It is registered once, at Weight;
+	// the renderer uses the default instance of the font unless the CSS
+	// selects another one with font-variation-settings, for example
+	// "wght" 700 for bold text.
+	Variable bool
+}
+
+// name describes the face in error messages.
+func (f FontFace) name() string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+	return fmt.Sprintf("weight %d italic %t", f.weight(), f.Italic)
+}
+
+func (f FontFace) weight() int {
+	if f.Weight == 0 {
+		return 400
+	}
+	return f.Weight
+}
+
+// AddFontFamily registers a font family so that CSS font-family rules
+// resolve against it. A family needs an upright face (not italic) of weight
+// 400 or a variable upright face. Regular, bold, italic and bold italic
+// faces are typically given. Faces can be added to a family in several
+// calls, also to the default families monospace, sans and serif.
+func (d *Document) AddFontFamily(name string, faces ...FontFace) error {
+	if name == "" {
+		return errors.New("font family without a name")
+	}
+	if len(faces) == 0 {
+		return fmt.Errorf("font family %q: no font faces given", name)
+	}
+	hasRegular := false
+	for _, f := range faces {
+		if f.Filename == "" && f.Data == nil {
+			return fmt.Errorf("font family %q: face without file name or data", name)
+		}
+		if f.Weight != 0 && (f.Weight < 100 || f.Weight > 900) {
+			return fmt.Errorf("font family %q: %s: weight %d out of range 100–900", name, f.name(), f.Weight)
+		}
+		if f.Filename != "" && f.Data == nil {
+			if _, err := os.Stat(f.Filename); err != nil {
+				return fmt.Errorf("font family %q: %w", name, err)
+			}
+		}
+		if !f.Italic && (f.Variable || f.weight() == 400) {
+			hasRegular = true
+		}
+	}
+	if !hasRegular && d.Frontend.FindFontFamily(name) == nil {
+		return fmt.Errorf("font family %q: missing regular face (weight 400, not italic)", name)
+	}
+	return d.registerFontFamily(fontFamily{name: name, faces: faces})
+}
+
+// registerFontFamily adds the faces to the frontend without validating
+// them. @font-face rules may declare a family without a regular face.
+func (d *Document) registerFontFamily(family fontFamily) error {
+	name, faces := family.name, family.faces
+	ff := d.Frontend.FindFontFamily(name)
+	if ff == nil {
+		ff = d.Frontend.NewFontFamily(name)
+	}
+	for _, f := range faces {
+		src := &frontend.FontSource{Location: f.Filename, Data: f.Data}
+		style := frontend.FontStyleNormal
+		if f.Italic {
+			style = frontend.FontStyleItalic
+		}
+		if err := ff.AddMember(src, frontend.FontWeight(f.weight()), style); err != nil {
+			return fmt.Errorf("font family %q: %s: %w", name, f.name(), err)
+		}
+	}
+	d.fontFamilies[strings.ToLower(name)] = true
+	d.fonts = append(d.fonts, family)
+	return nil
+}
+
+// LoadFontsFromFS registers all .ttf and .otf files in fsys (for example an
+// embed.FS or os.DirFS) as font families. The family name is read from the
+// name table of the font, preferring the typographic family name, so that
+// OpenSans-Regular.ttf and OpenSans-Light.ttf both belong to "Open Sans".
+// Fonts without a family name are grouped by the part of the file name
+// before the first hyphen or bracket:
+//
+//	Inter-Regular.ttf, Inter-Bold.ttf, Inter-BoldItalic.ttf → "Inter"
+//	Roboto[wght].ttf, Roboto-Italic[wght].ttf             → "Roboto", variable
+//
+// Weight and style are read from the OS/2 table of the font (usWeightClass
+// and fsSelection), a variable font is recognized by its fvar table. Only
+// fonts without a usable OS/2 table are classified by the file name. A
+// variable font is registered once, at the weight of its default instance,
+// not for each weight of its range; see FontFace.Variable. Each family
+// needs a regular face.
+func (d *Document) LoadFontsFromFS(fsys fs.FS) error {
+	families, err := fontFamiliesFromFS(fsys)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(families) {
+		if err := d.AddFontFamily(name, families[name]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFontsFromDir is LoadFontsFromFS for a directory of the file system.
+func (d *Document) LoadFontsFromDir(dir string) error {
+	return d.LoadFontsFromFS(os.DirFS(dir))
+}
+
+// fontFamiliesFromFS reads all font files in fsys and groups them by family.
+func fontFamiliesFromFS(fsys fs.FS) (map[string][]FontFace, error) {
+	families := make(map[string][]FontFace)
+	err := fs.WalkDir(fsys, ".", func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(path.Ext(p))
+		if de.IsDir() || (ext != ".ttf" && ext != ".otf") {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		family, face := classifyFontFile(path.Base(p))
+		if name, ok := sfntFamily(data); ok {
+			family = name
+		}
+		if weight, italic, variable, ok := sfntStyle(data); ok {
+			face.Weight, face.Italic, face.Variable = weight, italic, variable
+		}
+		face.Data = data
+		families[family] = append(families[family], face)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(families) == 0 {
+		return nil, errors.New("no .ttf or .otf font files found")
+	}
+	return families, nil
+}
+
+// fontWeightNames maps style names in font file names to CSS weights.
+// Longer names come first so that "SemiBold" is not taken for "Bold".
+var fontWeightNames = []struct {
+	name   string
+	weight int
+}{
+	{"extralight", 200}, {"ultralight", 200}, {"extrabold", 800}, {"ultrabold", 800},
+	{"semibold", 600}, {"demibold", 600}, {"hairline", 100}, {"regular", 400},
+	{"medium", 500}, {"normal", 400}, {"black", 900}, {"heavy", 900},
+	{"light", 300}, {"thin", 100}, {"book", 400}, {"bold", 700},
+}
+
+// classifyFontFile derives family name, weight and style from a font file
+// name such as "CrimsonPro-SemiBoldItalic.ttf" or "Inter[wght].ttf".
+// The family name is only used if sfntFamily cannot read it from the font,
+// weight and style only if sfntStyle cannot read them.
+func classifyFontFile(filename string) (string, FontFace) {
+	stem := strings.TrimSuffix(filename, path.Ext(filename))
+	var face FontFace
+	if i := strings.IndexByte(stem, '['); i >= 0 {
+		face.Variable = true
+		stem = stem[:i]
+	}
+	family, style, _ := strings.Cut(stem, "-")
+	style = strings.ToLower(style)
+	if strings.Contains(style, "italic") || strings.Contains(style, "oblique") {
+		face.Italic = true
+	}
+	if strings.HasSuffix(style, "vf") || strings.Contains(style, "variable") {
+		face.Variable = true
+	}
+	for _, wn := range fontWeightNames {
+		if strings.Contains(style, wn.name) {
+			face.Weight = wn.weight
+			break
+		}
+	}
+	return family, face
+}
+
+// sfntTables returns the tables of an OpenType or TrueType font by tag. The
+// first font of a collection is used. ok is false if data is not a font or
+// a table lies outside of data.
+func sfntTables(data []byte) (map[string][]byte, bool) {
+	be := binary.BigEndian
+	start := 0
+	if len(data) >= 16 && string(data[:4]) == "ttcf" {
+		start = int(be.Uint32(data[12:]))
+	}
+	if start < 0 || len(data) < start+12 {
+		return nil, false
+	}
+	switch string(data[start : start+4]) {
+	case "\x00\x01\x00\x00", "OTTO", "true":
+	default:
+		return nil, false
+	}
+	numTables := int(be.Uint16(data[start+4:]))
+	tables := make(map[string][]byte, numTables)
+	for i := 0; i < numTables; i++ {
+		rec := start + 12 + 16*i
+		if len(data) < rec+16 {
+			return nil, false
+		}
+		offset, length := int(be.Uint32(data[rec+8:])), int(be.Uint32(data[rec+12:]))
+		if offset < 0 || length < 0 || offset > len(data)-length {
+			return nil, false
+		}
+		tables[string(data[rec:rec+4])] = data[offset : offset+length]
+	}
+	return tables, true
+}
+
+// sfntStyle reads the weight (usWeightClass) and the italic and oblique bits
+// of fsSelection from the OS/2 table of an OpenType or TrueType font and
+// whether the font has an fvar table. The first font of a collection is
+// used. ok is false if the font has no OS/2 table with a weight from 100
+// to 900.
+func sfntStyle(data []byte) (weight int, italic, variable, ok bool) {
+	tables, ok := sfntTables(data)
+	if !ok {
+		return 0, false, false, false
+	}
+	os2 := tables["OS/2"]
+	if len(os2) < 64 {
+		return 0, false, false, false
+	}
+	be := binary.BigEndian
+	weight = int(be.Uint16(os2[4:]))
+	if weight < 100 || weight > 900 {
+		return 0, false, false, false
+	}
+	fsSelection := be.Uint16(os2[62:])
+	italic = fsSelection&(1<<0) != 0 || fsSelection&(1<<9) != 0
+	_, variable = tables["fvar"]
+	return weight, italic, variable, true
+}
+
+// sfntFamily reads the family name from the name table of an OpenType or
+// TrueType font: the typographic family name (name ID 16) if the font has
+// one, otherwise the family name (name ID 1). English names on the Windows
+// platform are preferred. ok is false if the font has no family name.
+func sfntFamily(data []byte) (string, bool) {
+	tables, ok := sfntTables(data)
+	if !ok {
+		return "", false
+	}
+	nt := tables["name"]
+	be := binary.BigEndian
+	if len(nt) < 6 {
+		return "", false
+	}
+	count, storage := int(be.Uint16(nt[2:])), int(be.Uint16(nt[4:]))
+	best, bestScore := "", 0
+	for i := 0; i < count; i++ {
+		rec := 6 + 12*i
+		if len(nt) < rec+12 {
+			break
+		}
+		platform, encoding, language := be.Uint16(nt[rec:]), be.Uint16(nt[rec+2:]), be.Uint16(nt[rec+4:])
+		nameID := be.Uint16(nt[rec+6:])
+		length, offset := int(be.Uint16(nt[rec+8:])), storage+int(be.Uint16(nt[rec+10:]))
+		if nameID != 1 && nameID != 16 || offset+length > len(nt) {
+			continue
+		}
+		raw := nt[offset : offset+length]
+		var name string
+		score := 1
+		switch {
+		case platform == 3 && (encoding == 1 || encoding == 10), platform == 0:
+			name = decodeUTF16BE(raw)
+			score = 2
+			if platform == 3 && language == 0x409 {
+				score = 3
+			}
+		case platform == 1 && encoding == 0:
+			// Mac Roman; the ASCII range is the same.
+			name = string(raw)
+		default:
+			continue
+		}
+		if nameID == 16 {
+			score += 10
+		}
+		if name = strings.TrimSpace(name); name != "" && score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best, best != ""
+}
+
+// decodeUTF16BE decodes UTF-16 big endian text.
+func decodeUTF16BE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
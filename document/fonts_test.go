@@ -0,0 +1,311 @@
+package document
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+)
+
+func TestClassifyFontFile(t *testing.T) {
+	tests := []struct {
+		filename string
+		family   string
+		face     FontFace
+	}{
+		{"Inter-Regular.ttf", "Inter", FontFace{Weight: 400}},
+		{"Inter-Bold.otf", "Inter", FontFace{Weight: 700}},
+		{"Inter-BoldItalic.ttf", "Inter", FontFace{Weight: 700, Italic: true}},
+		{"CrimsonPro-SemiBoldItalic.ttf", "CrimsonPro", FontFace{Weight: 600, Italic: true}},
+		{"Roboto[wght].ttf", "Roboto", FontFace{Variable: true}},
+		{"Roboto-Italic[wght].ttf", "Roboto", FontFace{Italic: true, Variable: true}},
+		{"Corporate.ttf", "Corporate", FontFace{}},
+	}
+	for _, tt := range tests {
+		family, face := classifyFontFile(tt.filename)
+		if family != tt.family {
+			t.Errorf("%s: expected family %q, got %q", tt.filename, tt.family, family)
+		}
+		if face.Weight != tt.face.Weight || face.Italic != tt.face.Italic || face.Variable != tt.face.Variable {
+			t.Errorf("%s: expected %+v, got %+v", tt.filename, tt.face, face)
+		}
+	}
+}
+
+type sfntTable struct {
+	tag  string
+	data []byte
+}
+
+// sfntFont builds an sfnt font that has only an OS/2 table with the given
+// weight and fsSelection, if variable is set an empty fvar table and the
+// extra tables.
+func sfntFont(weight, fsSelection uint16, variable bool, extra ...sfntTable) []byte {
+	os2 := make([]byte, 78)
+	binary.BigEndian.PutUint16(os2[4:], weight)
+	binary.BigEndian.PutUint16(os2[62:], fsSelection)
+	tables := []sfntTable{{"OS/2", os2}}
+	if variable {
+		tables = append(tables, sfntTable{"fvar", make([]byte, 16)})
+	}
+	tables = append(tables, extra...)
+	font := []byte{0, 1, 0, 0, 0, byte(len(tables)), 0, 0, 0, 0, 0, 0}
+	offset := 12 + 16*len(tables)
+	var body []byte
+	for _, tbl := range tables {
+		rec := make([]byte, 16)
+		copy(rec, tbl.tag)
+		binary.BigEndian.PutUint32(rec[8:], uint32(offset+len(body)))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(tbl.data)))
+		font = append(font, rec...)
+		body = append(body, tbl.data...)
+	}
+	return append(font, body...)
+}
+
+// sfntName is a record of a name table.
+type sfntName struct {
+	platform, encoding, language, id uint16
+	value                            string
+}
+
+// nameTable builds a name table. Names on the Macintosh platform are
+// stored as ASCII, all others as UTF-16BE.
+func nameTable(names ...sfntName) sfntTable {
+	be := binary.BigEndian
+	header := make([]byte, 6+12*len(names))
+	be.PutUint16(header[2:], uint16(len(names)))
+	be.PutUint16(header[4:], uint16(len(header)))
+	var storage []byte
+	for i, n := range names {
+		var raw []byte
+		if n.platform == 1 {
+			raw = []byte(n.value)
+		} else {
+			for _, u := range utf16.Encode([]rune(n.value)) {
+				raw = be.AppendUint16(raw, u)
+			}
+		}
+		rec := header[6+12*i:]
+		be.PutUint16(rec, n.platform)
+		be.PutUint16(rec[2:], n.encoding)
+		be.PutUint16(rec[4:], n.language)
+		be.PutUint16(rec[6:], n.id)
+		be.PutUint16(rec[8:], uint16(len(raw)))
+		be.PutUint16(rec[10:], uint16(len(storage)))
+		storage = append(storage, raw...)
+	}
+	return sfntTable{"name", append(header, storage...)}
+}
+
+func TestSfntFamily(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"family", sfntFont(400, 0, false, nameTable(sfntName{3, 1, 0x409, 1, "Open Sans"})), "Open Sans"},
+		{"typographic family", sfntFont(300, 0, false, nameTable(
+			sfntName{3, 1, 0x409, 1, "Open Sans Light"},
+			sfntName{3, 1, 0x409, 16, "Open Sans"},
+		)), "Open Sans"},
+		{"English preferred", sfntFont(400, 0, false, nameTable(
+			sfntName{3, 1, 0x407, 1, "Schrift"},
+			sfntName{3, 1, 0x409, 1, "Font"},
+			sfntName{1, 0, 0, 1, "Mac Font"},
+		)), "Font"},
+		{"Macintosh", sfntFont(400, 0, false, nameTable(sfntName{1, 0, 0, 1, "Mac Font"})), "Mac Font"},
+		{"unknown encoding", sfntFont(400, 0, false, nameTable(sfntName{3, 3, 0x409, 1, "Font"})), ""},
+		{"no name table", sfntFont(400, 0, false), ""},
+		{"not a font", []byte("hello, world"), ""},
+	}
+	for _, tt := range tests {
+		got, ok := sfntFamily(tt.data)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: expected %q, got %q %t", tt.name, tt.want, got, ok)
+		}
+	}
+}
+
+func TestSfntStyle(t *testing.T) {
+	tests := []struct {
+		name                 string
+		data                 []byte
+		weight               int
+		italic, variable, ok bool
+	}{
+		{"regular", sfntFont(400, 0x40, false), 400, false, false, true},
+		{"bold italic", sfntFont(700, 0x21, false), 700, true, false, true},
+		{"oblique", sfntFont(300, 1<<9, false), 300, true, false, true},
+		{"variable", sfntFont(400, 0x40, true), 400, false, true, true},
+		{"weight out of range", sfntFont(5, 0, false), 0, false, false, false},
+		{"collection", append([]byte("ttcf\x00\x01\x00\x00\x00\x00\x00\x01\x00\x00\x00\x10"), sfntFont(600, 0, false)...), 0, false, false, false},
+		{"not a font", []byte("hello, world"), 0, false, false, false},
+		{"truncated", sfntFont(400, 0, false)[:40], 0, false, false, false},
+	}
+	for _, tt := range tests {
+		weight, italic, variable, ok := sfntStyle(tt.data)
+		if weight != tt.weight || italic != tt.italic || variable != tt.variable || ok != tt.ok {
+			t.Errorf("%s: got %d %t %t %t", tt.name, weight, italic, variable, ok)
+		}
+	}
+}
+
+func TestFontFamiliesFromFSReadsOS2(t *testing.T) {
+	families, err := fontFamiliesFromFS(fstest.MapFS{
+		"Corp-Regular.ttf": {Data: sfntFont(400, 0, false)},
+		"Corp-Bold.ttf":    {Data: sfntFont(600, 1, false)},
+		"Corp-Unknown.ttf": {Data: []byte("not a font")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := families["Corp"]
+	if len(faces) != 3 {
+		t.Fatalf("expected 3 faces, got %d", len(faces))
+	}
+	// WalkDir visits the files in lexical order
+	if faces[0].Weight != 600 || !faces[0].Italic {
+		t.Errorf("expected the OS/2 table to win over the file name, got %+v", faces[0])
+	}
+	if faces[2].Weight != 0 || faces[2].Italic {
+		t.Errorf("expected the file name to be used without OS/2 table, got %+v", faces[2])
+	}
+}
+
+func TestFontFamiliesFromFSReadsName(t *testing.T) {
+	family := nameTable(sfntName{3, 1, 0x409, 1, "Open Sans"})
+	families, err := fontFamiliesFromFS(fstest.MapFS{
+		"OpenSans-Regular.ttf": {Data: sfntFont(400, 0, false, family)},
+		"OpenSans-Light.ttf": {Data: sfntFont(300, 0, false, nameTable(
+			sfntName{3, 1, 0x409, 1, "Open Sans Light"},
+			sfntName{3, 1, 0x409, 16, "Open Sans"},
+		))},
+		"OpenSans-Bold.ttf": {Data: sfntFont(700, 0, false)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families["Open Sans"]) != 2 {
+		t.Errorf("expected 2 faces of Open Sans, got %v", sortedKeys(families))
+	}
+	if len(families["OpenSans"]) != 1 {
+		t.Errorf("expected the file name to be used without name table, got %v", sortedKeys(families))
+	}
+}
+
+func TestAddFontFamilyErrors(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		family string
+		faces  []FontFace
+		want   string
+	}{
+		{"no faces", "Corp", nil, "no font faces"},
+		{"missing file", "Corp", []FontFace{{Filename: "/nonexistent/Corp-Regular.ttf"}}, "Corp-Regular.ttf"},
+		{"no regular", "Corp", []FontFace{{Data: []byte("x"), Weight: 700}}, "missing regular face"},
+		{"bad weight", "Corp", []FontFace{{Data: []byte("x"), Weight: 1000}}, "out of range"},
+		{"empty face", "Corp", []FontFace{{Weight: 400}}, "without file name or data"},
+		{"no name", "", []FontFace{{Data: []byte("x")}}, "without a name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := d.AddFontFamily(tt.family, tt.faces...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadFontsFromFSErrors(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.LoadFontsFromFS(fstest.MapFS{"readme.txt": {Data: []byte("no fonts")}}); err == nil {
+		t.Error("expected error for file system without fonts")
+	}
+	italicOnly := fstest.MapFS{"fonts/Corp-Italic.ttf": {Data: []byte("x")}}
+	if err := d.LoadFontsFromFS(italicOnly); err == nil || !strings.Contains(err.Error(), "missing regular face") {
+		t.Errorf("expected missing regular face error, got %v", err)
+	}
+}
+
+// systemFont returns the path of a TrueType font installed on the system or
+// skips the test.
+func systemFont(t *testing.T) string {
+	t.Helper()
+	var found string
+	for _, dir := range []string{"/usr/share/fonts", "/Library/Fonts", `C:\Windows\Fonts`} {
+		filepath.WalkDir(dir, func(p string, de os.DirEntry, err error) error {
+			if err == nil && found == "" && strings.EqualFold(filepath.Ext(p), ".ttf") {
+				found = p
+			}
+			return nil
+		})
+	}
+	if found == "" {
+		t.Skip("no TrueType font installed")
+	}
+	return found
+}
+
+func TestAddFontFamily(t *testing.T) {
+	font := systemFont(t)
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddFontFamily("Corporate", FontFace{Filename: font}, FontFace{Filename: font, Weight: 700}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`body { font-family: Corporate; }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>Regular and <b>bold</b></p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFontsFromFS(t *testing.T) {
+	font := systemFont(t)
+	data, err := os.ReadFile(font)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"fonts/Corp-Regular.ttf": {Data: data},
+		"fonts/Corp-Bold.ttf":    {Data: data},
+	}
+	tmpl := NewTemplate()
+	if err := tmpl.LoadFontsFromFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.AddCSS(`body { font-family: Corp; }`); err != nil {
+		t.Fatal(err)
+	}
+	d, err := tmpl.New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.fontFamilies["corp"] {
+		t.Error("expected font family Corp to be registered")
+	}
+	if err := d.RenderPages("<p>Corporate <b>font</b></p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
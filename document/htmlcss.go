@@ -0,0 +1,163 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// addDocumentCSS adds the stylesheets of the <style> and
+// <link rel="stylesheet"> elements in src in document order and returns src
+// without these elements. Stylesheets whose media attribute excludes print
+// are skipped. Linked stylesheets are read with the resource loader, or from
+// the file system relative to the base directory or the working directory,
+// and their relative URLs are resolved against the location of the
+// stylesheet.
+func (d *Document) addDocumentCSS(src string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	var elements []*html.Node
+	walkElements(doc, func(n *html.Node) {
+		switch {
+		case n.DataAtom == atom.Style:
+			elements = append(elements, n)
+		case n.DataAtom == atom.Link && isStylesheetLink(n):
+			elements = append(elements, n)
+		}
+	})
+	if len(elements) == 0 {
+		return src, nil
+	}
+	lines := styleLines(src)
+	styles := 0
+	for _, n := range elements {
+		if n.DataAtom == atom.Style {
+			styles++
+		}
+	}
+	if styles != len(lines) {
+		// the parser moved or dropped a <style> element
+		lines = nil
+	}
+	for _, n := range elements {
+		n.Parent.RemoveChild(n)
+		if !printMedia(attr(n, "media")) {
+			continue
+		}
+		if n.DataAtom == atom.Style {
+			line := 1
+			if len(lines) > 0 {
+				line, lines = lines[0], lines[1:]
+			}
+			if t := strings.ToLower(strings.TrimSpace(attr(n, "type"))); t != "" && t != "text/css" {
+				continue
+			}
+			css := textContent(n)
+			blocks := parseCSS(css)
+			shiftLines(blocks, line-1)
+			if err := d.addParsedCSS(blocks, stylesheet{css: css}); err != nil {
+				return "", err
+			}
+			continue
+		}
+		href := strings.TrimSpace(attr(n, "href"))
+		data, _, err := d.cfg.readResource(d.context(), href, "")
+		if err != nil {
+			return "", fmt.Errorf("<link href=%q>: %w", href, err)
+		}
+		dir := urlDir(href)
+		if d.cfg.loader == nil && d.cfg.baseDir != "" && !isAbsoluteURL(href) {
+			// readResource read the stylesheet from the base directory
+			dir = joinURL(d.cfg.baseDir, dir)
+		}
+		css := string(data)
+		if err := d.addParsedCSS(parseCSS(css), stylesheet{css: css, dir: dir, name: href}); err != nil {
+			return "", err
+		}
+	}
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// styleLines returns the line in src at which the content of each <style>
+// element starts.
+func styleLines(src string) []int {
+	var lines []int
+	line := 1
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return lines
+		}
+		line += strings.Count(string(z.Raw()), "\n")
+		if tt == html.StartTagToken {
+			if name, _ := z.TagName(); string(name) == "style" {
+				lines = append(lines, line)
+			}
+		}
+	}
+}
+
+// shiftLines adds offset to the line numbers of blocks, for CSS that starts
+// in the middle of an HTML document.
+func shiftLines(blocks []*cssBlock, offset int) {
+	for _, b := range blocks {
+		b.line += offset
+		for i := range b.decls {
+			b.decls[i].line += offset
+		}
+		shiftLines(b.children, offset)
+	}
+}
+
+// isStylesheetLink reports whether n is an enabled <link rel="stylesheet">.
+// Alternate stylesheets are not used.
+func isStylesheetLink(n *html.Node) bool {
+	if hasAttr(n, "disabled") || strings.TrimSpace(attr(n, "href")) == "" {
+		return false
+	}
+	stylesheet := false
+	for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+		switch rel {
+		case "stylesheet":
+			stylesheet = true
+		case "alternate":
+			return false
+		}
+	}
+	return stylesheet
+}
+
+// printMedia reports whether a media attribute such as "print",
+// "screen, print" or "only print and (color)" applies to print output. An
+// empty attribute applies to all media. Media features are not evaluated.
+func printMedia(media string) bool {
+	if strings.TrimSpace(media) == "" {
+		return true
+	}
+	for _, query := range strings.Split(strings.ToLower(media), ",") {
+		fields := strings.Fields(query)
+		not := false
+		if len(fields) > 0 && (fields[0] == "only" || fields[0] == "not") {
+			not = fields[0] == "not"
+			fields = fields[1:]
+		}
+		mediaType := "all"
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "(") {
+			mediaType = fields[0]
+		}
+		matches := mediaType == "all" || mediaType == "print"
+		if matches != not {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package document
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPrintMedia(t *testing.T) {
+	tests := map[string]bool{
+		"":                        true,
+		"all":                     true,
+		"print":                   true,
+		"screen":                  false,
+		"screen, print":           true,
+		"only print and (color)":  true,
+		"(min-width: 600px)":      true,
+		"not print":               false,
+		"not screen":              true,
+		"screen and (color), tv":  false,
+		"PRINT":                   true,
+		"speech, screen":          false,
+		"only screen, only print": true,
+	}
+	for media, want := range tests {
+		if got := printMedia(media); got != want {
+			t.Errorf("printMedia(%q) = %t, want %t", media, got, want)
+		}
+	}
+}
+
+func TestAddDocumentCSS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/print.css":  {Data: []byte(`h1 { background-image: url(img/bg.png) }`)},
+		"css/screen.css": {Data: []byte(`h1 { color: blue }`)},
+	}
+	d, err := New(tempPDF(t), WithResourceLoader(FSLoader(fsys)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.removeLocalFiles()
+	if err := d.AddCSS(`p { margin: 0 }`); err != nil {
+		t.Fatal(err)
+	}
+	fsys["css/img/bg.png"] = &fstest.MapFile{Data: []byte("png")}
+	src := `<html><head>
+<style>h1 { color: red }</style>
+<link rel="stylesheet" href="css/screen.css" media="screen">
+<link rel="alternate stylesheet" href="css/screen.css">
+</head><body><h1>Title</h1>
+<link rel="stylesheet" href="css/print.css" media="print">
+<style media="screen">h1 { color: green }</style>
+<style type="text/x-scss">h1 { color: $x }</style>
+</body></html>`
+	got, err := d.addDocumentCSS(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "<style") || strings.Count(got, "<link") != 1 {
+		t.Errorf("expected all but the alternate stylesheet to be removed, got\n%s", got)
+	}
+	if len(d.stylesheets) != 3 {
+		t.Fatalf("expected 3 stylesheets, got %d", len(d.stylesheets))
+	}
+	if !strings.Contains(d.stylesheets[1].css, "color: red") {
+		t.Errorf("expected <style> second, got %q", d.stylesheets[1].css)
+	}
+	bg := d.localFiles["css/img/bg.png"]
+	if bg == "" || !strings.Contains(d.stylesheets[2].css, bg) {
+		t.Errorf("expected linked stylesheet with resolved image last, got %q", d.stylesheets[2].css)
+	}
+
+	plain := "<p>No stylesheets</p>"
+	if got, err := d.addDocumentCSS(plain); err != nil || got != plain {
+		t.Errorf("expected HTML without stylesheets to be unchanged, got %q, %v", got, err)
+	}
+}
+
+func TestAddDocumentCSSMissingLink(t *testing.T) {
+	d, err := New(tempPDF(t), WithSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.addDocumentCSS(`<link rel="stylesheet" href="style.css">`); err == nil || !strings.Contains(err.Error(), "style.css") {
+		t.Errorf("expected error for the link, got %v", err)
+	}
+}
+
+func TestAddDocumentCSSLinkBaseDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "css", "print.css"), []byte(`h1 { background-image: url(img/bg.png) }`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	d, err := New(tempPDF(t), WithBaseDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.addDocumentCSS(`<link rel="stylesheet" href="css/print.css"><h1>Title</h1>`); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.ToSlash(filepath.Join(dir, "css", "img", "bg.png"))
+	if len(d.stylesheets) != 1 || !strings.Contains(d.stylesheets[0].css, want) {
+		t.Errorf("expected url() to be resolved against the base directory, got %q", d.stylesheets)
+	}
+}
@@ -0,0 +1,253 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenderTemplate executes the template name of tmpl with data and renders
+// the result with RenderPages. If name is empty, tmpl itself is executed.
+// Add TemplateFuncs to tmpl before parsing to use the PDF oriented helper
+// functions:
+//
+//	tmpl := template.Must(template.New("invoice").Funcs(document.TemplateFuncs()).ParseFiles("invoice.html"))
+//	err := d.RenderTemplate(tmpl, "invoice.html", invoice)
+func (d *Document) RenderTemplate(tmpl *template.Template, name string, data any) error {
+	return d.RenderTemplateContext(context.Background(), tmpl, name, data)
+}
+
+// RenderTemplateContext is RenderTemplate with a context, see
+// RenderPagesContext.
+func (d *Document) RenderTemplateContext(ctx context.Context, tmpl *template.Template, name string, data any) error {
+	var sb strings.Builder
+	var err error
+	if name == "" {
+		err = tmpl.Execute(&sb, data)
+	} else {
+		err = tmpl.ExecuteTemplate(&sb, name, data)
+	}
+	if err != nil {
+		return err
+	}
+	return d.RenderPagesContext(ctx, sb.String())
+}
+
+// TemplateFuncs returns functions for html/template that help with
+// invoices and reports. Numbers may be given as any Go integer or float
+// type, as a string or as a value with a Float64 method such as
+// json.Number.
+//
+//	{{ .Total | number 2 }}                1,234.50
+//	{{ .Total | formatNumber 2 "," "." }}  1.234,50
+//	{{ .Total | currency "EUR" }}          €1,234.50
+//	{{ .Date | date "02.01.2006" }}        date in Go layout syntax
+//	{{ pageBreak }}                        forces a page break
+//	{{ qrcode .URL "3cm" }}                QR code as inline SVG
+//	{{ barcode "ean13" .GTIN "4cm" }}      barcode as inline SVG
+//
+// number and formatNumber take 0 to 20 decimals and reject numbers given as
+// strings that are longer than 100 characters or have an exponent beyond
+// ±400, so that untrusted data cannot make them arbitrarily expensive.
+// date accepts a time.Time, a *time.Time or a string in RFC 3339 or
+// YYYY-MM-DD format. barcode supports "qr", "datamatrix", "code128",
+// "ean13" and "ean8"; the optional arguments of barcode and qrcode are the
+// width and the height as CSS lengths.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"number": func(decimals int, v any) (string, error) {
+			return formatNumber(decimals, ".", ",", v)
+		},
+		"formatNumber": formatNumber,
+		"currency":     formatCurrency,
+		"date":         formatDate,
+		"pageBreak": func() template.HTML {
+			return `<div style="page-break-after: always"></div>`
+		},
+		"qrcode": func(value string, size ...string) (template.HTML, error) {
+			return barcodeHTML("qr", value, size)
+		},
+		"barcode": func(kind, value string, size ...string) (template.HTML, error) {
+			return barcodeHTML(kind, value, size)
+		},
+	}
+}
+
+// barcodeHTML is the implementation of the barcode template function. size
+// holds the optional width and height.
+func barcodeHTML(kind, value string, size []string) (template.HTML, error) {
+	if len(size) > 2 {
+		return "", fmt.Errorf("barcode: too many arguments")
+	}
+	size = append(size, "", "")
+	svg, err := barcodeSVG(kind, value, size[0], size[1])
+	return template.HTML(svg), err
+}
+
+// Limits for the numbers of the template functions, which may come from
+// untrusted data.
+const (
+	maxNumberLength   = 100 // characters of a number given as a string
+	maxNumberExponent = 400 // beyond the range of float64
+	maxDecimals       = 20
+)
+
+// toDecimal converts a number passed to a template function. Strings and
+// json.Number values are read as decimal numbers, so "1.005" stays exactly
+// 1.005. They are limited to maxNumberLength characters and an exponent of
+// ±maxNumberExponent. Floats are converted by their shortest decimal
+// representation.
+func toDecimal(v any) (*big.Rat, error) {
+	var s string
+	switch n := v.(type) {
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("cannot format %v", n)
+		}
+		s = strconv.FormatFloat(n, 'g', -1, 64)
+	case float32:
+		if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+			return nil, fmt.Errorf("cannot format %v", n)
+		}
+		s = strconv.FormatFloat(float64(n), 'g', -1, 32)
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), nil
+	case int8:
+		return new(big.Rat).SetInt64(int64(n)), nil
+	case int16:
+		return new(big.Rat).SetInt64(int64(n)), nil
+	case int32:
+		return new(big.Rat).SetInt64(int64(n)), nil
+	case int64:
+		return new(big.Rat).SetInt64(n), nil
+	case uint:
+		return new(big.Rat).SetUint64(uint64(n)), nil
+	case uint8:
+		return new(big.Rat).SetUint64(uint64(n)), nil
+	case uint16:
+		return new(big.Rat).SetUint64(uint64(n)), nil
+	case uint32:
+		return new(big.Rat).SetUint64(uint64(n)), nil
+	case uint64:
+		return new(big.Rat).SetUint64(n), nil
+	case string:
+		s = strings.TrimSpace(n)
+	case interface{ Float64() (float64, error) }:
+		// json.Number
+		s = strings.TrimSpace(fmt.Sprint(n))
+	default:
+		return nil, fmt.Errorf("%v (%T) is not a number", v, v)
+	}
+	if len(s) > maxNumberLength {
+		return nil, fmt.Errorf("number with %d characters exceeds %d", len(s), maxNumberLength)
+	}
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		if exp < -maxNumberExponent || exp > maxNumberExponent {
+			return nil, fmt.Errorf("%q: exponent out of range ±%d", s, maxNumberExponent)
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.Contains(s, "/") {
+		return nil, fmt.Errorf("%q is not a number", s)
+	}
+	return r, nil
+}
+
+// formatNumber formats v with the given number of decimals, from 0 to
+// maxDecimals, decimal separator and thousands separator.
+func formatNumber(decimals int, decimalSep, thousandsSep string, v any) (string, error) {
+	if decimals < 0 || decimals > maxDecimals {
+		return "", fmt.Errorf("decimals %d out of range 0–%d", decimals, maxDecimals)
+	}
+	r, err := toDecimal(v)
+	if err != nil {
+		return "", err
+	}
+	// Round half away from zero as usual for amounts, in decimal so that
+	// 1.005 becomes 1.01.
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	num := new(big.Int).Abs(scaled.Num())
+	q, rem := new(big.Int).QuoRem(num, scaled.Denom(), new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(scaled.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	digits := q.String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	intPart, frac := digits[:len(digits)-decimals], digits[len(digits)-decimals:]
+	var sb strings.Builder
+	if r.Sign() < 0 && q.Sign() != 0 {
+		sb.WriteByte('-')
+	}
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			sb.WriteString(thousandsSep)
+		}
+		sb.WriteRune(c)
+	}
+	if frac != "" {
+		sb.WriteString(decimalSep + frac)
+	}
+	return sb.String(), nil
+}
+
+// currencySymbols are written in front of the amount instead of the code.
+var currencySymbols = map[string]string{
+	"EUR": "€", "USD": "$", "GBP": "£", "JPY": "¥", "INR": "₹",
+}
+
+// formatCurrency formats v as an amount in the currency with the ISO 4217
+// code, for example €1,234.50 or CHF 1,234.50.
+func formatCurrency(code string, v any) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	decimals := 2
+	switch code {
+	case "JPY", "KRW", "ISK", "CLP", "VND":
+		decimals = 0
+	case "BHD", "KWD", "OMR", "TND", "JOD":
+		decimals = 3
+	}
+	s, err := formatNumber(decimals, ".", ",", v)
+	if err != nil {
+		return "", err
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if sym, ok := currencySymbols[code]; ok {
+		return sign + sym + s, nil
+	}
+	return sign + code + " " + s, nil
+}
+
+// formatDate formats v with the Go time layout.
+func formatDate(layout string, v any) (string, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		if t == nil {
+			return "", nil
+		}
+		return t.Format(layout), nil
+	case string:
+		for _, l := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+			if parsed, err := time.Parse(l, strings.TrimSpace(t)); err == nil {
+				return parsed.Format(layout), nil
+			}
+		}
+		return "", fmt.Errorf("date: cannot parse %q", t)
+	}
+	return "", fmt.Errorf("date: %v (%T) is not a date", v, v)
+}
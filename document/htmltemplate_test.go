@@ -0,0 +1,133 @@
+package document
+
+import (
+	"encoding/json"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		decimals int
+		dec, sep string
+		v        any
+		want     string
+	}{
+		{2, ".", ",", 1234.5, "1,234.50"},
+		{0, ".", ",", 999, "999"},
+		{2, ",", ".", int64(-1234567), "-1.234.567,00"},
+		{1, ".", ",", "12.34", "12.3"},
+		{2, ".", "", json.Number("1000"), "1000.00"},
+		{2, ".", ",", -0.001, "0.00"},
+		{2, ".", ",", "1.005", "1.01"},
+		{2, ".", ",", json.Number("1.005"), "1.01"},
+		{2, ".", ",", "2.675", "2.68"},
+		{2, ".", ",", json.Number("2.675"), "2.68"},
+		{2, ".", ",", 2.675, "2.68"},
+		{2, ".", ",", "-2.675", "-2.68"},
+		{0, ".", ",", "-0.5", "-1"},
+		{0, ".", ",", json.Number("-2.5"), "-3"},
+		{1, ".", ",", "-0.04", "0.0"},
+		{2, ".", ",", " 1e3 ", "1,000.00"},
+		{2, ".", ",", uint64(18446744073709551615), "18,446,744,073,709,551,615.00"},
+	}
+	for _, tt := range tests {
+		got, err := formatNumber(tt.decimals, tt.dec, tt.sep, tt.v)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.v, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.v, tt.want, got)
+		}
+	}
+	for _, v := range []any{"abc", "1/3", "NaN", json.Number(""), true, "1e1000000000", json.Number("1e-401"), "1e", "1e+x", strings.Repeat("9", 101)} {
+		if _, err := formatNumber(2, ".", ",", v); err == nil {
+			t.Errorf("%v: expected error", v)
+		}
+	}
+	if got, err := formatNumber(0, ".", "", json.Number("1E+3")); err != nil || got != "1000" {
+		t.Errorf("expected exponent within range to work, got %q, %v", got, err)
+	}
+	for _, decimals := range []int{-1, 21, 1000000000} {
+		if _, err := formatNumber(decimals, ".", ",", 1); err == nil {
+			t.Errorf("decimals %d: expected error", decimals)
+		}
+	}
+}
+
+func TestFormatCurrency(t *testing.T) {
+	tests := []struct {
+		code string
+		v    any
+		want string
+	}{
+		{"EUR", 1234.5, "€1,234.50"},
+		{"usd", -3, "-$3.00"},
+		{"CHF", 10.125, "CHF 10.13"},
+		{"JPY", 1500.4, "¥1,500"},
+	}
+	for _, tt := range tests {
+		got, err := formatCurrency(tt.code, tt.v)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.code, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %v: expected %q, got %q", tt.code, tt.v, tt.want, got)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	d := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	for _, v := range []any{d, &d, "2024-03-09", "2024-03-09T00:00:00Z"} {
+		got, err := formatDate("02.01.2006", v)
+		if err != nil || got != "09.03.2024" {
+			t.Errorf("%v: expected 09.03.2024, got %q, %v", v, got, err)
+		}
+	}
+	if _, err := formatDate("2006", 42); err == nil {
+		t.Error("expected error for int")
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tmpl := template.Must(template.New("t").Funcs(TemplateFuncs()).Parse(
+		`<p>{{ .Total | currency "EUR" }}</p>{{ pageBreak }}{{ qrcode .URL "2cm" }}`))
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, map[string]any{"Total": 12.5, "URL": "https://example.com/<x>"}); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"<p>€12.50</p>",
+		`<div style="page-break-after: always"></div>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="2cm" height="2cm"`,
+		"<title>https://example.com/&lt;x&gt;</title>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output %q", want, out)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(template.New("doc").Funcs(TemplateFuncs()).Parse(
+		`{{ define "body" }}<h1>{{ .Title }}</h1><p>{{ .Total | number 2 }}</p>{{ end }}`))
+	if err := d.RenderTemplate(tmpl, "body", map[string]any{"Title": "Invoice", "Total": 99}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderTemplate(tmpl, "missing", nil); err == nil {
+		t.Error("expected error for undefined template")
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,150 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// InsertPDF appends pages of the PDF read from r to the document, for
+// example static terms and conditions after a generated cover letter. See
+// InsertPDFFile.
+func (d *Document) InsertPDF(r io.Reader, pages string) error {
+	return d.InsertPDFContext(context.Background(), r, pages)
+}
+
+// InsertPDFContext is InsertPDF with a context, see InsertPDFFileContext.
+func (d *Document) InsertPDFContext(ctx context.Context, r io.Reader, pages string) error {
+	if d.aborted != nil {
+		return d.aborted
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fn, err := d.tempFile(data, ".pdf")
+	if err != nil {
+		return err
+	}
+	return d.InsertPDFFileContext(ctx, fn, pages)
+}
+
+// InsertPDFFile appends pages of the PDF file filename to the document.
+// pages selects the pages as a comma separated list of page numbers and
+// ranges such as "1-3,5,8-"; an empty string selects all pages. Each
+// imported page becomes a page of its own with the size of the original.
+//
+// Pages are inserted in the order of the calls, so InsertPDFFile before
+// RenderPages puts the pages in front of the rendered HTML and afterwards
+// behind it. A page filled with OutputAt is finished first, so the inserted
+// pages follow it. Inserted pages count for page numbers in Headings, the
+// table of contents, cross-references and OutputAtPage as well as for
+// WithMaxPages. Page templates, overlays and PageInitCallback do not apply
+// to them. Do not call OutputAt after InsertPDFFile.
+func (d *Document) InsertPDFFile(filename, pages string) error {
+	return d.InsertPDFFileContext(context.Background(), filename, pages)
+}
+
+// InsertPDFFileContext is InsertPDFFile with a context. Cancellation is
+// checked before every page. When ctx is done, the document is aborted as
+// described for RenderPagesContext.
+func (d *Document) InsertPDFFileContext(ctx context.Context, filename, pages string) error {
+	if d.aborted != nil {
+		return d.aborted
+	}
+	if err := ctx.Err(); err != nil {
+		return d.abort(err)
+	}
+	f, err := d.Frontend.Doc.LoadImageFile(filename)
+	if err != nil {
+		return err
+	}
+	nums, err := parsePageRange(pages, f.NumberOfPages)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	count := d.pageCount
+	open := !d.pagesRendered && d.Frontend.Doc.CurrentPage != nil
+	if open {
+		// the pages of OutputAt mode
+		count += d.manualPages + 1
+	}
+	if max := d.cfg.limits.maxPages; max > 0 && count+len(nums) > max {
+		return pageLimitError(max)
+	}
+	if open {
+		d.Frontend.Doc.CurrentPage.Shipout()
+		d.pageCount = count
+	}
+	d.pagesRendered = true
+	for _, n := range nums {
+		if err := ctx.Err(); err != nil {
+			return d.abort(err)
+		}
+		img := d.Frontend.Doc.CreateImage(f, n, "/MediaBox")
+		imgNode := node.NewImage()
+		imgNode.Img = img
+		imgNode.Width = img.Width
+		imgNode.Height = img.Height
+		page := d.Frontend.Doc.NewPage()
+		page.Width = img.Width
+		page.Height = img.Height
+		page.OutputAt(0, img.Height, node.Vpack(imgNode))
+		page.Shipout()
+		d.pageCount++
+	}
+	return nil
+}
+
+// parsePageRange returns the page numbers selected by spec in a document
+// with n pages. spec is a comma separated list of page numbers and ranges
+// "a-b", where a missing a or b means the first or last page. An empty spec
+// selects all pages.
+func parsePageRange(spec string, n int) ([]int, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = "1-"
+	}
+	var pages []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := pageNumber(from, 1, isRange)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		last := first
+		if isRange {
+			if last, err = pageNumber(to, n, true); err != nil {
+				return nil, fmt.Errorf("invalid page range %q", part)
+			}
+		}
+		if first > last {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		if last > n {
+			return nil, fmt.Errorf("page %d requested, the file has %d pages", last, n)
+		}
+		for p := first; p <= last; p++ {
+			pages = append(pages, p)
+		}
+	}
+	return pages, nil
+}
+
+// pageNumber parses a page number of a page range. An empty string returns
+// def if open ends are allowed.
+func pageNumber(s string, def int, open bool) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" && open {
+		return def, nil
+	}
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 1 {
+		return 0, strconv.ErrSyntax
+	}
+	return p, nil
+}
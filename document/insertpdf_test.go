@@ -0,0 +1,246 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/bagme/internal/pdfread"
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+func TestParsePageRange(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int
+	}{
+		{"", []int{1, 2, 3, 4, 5}},
+		{"2", []int{2}},
+		{"1-3", []int{1, 2, 3}},
+		{"4-", []int{4, 5}},
+		{"-2", []int{1, 2}},
+		{"5, 1-2", []int{5, 1, 2}},
+	}
+	for _, tt := range tests {
+		got, err := parsePageRange(tt.spec, 5)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.spec, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+	for _, spec := range []string{"0", "6", "3-2", "a", "1-x", "1,,2", "2-9"} {
+		if _, err := parsePageRange(spec, 5); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestInsertPDF(t *testing.T) {
+	cover := letterheadPDF(t)
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPDFFile(cover, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<h1>Terms</h1><p>Generated terms.</p>`); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPDF(bytes.NewReader(data), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if d.pageCount != 3 {
+		t.Errorf("expected 3 pages, got %d", d.pageCount)
+	}
+	hs := d.Headings()
+	if len(hs) != 1 || hs[0].PageNumber != 2 {
+		t.Errorf("expected the heading on page 2, got %+v", hs)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertPDFErrors(t *testing.T) {
+	cover := letterheadPDF(t)
+	d, err := New(tempPDF(t), WithMaxPages(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPDFFile(cover, "2"); err == nil || !strings.Contains(err.Error(), "page 2 requested") {
+		t.Errorf("expected page range error, got %v", err)
+	}
+	if err := d.InsertPDFFile(cover, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPDFFile(cover, "1"); !errors.Is(err, ErrPageLimitExceeded) {
+		t.Errorf("expected ErrPageLimitExceeded, got %v", err)
+	}
+	if err := d.InsertPDFFile(tempPDF(t), ""); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+// pdfPageWidths returns the widths of the MediaBoxes of the pages of the PDF
+// file filename in page order.
+func pdfPageWidths(t *testing.T, filename string) []float64 {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := pdfread.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := f.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var widths []float64
+	var walk func(node pdfread.Dict, box pdfread.Array)
+	walk = func(node pdfread.Dict, box pdfread.Array) {
+		if b, ok := f.Resolve(node["MediaBox"]).(pdfread.Array); ok {
+			box = b
+		}
+		if node["Type"] == pdfread.Name("Page") {
+			if len(box) != 4 {
+				t.Fatal("page without MediaBox")
+			}
+			widths = append(widths, pdfNumber(f.Resolve(box[2]))-pdfNumber(f.Resolve(box[0])))
+			return
+		}
+		kids, _ := f.Resolve(node["Kids"]).(pdfread.Array)
+		for _, k := range kids {
+			if kid, ok := f.Resolve(k).(pdfread.Dict); ok {
+				walk(kid, box)
+			}
+		}
+	}
+	root, _ := f.Resolve(catalog["Pages"]).(pdfread.Dict)
+	walk(root, nil)
+	return widths
+}
+
+// pdfNumber returns a PDF integer or real as float64.
+func pdfNumber(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// a5PDF returns the file name of a one page PDF in A5 format.
+func a5PDF(t *testing.T) string {
+	t.Helper()
+	filename := tempPDF(t)
+	d, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page { size: A5; }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<p>Terms and conditions</p>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestInsertPDFOrder(t *testing.T) {
+	terms := a5PDF(t)
+	filename := tempPDF(t)
+	d, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OutputAt("<p>Cover letter</p>", bag.MustSP("10cm"), bag.MustSP("2cm"), bag.MustSP("26cm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPDFFile(terms, ""); err != nil {
+		t.Fatal(err)
+	}
+	if d.pageCount != 2 {
+		t.Errorf("expected 2 pages, got %d", d.pageCount)
+	}
+	if err := d.RenderPages("<p>Appendix</p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	// A4 cover letter, A5 terms, A4 appendix
+	widths := pdfPageWidths(t, filename)
+	if len(widths) != 3 || widths[0] < 590 || widths[1] > 425 || widths[2] < 590 {
+		t.Errorf("expected pages of width A4, A5, A4, got %v", widths)
+	}
+}
+
+func TestInsertPDFPageNumbers(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPDFFile(letterheadPDF(t), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`h1 { page-break-before: always; }`); err != nil {
+		t.Fatal(err)
+	}
+	out, err := d.insertTOC(tocHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pages := tocRows(t, out, "bag-toc"); strings.Join(pages, "|") != "3|3|3|4" {
+		t.Errorf("expected TOC pages 3|3|3|4 after the inserted page, got %v", pages)
+	}
+	if err := d.RenderPages(tocHTML); err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]int{}
+	for _, h := range d.Headings() {
+		pages[h.Text] = h.PageNumber
+	}
+	if pages["Introduction"] != 3 || pages["Appendix"] != 4 {
+		t.Errorf("expected Introduction on page 3 and Appendix on page 4, got %v", pages)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertPDFContext(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.InsertPDFFileContext(ctx, letterheadPDF(t), ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if d.pageCount != 0 {
+		t.Errorf("expected no inserted page, got %d", d.pageCount)
+	}
+	if err := d.Finish(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the document to be aborted, got %v", err)
+	}
+}
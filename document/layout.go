@@ -0,0 +1,224 @@
+package document
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxLayoutPasses limits the number of layout passes of the table of
+// contents and cross-references. Page numbers usually settle after two
+// passes; they only move again if generated text pushes content onto
+// another page.
+const maxLayoutPasses = 5
+
+// stylesheet is CSS added to the document. dir is the directory relative
+// URLs are resolved against, name the file name used in diagnostics.
+// Documents record the CSS they pass to the builder, with fonts loaded and
+// URLs resolved, so that layout passes can replay it.
+type stylesheet struct {
+	css  string
+	dir  string
+	name string
+}
+
+// layoutPass renders html into a throw-away document that has the same
+// stylesheets as d and returns the headings recorded on the way. Layout
+// passes are used to find out on which page content ends up before the
+// final rendering. Callbacks are not invoked during a layout pass. The page
+// numbers are shifted by the pages that d already has, so they match the
+// final rendering.
+func (d *Document) layoutPass(html string) ([]HeadingEntry, error) {
+	scratch, err := d.scratchPass(html)
+	if err != nil {
+		return nil, err
+	}
+	entries := scratch.Headings()
+	for i := range entries {
+		entries[i].PageNumber += d.pageCount
+	}
+	return entries, nil
+}
+
+// pageCountPass returns the number of pages html needs.
+func (d *Document) pageCountPass(html string) (int, error) {
+	scratch, err := d.scratchPass(html)
+	if err != nil {
+		return 0, err
+	}
+	return scratch.pageCount - d.pageCount, nil
+}
+
+// scratchPass renders html into a throw-away document with the fonts and
+// stylesheets of d and returns that document.
+func (d *Document) scratchPass(html string) (*Document, error) {
+	scratch, err := d.newScratch()
+	if err != nil {
+		return nil, err
+	}
+	if err := scratch.renderPages(html); err != nil {
+		return nil, err
+	}
+	return scratch, nil
+}
+
+// newScratch returns a throw-away document for a layout pass with the
+// fonts, stylesheets and page styles of d.
+func (d *Document) newScratch() (*Document, error) {
+	if err := d.context().Err(); err != nil {
+		return nil, err
+	}
+	scratch, err := NewWriter(io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	scratch.ctx = d.ctx
+	// Number the pages like the final rendering, so that :first, :left
+	// and :right select the same page styles.
+	scratch.pageCount = d.pageCount
+	scratch.cfg.limits.maxPages = d.cfg.limits.maxPages
+	scratch.SetGenerateOutline(false)
+	for _, ff := range d.fonts {
+		if err := scratch.registerFontFamily(ff); err != nil {
+			return nil, err
+		}
+	}
+	for _, ss := range d.stylesheets {
+		if err := scratch.addCSS(ss.css, ss.dir); err != nil {
+			return nil, err
+		}
+	}
+	scratch.pages = pageStyles{rules: d.pages.rules, props: d.pages.props, boxes: d.pages.boxes, selected: d.pages.selected, running: d.pages.running, masters: d.pages.masters}
+	return scratch, nil
+}
+
+// insertGenerated inserts the table of contents and the cross-references
+// into src. The page numbers of each depend on the text the other one
+// generates, so if src has both, they are repeated until the HTML no
+// longer changes.
+func (d *Document) insertGenerated(src string) (string, error) {
+	both := strings.Contains(src, tocAttr) && len(d.xrefs) > 0
+	for pass := 1; ; pass++ {
+		out, err := d.insertTOC(src)
+		if err != nil {
+			return "", err
+		}
+		if out, err = d.resolveXrefs(out); err != nil {
+			return "", err
+		}
+		if !both || out == src || pass >= maxLayoutPasses {
+			return out, nil
+		}
+		src = out
+	}
+}
+
+// Probes are tiny headings inserted in front of elements that are not
+// headings themselves. Headings are the only elements whose page the
+// renderer records, so a probe reveals the page of the element after it.
+const (
+	probeAttr  = "data-bag-probe"
+	probeStyle = "font-size: 0.1pt; line-height: 0.1pt; margin: 0; padding: 0; border: 0; page-break-after: avoid"
+)
+
+// anchorPass runs a layout pass of doc and returns the page number of every
+// element with an id. doc is left unchanged.
+func (d *Document) anchorPass(doc *html.Node) (map[string]int, error) {
+	var targets []*html.Node
+	walkElements(doc, func(n *html.Node) {
+		if attr(n, "id") != "" && headingLevel(n) == 0 && inBody(n) {
+			targets = append(targets, n)
+		}
+	})
+	probes := make([]*html.Node, 0, len(targets))
+	for _, n := range targets {
+		id := attr(n, "id")
+		probe := newElement(atom.H6, probeAttr, id, "style", probeStyle)
+		probe.AppendChild(&html.Node{Type: html.TextNode, Data: fmt.Sprintf("bag-probe-%d", len(probes))})
+		block := flowAncestor(n)
+		block.Parent.InsertBefore(probe, block)
+		probes = append(probes, probe)
+	}
+	defer func() {
+		for _, p := range probes {
+			p.Parent.RemoveChild(p)
+		}
+	}()
+	hs := collectHeadings(doc, nil)
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return nil, err
+	}
+	entries, err := d.layoutPass(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	pages := headingPages(hs, entries)
+	anchors := make(map[string]int)
+	for i, h := range hs {
+		id := attr(h.node, probeAttr)
+		if id == "" {
+			id = attr(h.node, "id")
+		}
+		if id != "" && pages[i] > 0 {
+			anchors[id] = pages[i]
+		}
+	}
+	return anchors, nil
+}
+
+// recordHeadingAnchors stores the page numbers of all headings with an id
+// in d.anchors. entries are the headings recorded while rendering src.
+func (d *Document) recordHeadingAnchors(src string, entries []HeadingEntry) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return
+	}
+	hs := collectHeadings(doc, nil)
+	for i, page := range headingPages(hs, entries) {
+		if id := attr(hs[i].node, "id"); id != "" && page > 0 {
+			d.anchors[id] = page
+		}
+	}
+}
+
+// flowContainers are elements whose children are laid out as a sequence of
+// blocks, so a probe can be inserted between them.
+var flowContainers = map[atom.Atom]bool{
+	atom.Body: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Aside: true, atom.Header: true, atom.Footer: true,
+	atom.Nav: true, atom.Blockquote: true, atom.Li: true, atom.Td: true,
+	atom.Th: true, atom.Figure: true,
+}
+
+// flowAncestor returns n or its closest ancestor whose parent is a flow
+// container.
+func flowAncestor(n *html.Node) *html.Node {
+	for n.Parent != nil && n.Parent.Type == html.ElementNode && !flowContainers[n.Parent.DataAtom] {
+		n = n.Parent
+	}
+	return n
+}
+
+// inBody reports whether n is a descendant of the body element.
+func inBody(n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Body {
+			return true
+		}
+	}
+	return false
+}
+
+// walkElements calls fn for every element below n in tree order.
+func walkElements(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkElements(c, fn)
+	}
+}
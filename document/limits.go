@@ -0,0 +1,189 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Errors returned when a document exceeds one of its limits. The returned
+// errors wrap these values and can be tested with errors.Is.
+var (
+	ErrPageLimitExceeded = errors.New("page limit exceeded")
+	ErrImageTooLarge     = errors.New("image too large")
+	ErrDOMDepthExceeded  = errors.New("DOM nesting too deep")
+	ErrInputTooLarge     = errors.New("input too large")
+)
+
+// limits bounds the resources used for untrusted input. Zero means no
+// limit.
+type limits struct {
+	maxPages      int
+	maxImageBytes int64
+	maxDOMDepth   int
+	maxInputSize  int64
+}
+
+// WithMaxPages limits the number of pages of the document. RenderPages
+// stops with ErrPageLimitExceeded when content would need more pages; the
+// document is then aborted as described for RenderPagesContext. NewPage
+// and InsertPDF return the same error.
+func WithMaxPages(n int) Option {
+	return func(c *config) { c.limits.maxPages = n }
+}
+
+// WithMaxImageBytes limits the size of each image referenced by <img>
+// elements or CSS url() values to n bytes, whether it is read from the file
+// system or fetched by the resource loader. Larger images make RenderPages,
+// OutputAt and the CSS methods fail with ErrImageTooLarge, as do image
+// files that cannot be found. Stylesheets and fonts are not limited.
+func WithMaxImageBytes(n int64) Option {
+	return func(c *config) { c.limits.maxImageBytes = n }
+}
+
+// WithMaxDOMDepth limits the nesting depth of HTML elements passed to
+// RenderPages and OutputAt. Deeper documents fail with ErrDOMDepthExceeded.
+func WithMaxDOMDepth(n int) Option {
+	return func(c *config) { c.limits.maxDOMDepth = n }
+}
+
+// WithMaxInputSize limits the size of the HTML passed to RenderPages and
+// OutputAt to n bytes. Larger input fails with ErrInputTooLarge before it
+// is parsed.
+func WithMaxInputSize(n int64) Option {
+	return func(c *config) { c.limits.maxInputSize = n }
+}
+
+// pageLimitError returns the error for a document with more than max pages.
+func pageLimitError(max int) error {
+	return fmt.Errorf("%w: more than %d pages", ErrPageLimitExceeded, max)
+}
+
+// checkInput applies the input size and nesting depth limits to src, which
+// is an HTML fragment if fragment is true. Images are checked by
+// prepareResources and applyCSS.
+func (d *Document) checkInput(src string, fragment bool) error {
+	l := d.cfg.limits
+	if l.maxInputSize > 0 && int64(len(src)) > l.maxInputSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrInputTooLarge, len(src), l.maxInputSize)
+	}
+	if l.maxDOMDepth == 0 {
+		return nil
+	}
+	var nodes []*html.Node
+	if fragment {
+		body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		ns, err := html.ParseFragment(strings.NewReader(src), body)
+		if err != nil {
+			return err
+		}
+		nodes = ns
+	} else {
+		doc, err := html.Parse(strings.NewReader(src))
+		if err != nil {
+			return err
+		}
+		nodes = []*html.Node{doc}
+	}
+	// Walk without recursion, the tree may be arbitrarily deep.
+	type entry struct {
+		n     *html.Node
+		depth int
+	}
+	var stack []entry
+	for i := len(nodes) - 1; i >= 0; i-- {
+		stack = append(stack, entry{nodes[i], 0})
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		depth := e.depth
+		if e.n.Type == html.ElementNode {
+			depth++
+			if l.maxDOMDepth > 0 && depth > l.maxDOMDepth {
+				return fmt.Errorf("%w: <%s> at depth %d, limit %d", ErrDOMDepthExceeded, e.n.Data, depth, l.maxDOMDepth)
+			}
+		}
+		for c := e.n.LastChild; c != nil; c = c.PrevSibling {
+			stack = append(stack, entry{c, depth})
+		}
+	}
+	return nil
+}
+
+// checkImageFile returns ErrImageTooLarge if the image file, file: URL or
+// data: URI ref is larger than max bytes, and an error if the file cannot
+// be found. ref must already be resolved against the base directory. URLs
+// with other schemes are left to the renderer.
+func checkImageFile(ref string, max int64) error {
+	var size int64
+	switch {
+	case ref == "" || strings.HasPrefix(ref, "#"):
+		return nil
+	case strings.HasPrefix(strings.ToLower(ref), "data:"):
+		_, payload, _ := strings.Cut(ref, ",")
+		size = int64(len(payload))
+		if strings.Contains(strings.ToLower(ref[:len(ref)-len(payload)]), ";base64") {
+			size = size * 3 / 4
+		}
+	case hasScheme(ref):
+		u, err := url.Parse(ref)
+		if err != nil || u.Scheme != "file" {
+			return nil
+		}
+		if size, err = fileSize(filepath.FromSlash(u.Path)); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if size, err = fileSize(ref); err != nil {
+			return err
+		}
+	}
+	if size > max {
+		return fmt.Errorf("%w: %s has %d bytes, limit %d", ErrImageTooLarge, shortRef(ref), size, max)
+	}
+	return nil
+}
+
+// fileSize returns the size of the file name.
+func fileSize(name string) (int64, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, fmt.Errorf("image size limit: %w", err)
+	}
+	return fi.Size(), nil
+}
+
+// checkBlockImages applies the image size limit to the url() references in
+// the declarations of blocks, which are resolved against the base directory.
+func checkBlockImages(blocks []*cssBlock, max int64) error {
+	check := func(ref string) (string, error) {
+		return ref, checkImageFile(ref, max)
+	}
+	for _, b := range blocks {
+		for _, decl := range b.decls {
+			if _, err := mapURLs(decl.value, check); err != nil {
+				return err
+			}
+		}
+		if err := checkBlockImages(b.children, max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shortRef shortens data: URIs for error messages.
+func shortRef(ref string) string {
+	if len(ref) > 64 {
+		return ref[:61] + "..."
+	}
+	return ref
+}
@@ -0,0 +1,175 @@
+package document
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckInput(t *testing.T) {
+	deep := strings.Repeat("<div>", 10) + "x" + strings.Repeat("</div>", 10)
+	tests := []struct {
+		name     string
+		limits   limits
+		src      string
+		fragment bool
+		want     error
+	}{
+		{"input ok", limits{maxInputSize: 100}, "<p>short</p>", false, nil},
+		{"input too large", limits{maxInputSize: 10}, "<p>too long</p>", false, ErrInputTooLarge},
+		{"fragment depth ok", limits{maxDOMDepth: 10}, deep, true, nil},
+		{"fragment too deep", limits{maxDOMDepth: 9}, deep, true, ErrDOMDepthExceeded},
+		{"document depth counts html and body", limits{maxDOMDepth: 11}, deep, false, ErrDOMDepthExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Document{cfg: config{limits: tt.limits}}
+			err := d.checkInput(tt.src, tt.fragment)
+			if tt.want == nil && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMaxImageBytesFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "big.png"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "small.png"), make([]byte, 5), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bigURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(make([]byte, 100))
+	tests := []struct {
+		name string
+		src  string
+		want error
+	}{
+		{"image ok", `<img src="small.png">`, nil},
+		{"image relative to base dir too large", `<img src="big.png">`, ErrImageTooLarge},
+		{"style attribute too large", `<div style="background-image: url(big.png)">x</div>`, ErrImageTooLarge},
+		{"absolute path too large", `<img src="` + filepath.Join(dir, "big.png") + `">`, ErrImageTooLarge},
+		{"data URI too large", `<img src="` + bigURI + `">`, ErrImageTooLarge},
+		{"missing image", `<img src="missing.png">`, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Document{cfg: config{baseDir: dir, limits: limits{maxImageBytes: 10}}}
+			_, err := d.prepareResources(tt.src, true)
+			if tt.want == nil && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	d, err := NewWriter(io.Discard, WithBaseDir(dir), WithMaxImageBytes(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`li { list-style-image: url(small.png) }`); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := d.AddCSS(`body { background-image: url(big.png) }`); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge for CSS url(), got %v", err)
+	}
+	if err := d.AddCSS(`@page :first { background-image: url("big.png") }`); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge for @page url(), got %v", err)
+	}
+}
+
+func TestMaxImageBytesLoader(t *testing.T) {
+	cfg := config{limits: limits{maxImageBytes: 4}}
+	cfg.loader = func(ctx context.Context, url string) (io.ReadCloser, string, error) {
+		return io.NopCloser(strings.NewReader(url)), "", nil
+	}
+	if _, _, err := cfg.readImage(context.Background(), "ok", ""); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, _, err := cfg.readImage(context.Background(), "too large", ""); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge, got %v", err)
+	}
+	if data, _, err := cfg.readResource(context.Background(), "large font", ""); err != nil || string(data) != "large font" {
+		t.Errorf("expected other resources not to be limited, got %q, %v", data, err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "big.png"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg = config{limits: limits{maxImageBytes: 4}}
+	if _, _, err := cfg.readImage(context.Background(), "big.png", dir); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge for a file, got %v", err)
+	}
+	if _, _, err := cfg.readResource(context.Background(), "big.png", dir); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMaxPages(t *testing.T) {
+	d, err := New(tempPDF(t), WithMaxPages(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := strings.Repeat(`<p style="page-break-after: always">Page</p>`, 10)
+	if err := d.RenderPages(html); !errors.Is(err, ErrPageLimitExceeded) {
+		t.Fatalf("expected ErrPageLimitExceeded, got %v", err)
+	}
+	if d.pageCount != 4 {
+		t.Errorf("expected rendering to stop at page 4, got %d", d.pageCount)
+	}
+	if err := d.Finish(); !errors.Is(err, ErrPageLimitExceeded) {
+		t.Errorf("expected Finish to fail with ErrPageLimitExceeded, got %v", err)
+	}
+
+	d, err = New(tempPDF(t), WithMaxPages(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<p style="page-break-after: always">One</p><p>Two</p>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxPagesNewPage(t *testing.T) {
+	d, err := New(tempPDF(t), WithMaxPages(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.NewPage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.NewPage(); !errors.Is(err, ErrPageLimitExceeded) {
+		t.Errorf("expected ErrPageLimitExceeded, got %v", err)
+	}
+}
+
+func TestMaxInputSize(t *testing.T) {
+	d, err := New(tempPDF(t), WithMaxInputSize(16), WithMaxDOMDepth(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>" + strings.Repeat("x", 100) + "</p>"); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge, got %v", err)
+	}
+	if err := d.OutputAt("<p>"+strings.Repeat("x", 100)+"</p>", 0, 0, 0); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge from OutputAt, got %v", err)
+	}
+	if err := d.RenderPages("<p>ok</p>"); err != nil {
+		t.Errorf("expected document to stay usable, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package document
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// WithHTMLMetadata makes RenderPages fill empty Document metadata fields
+// from the HTML head: Title from <title>, Author from <meta name="author">,
+// Keywords from <meta name="keywords">, Subject from
+// <meta name="description"> and Language from <html lang>. Values set in Go
+// take precedence over the HTML. This is especially useful together with
+// WithPDFUA() or WithPDFUA2(), where title and language are mandatory.
+func WithHTMLMetadata() Option {
+	return func(c *config) { c.htmlMetadata = true }
+}
+
+// htmlMeta holds the metadata found in an HTML document.
+type htmlMeta struct {
+	title    string
+	author   string
+	keywords string
+	subject  string
+	language string
+}
+
+// readHTMLMeta extracts the document metadata from the HTML source.
+func readHTMLMeta(src string) (htmlMeta, error) {
+	var m htmlMeta
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return m, err
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Html:
+				m.language = strings.TrimSpace(attr(n, "lang"))
+			case atom.Title:
+				if m.title == "" {
+					m.title = strings.Join(strings.Fields(textContent(n)), " ")
+				}
+			case atom.Meta:
+				content := strings.TrimSpace(attr(n, "content"))
+				switch strings.ToLower(attr(n, "name")) {
+				case "author":
+					m.author = content
+				case "keywords":
+					m.keywords = content
+				case "description":
+					m.subject = content
+				}
+			case atom.Body:
+				// metadata lives in the head
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return m, nil
+}
+
+// applyHTMLMeta copies the metadata from the HTML source into all Document
+// fields that have not been set in Go.
+func (d *Document) applyHTMLMeta(src string) error {
+	m, err := readHTMLMeta(src)
+	if err != nil {
+		return err
+	}
+	setIfEmpty(&d.Title, m.title)
+	setIfEmpty(&d.Author, m.author)
+	setIfEmpty(&d.Keywords, m.keywords)
+	setIfEmpty(&d.Subject, m.subject)
+	setIfEmpty(&d.Language, m.language)
+	return nil
+}
+
+func setIfEmpty(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// attr returns the value of the attribute key or the empty string.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns the concatenated text of n and its descendants.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
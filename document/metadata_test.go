@@ -0,0 +1,74 @@
+package document
+
+import "testing"
+
+const metaHTML = `<!DOCTYPE html>
+<html lang="de">
+<head>
+  <title>  Annual
+  Report </title>
+  <meta name="author" content="Jane Doe">
+  <meta name="Keywords" content="report,finance">
+  <meta name="description" content="Figures for 2024">
+</head>
+<body><h1>Report</h1><p>Text</p></body>
+</html>`
+
+func TestReadHTMLMeta(t *testing.T) {
+	m, err := readHTMLMeta(metaHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := htmlMeta{
+		title:    "Annual Report",
+		author:   "Jane Doe",
+		keywords: "report,finance",
+		subject:  "Figures for 2024",
+		language: "de",
+	}
+	if m != want {
+		t.Errorf("expected %+v, got %+v", want, m)
+	}
+}
+
+func TestWithHTMLMetadata(t *testing.T) {
+	d, err := New(tempPDF(t), WithHTMLMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Author = "Explicit Author"
+	if err := d.RenderPages(metaHTML); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Title != "Annual Report" {
+		t.Errorf("expected title from <title>, got %q", d.Title)
+	}
+	if d.Author != "Explicit Author" {
+		t.Errorf("explicit author should win, got %q", d.Author)
+	}
+	if d.Language != "de" {
+		t.Errorf("expected language from <html lang>, got %q", d.Language)
+	}
+	if d.Frontend.Doc.Subject != "Figures for 2024" {
+		t.Errorf("expected subject in PDF, got %q", d.Frontend.Doc.Subject)
+	}
+}
+
+func TestHTMLMetadataOffByDefault(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(metaHTML); err != nil {
+		t.Fatal(err)
+	}
+	if d.Title != "" {
+		t.Errorf("expected no title without WithHTMLMetadata, got %q", d.Title)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,161 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// Page selectors for OutputAtPage besides page numbers.
+const (
+	// EveryPage places the overlay on every page.
+	EveryPage = 0
+	// LastPage places the overlay on the last page. -2 selects the page
+	// before the last page and so on.
+	LastPage = -1
+)
+
+// overlay is an HTML fragment placed at a fixed position on pages produced
+// by RenderPages.
+type overlay struct {
+	page   int // page number, EveryPage or counted from the end if negative
+	html   string
+	width  bag.ScaledPoint
+	x, y   bag.ScaledPoint
+	vlist  *node.VList // built by RenderPages
+	placed bool        // selected for at least one page
+}
+
+// OutputAtPage places an HTML fragment at an absolute position (x, y) with
+// the given width on pages produced by a following call to RenderPages,
+// for example a stamp, an address window or a signature. page is a page
+// number starting at 1, EveryPage or LastPage; negative numbers count from
+// the end. Page numbers count all pages of the document.
+//
+// Overlays are drawn when their page is shipped out, on top of the page
+// template and the flowed content. Selecting pages from the end costs an
+// extra layout pass. An overlay that no following RenderPages call places,
+// for example because it was added after the last one, is reported as a
+// warning by Finish.
+func (d *Document) OutputAtPage(page int, html string, width, x, y bag.ScaledPoint) error {
+	if d.aborted != nil {
+		return d.aborted
+	}
+	if err := d.checkInput(html, true); err != nil {
+		return err
+	}
+	if err := d.checkDocument(html); err != nil {
+		return err
+	}
+	html, err := expandBarcodes(html, true)
+	if err != nil {
+		return err
+	}
+	if html, err = d.prepareResources(html, true); err != nil {
+		return err
+	}
+	if width <= 0 {
+		return errors.New("OutputAtPage: width must be positive")
+	}
+	d.sources = append(d.sources, html)
+	d.overlays = append(d.overlays, overlay{page: page, html: html, width: width, x: x, y: y})
+	return nil
+}
+
+// prepareOverlays typesets the overlays that are not built yet and, if an
+// overlay selects pages from the end, sets d.lastPage to the number of the
+// last page html will produce.
+func (d *Document) prepareOverlays(html string) error {
+	fromEnd := false
+	for i := range d.overlays {
+		o := &d.overlays[i]
+		if o.page < 0 {
+			fromEnd = true
+		}
+		if o.vlist != nil {
+			continue
+		}
+		te, err := d.cssbuilder.HTMLToText(o.html)
+		if err != nil {
+			return err
+		}
+		if o.vlist, err = d.cssbuilder.CreateVlist(te, o.width); err != nil {
+			return err
+		}
+	}
+	d.lastPage = 0
+	if fromEnd {
+		n, err := d.pageCountPass(html)
+		if err != nil {
+			return err
+		}
+		d.lastPage = d.pageCount + n
+	}
+	return nil
+}
+
+// selectOverlays remembers the overlays selected for the page just started
+// by RenderPages, whose number is d.pageCount. They are drawn by
+// shipoutOverlays, after the content of the page.
+func (d *Document) selectOverlays() {
+	d.overlayPage = d.Frontend.Doc.CurrentPage
+	d.pageOverlays = d.pageOverlays[:0]
+	for i := range d.overlays {
+		if o := &d.overlays[i]; o.onPage(d.pageCount, d.lastPage) {
+			o.placed = true
+			d.pageOverlays = append(d.pageOverlays, i)
+		}
+	}
+}
+
+// shipoutOverlays is called by the backend before page is written. It
+// outputs the overlays selected for page.
+func (d *Document) shipoutOverlays(page *document.Page) {
+	if page == nil || page != d.overlayPage {
+		return
+	}
+	for _, i := range d.pageOverlays {
+		o := d.overlays[i]
+		page.OutputAt(o.x, o.y, o.vlist)
+	}
+	d.overlayPage = nil
+	d.pageOverlays = d.pageOverlays[:0]
+}
+
+// unplacedOverlays returns a warning for each overlay that was not placed on
+// any page.
+func (d *Document) unplacedOverlays() []Diagnostic {
+	var diags []Diagnostic
+	for _, o := range d.overlays {
+		if o.placed {
+			continue
+		}
+		page := "every page"
+		switch {
+		case o.page > 0:
+			page = fmt.Sprintf("page %d", o.page)
+		case o.page < 0:
+			page = fmt.Sprintf("page %d from the end", -o.page)
+		}
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("OutputAtPage overlay for %s was not placed on any page rendered after it was added", page),
+		})
+	}
+	return diags
+}
+
+// onPage reports whether the overlay belongs on page. last is the number of
+// the last page or zero if it is unknown.
+func (o overlay) onPage(page, last int) bool {
+	switch {
+	case o.page == EveryPage:
+		return true
+	case o.page > 0:
+		return o.page == page
+	}
+	return last > 0 && last+1+o.page == page
+}
@@ -0,0 +1,182 @@
+package document
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/bagme/internal/pdfread"
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+func TestOverlayOnPage(t *testing.T) {
+	tests := []struct {
+		selector   int
+		page, last int
+		want       bool
+	}{
+		{EveryPage, 1, 0, true},
+		{EveryPage, 7, 9, true},
+		{2, 2, 0, true},
+		{2, 3, 0, false},
+		{LastPage, 3, 3, true},
+		{LastPage, 2, 3, false},
+		{LastPage, 3, 0, false},
+		{-2, 2, 3, true},
+		{-4, 1, 3, false},
+	}
+	for _, tt := range tests {
+		o := overlay{page: tt.selector}
+		if got := o.onPage(tt.page, tt.last); got != tt.want {
+			t.Errorf("selector %d on page %d of %d: expected %t, got %t", tt.selector, tt.page, tt.last, tt.want, got)
+		}
+	}
+}
+
+func TestOutputAtPage(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bag.MustSP("5cm")
+	x, y := bag.MustSP("12cm"), bag.MustSP("27cm")
+	for _, page := range []int{EveryPage, 2, LastPage} {
+		if err := d.OutputAtPage(page, "<p>Stamp</p>", w, x, y); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var placed []int
+	d.PageInitCallback = func() { placed = append(placed, d.pageCount) }
+	html := strings.Repeat(`<p style="page-break-after: always">Page</p>`, 2) + "<p>Last page</p>"
+	if err := d.RenderPages(html); err != nil {
+		t.Fatal(err)
+	}
+	if d.lastPage != 3 || len(placed) != 3 {
+		t.Errorf("expected 3 pages with a known last page, got %d pages, last page %d", len(placed), d.lastPage)
+	}
+	for _, o := range d.overlays {
+		if o.vlist == nil {
+			t.Error("expected overlay to be typeset")
+		}
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutputAtPageErrors(t *testing.T) {
+	d, err := New(tempPDF(t), WithMaxInputSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OutputAtPage(1, "<p>x</p>", 0, 0, 0); err == nil {
+		t.Error("expected error for zero width")
+	}
+	if err := d.OutputAtPage(1, "<p>much too long</p>", bag.MustSP("1cm"), 0, 0); err == nil {
+		t.Error("expected input limit to apply")
+	}
+}
+
+// firstPageContent returns the decoded content stream of the first page of
+// the PDF file filename.
+func firstPageContent(t *testing.T, filename string) string {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := pdfread.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := f.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := f.Resolve(catalog["Pages"]).(pdfread.Dict)
+	for page != nil && page["Type"] != pdfread.Name("Page") {
+		kids, _ := f.Resolve(page["Kids"]).(pdfread.Array)
+		if len(kids) == 0 {
+			t.Fatal("no page found")
+		}
+		page, _ = f.Resolve(kids[0]).(pdfread.Dict)
+	}
+	contents := []any{page["Contents"]}
+	if a, ok := f.Resolve(page["Contents"]).(pdfread.Array); ok {
+		contents = a
+	}
+	var sb strings.Builder
+	for _, c := range contents {
+		s, ok := f.Resolve(c).(pdfread.Stream)
+		if !ok {
+			t.Fatal("page without content stream")
+		}
+		data, err := s.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func TestOutputAtPageZOrder(t *testing.T) {
+	filename := tempPDF(t)
+	d, err := New(filename, WithPageTemplate(letterheadPDF(t), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := `<div style="background-color: red; height: 2cm">Stamp</div>`
+	if err := d.OutputAtPage(1, stamp, bag.MustSP("5cm"), bag.MustSP("2cm"), bag.MustSP("26cm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>Flowed text under the stamp</p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	content := firstPageContent(t, filename)
+	template := strings.Index(content, " Do")
+	text := strings.Index(content, "BT")
+	stampFill := strings.LastIndex(content, " re")
+	if template < 0 || text < 0 || stampFill < 0 {
+		t.Fatalf("expected a page template, text and a filled box in:\n%s", content)
+	}
+	if !(template < text && text < stampFill) {
+		t.Errorf("expected the page template, then the content, then the overlay, got %d, %d, %d in:\n%s", template, text, stampFill, content)
+	}
+}
+
+func TestUnplacedOverlays(t *testing.T) {
+	d := &Document{overlays: []overlay{{page: 2, placed: true}, {page: 7}, {page: LastPage}, {page: EveryPage}}}
+	diags := d.unplacedOverlays()
+	want := []string{"page 7", "page 1 from the end", "every page"}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d warnings, got %+v", len(want), diags)
+	}
+	for i, w := range want {
+		if diags[i].Severity != SeverityWarning || !strings.Contains(diags[i].Message, w) {
+			t.Errorf("expected a warning about %s, got %+v", w, diags[i])
+		}
+	}
+}
+
+func TestOutputAtPageAfterRenderPages(t *testing.T) {
+	d, err := New(tempPDF(t), WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>Text</p>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OutputAtPage(1, "<p>Too late</p>", bag.MustSP("5cm"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	var de *DiagnosticsError
+	if err := d.Finish(); !errors.As(err, &de) {
+		t.Errorf("expected the unplaced overlay to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,757 @@
+package document
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/csshtml"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// pageSelector is the selector of an @page rule: an optional page name
+// followed by the pseudo-classes :first, :left, :right and :blank.
+type pageSelector struct {
+	name                      string
+	first, left, right, blank bool
+}
+
+// specificity returns the specificity of the selector as defined by CSS
+// Paged Media: the name counts more than :first and :blank, which count
+// more than :left and :right.
+func (s pageSelector) specificity() int {
+	n := 0
+	if s.name != "" {
+		n += 100
+	}
+	if s.first {
+		n += 10
+	}
+	if s.blank {
+		n += 10
+	}
+	if s.left || s.right {
+		n++
+	}
+	return n
+}
+
+// matches reports whether the selector applies to page. :first selects the
+// first page of the document, or with a page name the first page of every
+// run of pages with that name.
+func (s pageSelector) matches(page pageInfo) bool {
+	switch {
+	case s.name != "" && s.name != page.name:
+		return false
+	case s.first && s.name == "" && !page.first:
+		return false
+	case s.first && s.name != "" && !page.groupFirst:
+		return false
+	case s.left && !page.left, s.right && page.left:
+		return false
+	case s.blank && !page.blank:
+		return false
+	}
+	return true
+}
+
+// parsePageSelectors parses the selector list of an @page rule, for example
+// ":first" or "chapter:left, appendix". An empty list selects every page.
+func parsePageSelectors(list string) ([]pageSelector, bool) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return []pageSelector{{}}, true
+	}
+	var sels []pageSelector
+	for _, part := range strings.Split(list, ",") {
+		name, pseudos, _ := strings.Cut(strings.TrimSpace(part), ":")
+		sel := pageSelector{name: strings.TrimSpace(name)}
+		if sel.name == "" && pseudos == "" || sel.name != "" && !isPageName(sel.name) {
+			return nil, false
+		}
+		if pseudos != "" {
+			for _, p := range strings.Split(pseudos, ":") {
+				switch strings.ToLower(strings.TrimSpace(p)) {
+				case "first":
+					sel.first = true
+				case "left":
+					sel.left = true
+				case "right":
+					sel.right = true
+				case "blank":
+					sel.blank = true
+				default:
+					return nil, false
+				}
+			}
+		}
+		sels = append(sels, sel)
+	}
+	return sels, true
+}
+
+// isPageName reports whether s is a CSS identifier that can name a page.
+func isPageName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= 0x80:
+		case i > 0 && (r == '-' || r >= '0' && r <= '9'):
+		default:
+			return false
+		}
+	}
+	return s != "" && s != "auto"
+}
+
+// pageInfo describes a page for matching @page rules.
+type pageInfo struct {
+	name       string // page name, "" for unnamed pages
+	first      bool   // first page of the document
+	groupFirst bool   // first page of a run of pages with the same name
+	left       bool   // left (even) page, otherwise right
+	blank      bool   // empty page inserted by a left or right page break
+	number     int    // page number, zero if unknown
+}
+
+// pageRule is one selector of an @page rule with the rule's declarations
+// and margin boxes.
+type pageRule struct {
+	sel   pageSelector
+	block *cssBlock
+}
+
+// pageProp is a page, break-before, string-set or running position
+// declaration of a style rule.
+type pageProp struct {
+	sel      cascadia.Sel
+	property string // "page", "break-before", "string-set" or "position"
+	value    string
+}
+
+// pageStyles collects the parts of the stylesheets that bagme applies
+// itself: @page rules with selectors, the page and break-before properties
+// that choose them and the named strings and running elements shown in
+// margin boxes. @page rules without selector are recorded as well but also
+// left to the CSS builder.
+type pageStyles struct {
+	rules    []pageRule
+	props    []pageProp
+	boxes    []string                // margin boxes of @page rules with selectors
+	selected bool                    // an @page rule has a selector
+	running  bool                    // a margin box uses string(), element() or counter(pages)
+	paging   bool                    // set while RenderPages chooses the page styles
+	planned  bool                    // plan is given by the layout pass of planPages
+	plan     map[int]pageInfo        // page styles chosen by planPages, by page number
+	applied  string                  // CSS of the page master selected last
+	masters  map[string]csshtml.Page // page masters by the CSS of their @page rule
+	infos    map[int]pageInfo        // by page number, pages whose style was applied
+}
+
+// active reports whether pages need more than the @page rules without
+// selector that the CSS builder handles.
+func (p *pageStyles) active() bool {
+	return p.selected || p.running || len(p.props) > 0
+}
+
+// add adds the rules and properties of q to p.
+func (p *pageStyles) add(q *pageStyles) {
+	p.rules = append(p.rules, q.rules...)
+	p.props = append(p.props, q.props...)
+	for _, box := range q.boxes {
+		if !slices.Contains(p.boxes, box) {
+			p.boxes = append(p.boxes, box)
+		}
+	}
+	p.selected = p.selected || q.selected
+	p.running = p.running || q.running
+}
+
+// extractPageRules moves @page rules with selectors from blocks to d.pages
+// and records the page and break-before declarations of style rules. Page
+// declarations are removed, since the CSS builder does not know them. The
+// remaining blocks are returned.
+func (d *Document) extractPageRules(blocks []*cssBlock) []*cssBlock {
+	out := blocks[:0]
+	for _, b := range blocks {
+		switch b.atRule() {
+		case "media":
+			b.children = d.extractPageRules(b.children)
+		case "page":
+			sels, ok := parsePageSelectors(b.prelude[len("@page"):])
+			if !ok {
+				break
+			}
+			for _, sel := range sels {
+				d.pages.rules = append(d.pages.rules, pageRule{sel: sel, block: b.clone()})
+			}
+			if hasRunningContent(b) {
+				d.pages.running = true
+			}
+			if sels[0] == (pageSelector{}) {
+				break
+			}
+			d.pages.selected = true
+			for _, box := range b.children {
+				if !slices.Contains(d.pages.boxes, box.prelude) {
+					d.pages.boxes = append(d.pages.boxes, box.prelude)
+				}
+			}
+			continue
+		case "":
+			d.extractPageProps(b)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// extractPageProps records the page, break-before, string-set and
+// position: running() declarations of the style rule b and removes all but
+// the break-before declarations from b.
+func (d *Document) extractPageProps(b *cssBlock) {
+	var props []pageProp
+	decls := b.decls[:0]
+	for _, decl := range b.decls {
+		switch decl.property {
+		case "page":
+			props = append(props, pageProp{property: "page", value: strings.TrimSpace(decl.value)})
+			continue
+		case "break-before", "page-break-before":
+			if v := strings.ToLower(strings.TrimSpace(decl.value)); pageSide(v) != "" {
+				props = append(props, pageProp{property: "break-before", value: v})
+			}
+		case "string-set":
+			props = append(props, pageProp{property: "string-set", value: strings.TrimSpace(decl.value)})
+			continue
+		case "position":
+			if runningName(decl.value) != "" {
+				props = append(props, pageProp{property: "position", value: strings.TrimSpace(decl.value)})
+				continue
+			}
+		}
+		decls = append(decls, decl)
+	}
+	b.decls = decls
+	if len(props) == 0 {
+		return
+	}
+	group, err := cascadia.ParseGroup(b.prelude)
+	if err != nil {
+		return
+	}
+	for _, sel := range group {
+		for _, p := range props {
+			p.sel = sel
+			d.pages.props = append(d.pages.props, p)
+		}
+	}
+}
+
+// pageSide returns "left" or "right" for a break-before value that forces
+// a break to a left or right page and "" otherwise.
+func pageSide(v string) string {
+	switch v {
+	case "left", "verso":
+		return "left"
+	case "right", "recto":
+		return "right"
+	}
+	return ""
+}
+
+// mayChoosePages reports whether style attributes in src may set the page
+// or break-before properties.
+func mayChoosePages(src string) bool {
+	return strings.Contains(src, "page:") || strings.Contains(src, "break-before:")
+}
+
+// value returns the value of a property recorded in p.props for n from its
+// style attribute or the style rules, or "" if none is set.
+// break-before values are lower case.
+func (p *pageStyles) value(n *html.Node, property string) string {
+	if style := attr(n, "style"); style != "" {
+		for _, b := range parseCSS("x {" + style + "}") {
+			if v, ok := b.get(property); ok {
+				return strings.TrimSpace(v)
+			}
+			if property == "break-before" {
+				if v, ok := b.get("page-break-before"); ok {
+					return strings.ToLower(strings.TrimSpace(v))
+				}
+			}
+		}
+	}
+	var best *pageProp
+	for i := range p.props {
+		prop := &p.props[i]
+		if prop.property != property || !prop.sel.Match(n) {
+			continue
+		}
+		if best == nil || !prop.sel.Specificity().Less(best.sel.Specificity()) {
+			best = prop
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.value
+}
+
+// style returns the @page rule for page: the declarations and margin boxes
+// of all matching rules in the order of their specificity. Margin boxes
+// that other pages define are emptied.
+func (p *pageStyles) style(page pageInfo) *cssBlock {
+	var rules []pageRule
+	for _, r := range p.rules {
+		if r.sel.matches(page) {
+			rules = append(rules, r)
+		}
+	}
+	slices.SortStableFunc(rules, func(a, b pageRule) int {
+		return a.sel.specificity() - b.sel.specificity()
+	})
+	style := &cssBlock{prelude: "@page"}
+	boxes := map[string]*cssBlock{}
+	for _, r := range rules {
+		style.decls = append(style.decls, r.block.decls...)
+		for _, c := range r.block.children {
+			box, ok := boxes[c.prelude]
+			if !ok {
+				box = &cssBlock{prelude: c.prelude}
+				boxes[c.prelude] = box
+				style.children = append(style.children, box)
+			}
+			box.decls = append(box.decls, c.decls...)
+		}
+	}
+	for _, name := range p.boxes {
+		if boxes[name] == nil {
+			style.children = append(style.children, &cssBlock{prelude: name, decls: []cssDecl{{property: "content", value: `""`}}})
+		}
+	}
+	return style
+}
+
+// applyPageStyle selects the page master for the @page rule of page, which
+// the CSS builder uses for the pages it starts from now on. Each distinct
+// rule is turned into a page master once, so switching between left and
+// right pages does not add CSS to the builder. If bagme draws the margin
+// boxes itself, their content is left empty in the rule, so that it does
+// not change from page to page.
+func (d *Document) applyPageStyle(page pageInfo) error {
+	if d.pages.infos == nil {
+		d.pages.infos = map[int]pageInfo{}
+	}
+	d.pages.infos[page.number] = page
+	style := d.pages.style(page)
+	if d.pages.running {
+		for _, box := range style.children {
+			box.decls = []cssDecl{{property: "content", value: `""`}}
+		}
+	}
+	css := writeCSS([]*cssBlock{style})
+	if css == d.pages.applied {
+		return nil
+	}
+	master, ok := d.pages.masters[css]
+	if !ok {
+		c := csshtml.NewCSSParserWithDefaults()
+		if err := c.AddCSSText(css); err != nil {
+			return err
+		}
+		master = c.Pages[""]
+		if d.pages.masters == nil {
+			d.pages.masters = map[string]csshtml.Page{}
+		}
+		d.pages.masters[css] = master
+	}
+	d.css.Pages[""] = master
+	d.pages.applied = css
+	return nil
+}
+
+// groupAttr marks the headings that planPages inserts in layout passes to
+// find the first page of every page group.
+const groupAttr = "data-bag-page-group"
+
+// pageGroup is a part of the document that starts on a new page: a run of
+// children of body with the same page name.
+type pageGroup struct {
+	name  string
+	side  string     // "left" or "right" for a break to a left or right page
+	start *html.Node // first child of body in the group
+}
+
+// pageGroups divides the children of body in doc at the children whose
+// page name differs from the one before and at forced breaks to left and
+// right pages. The probes of layout passes belong to the element after
+// them. doc is not changed.
+func (d *Document) pageGroups(doc *html.Node) []pageGroup {
+	body := findElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body })
+	if body == nil {
+		return nil
+	}
+	defaultName := pageName(d.pages.value(body, "page"))
+	var groups []pageGroup
+	var probe *html.Node // first of the probes before c
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode && (c.Type != html.TextNode || strings.TrimSpace(c.Data) == "") {
+			continue
+		}
+		if hasAttr(c, probeAttr) {
+			if probe == nil {
+				probe = c
+			}
+			continue
+		}
+		name, side := defaultName, ""
+		if c.Type == html.ElementNode {
+			if v := pageName(d.pages.value(c, "page")); v != "" {
+				name = v
+			}
+			side = pageSide(strings.ToLower(d.pages.value(c, "break-before")))
+		}
+		if len(groups) == 0 || name != groups[len(groups)-1].name || side != "" {
+			start := c
+			if probe != nil {
+				start = probe
+			}
+			groups = append(groups, pageGroup{name: name, side: side, start: start})
+		}
+		probe = nil
+	}
+	if len(groups) == 0 {
+		return []pageGroup{{name: defaultName}}
+	}
+	return groups
+}
+
+// pageName returns the page name of a page property value, "" for auto.
+func pageName(v string) string {
+	if !isPageName(v) {
+		return ""
+	}
+	return v
+}
+
+// planPages chooses the page style of every page of src and returns the
+// HTML to render. The CSS builder flows the whole document at once, so
+// every page group but the first gets a forced page break, and an empty
+// page is inserted where a group has to start on the other side. Layout
+// passes find the pages the groups start on; they are repeated until the
+// blank pages and the page styles no longer change. The page styles are
+// recorded in d.pages.plan, pages after the plan get the page name of the
+// last group.
+func (d *Document) planPages(src string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	groups := d.pageGroups(doc)
+	if len(groups) == 0 {
+		return src, nil
+	}
+	d.pageName = groups[len(groups)-1].name
+	if len(groups) == 1 && groups[0].side == "" {
+		return src, nil
+	}
+	blanks := make([]bool, len(groups))
+	var plan map[int]pageInfo
+	for pass := 1; ; pass++ {
+		out, hs, err := groupHTML(doc, groups, blanks, true)
+		if err != nil {
+			return "", err
+		}
+		scratch, err := d.newScratch()
+		if err != nil {
+			return "", err
+		}
+		scratch.pages.plan, scratch.pages.planned = plan, true
+		scratch.pageName = groups[0].name
+		if err := scratch.renderPages(out); err != nil {
+			return "", err
+		}
+		entries := scratch.Headings()
+		for i := range entries {
+			entries[i].PageNumber += d.pageCount
+		}
+		starts := make([]int, len(groups))
+		for i, page := range headingPages(hs, entries) {
+			if k, err := strconv.Atoi(attr(hs[i].node, groupAttr)); err == nil && k < len(starts) {
+				starts[k] = page
+			}
+		}
+		newBlanks, newPlan := planGroups(groups, starts, blanks, d.pageCount, scratch.pageCount)
+		done := slices.Equal(newBlanks, blanks) && maps.Equal(newPlan, plan)
+		blanks, plan = newBlanks, newPlan
+		if done || pass >= maxLayoutPasses {
+			break
+		}
+	}
+	d.pages.plan = plan
+	out, _, err := groupHTML(doc, groups, blanks, false)
+	return out, err
+}
+
+// planGroups returns the blank pages and the page styles of the groups.
+// starts are the first pages of the groups in a layout pass that had blank
+// pages in front of the groups in blanks, offset the number of pages before
+// the document and last the last page of the layout pass.
+func planGroups(groups []pageGroup, starts []int, blanks []bool, offset, last int) ([]bool, map[int]pageInfo) {
+	newBlanks := make([]bool, len(groups))
+	first := make([]int, len(groups)) // first page of the group's content
+	shift := 0                        // pages added by changed blank pages
+	for k, g := range groups {
+		start := starts[k]
+		switch {
+		case k == 0 && start == 0:
+			start = offset + 1
+			if blanks[0] {
+				start++
+			}
+		case k > 0 && start < starts[k-1]:
+			// the marker was not found
+			start = starts[k-1]
+		}
+		starts[k] = start
+		natural := start + shift
+		if blanks[k] {
+			natural--
+		}
+		newBlanks[k] = g.side == "left" && natural%2 == 1 || g.side == "right" && natural%2 == 0
+		first[k] = natural
+		if newBlanks[k] {
+			first[k]++
+		}
+		shift = first[k] - start
+	}
+	last += shift
+	plan := map[int]pageInfo{}
+	for k, g := range groups {
+		if newBlanks[k] {
+			p := first[k] - 1
+			plan[p] = pageInfo{name: g.name, first: p == 1, left: p%2 == 0, blank: true, number: p}
+		}
+		end := last
+		if k+1 < len(groups) {
+			end = first[k+1] - 1
+			if newBlanks[k+1] {
+				end--
+			}
+		}
+		for p := first[k]; p <= end; p++ {
+			plan[p] = pageInfo{name: g.name, first: p == 1, groupFirst: p == first[k], left: p%2 == 0, number: p}
+		}
+	}
+	return newBlanks, plan
+}
+
+// groupHTML renders doc with a forced page break in front of every group
+// but the first and with the blank pages in blanks. With markers, every
+// group starts with a heading that records its page in a layout pass; the
+// headings are returned. doc is left unchanged.
+func groupHTML(doc *html.Node, groups []pageGroup, blanks []bool, markers bool) (string, []tocHeading, error) {
+	const pageBreak = "page-break-before: always"
+	var inserted []*html.Node
+	attrs := map[*html.Node][]html.Attribute{}
+	for k, g := range groups {
+		brk := k > 0
+		if blanks[k] {
+			blank := newElement(atom.Div)
+			if brk {
+				setAttr(blank, "style", pageBreak)
+			}
+			g.start.Parent.InsertBefore(blank, g.start)
+			inserted = append(inserted, blank)
+			brk = true
+		}
+		switch {
+		case markers:
+			style := probeStyle
+			if brk {
+				style += "; " + pageBreak
+			}
+			marker := newElement(atom.H6, groupAttr, strconv.Itoa(k), "style", style)
+			marker.AppendChild(&html.Node{Type: html.TextNode, Data: fmt.Sprintf("bag-page-group-%d", k)})
+			g.start.Parent.InsertBefore(marker, g.start)
+			inserted = append(inserted, marker)
+		case brk && g.start.Type == html.ElementNode:
+			attrs[g.start] = slices.Clone(g.start.Attr)
+			style := attr(g.start, "style")
+			if strings.TrimSpace(style) != "" {
+				style += "; "
+			}
+			setAttr(g.start, "style", style+pageBreak)
+		case brk:
+			div := newElement(atom.Div, "style", pageBreak)
+			g.start.Parent.InsertBefore(div, g.start)
+			inserted = append(inserted, div)
+		}
+	}
+	defer func() {
+		for _, n := range inserted {
+			n.Parent.RemoveChild(n)
+		}
+		for n, a := range attrs {
+			n.Attr = a
+		}
+	}()
+	var hs []tocHeading
+	if markers {
+		hs = collectHeadings(doc, nil)
+	}
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return "", nil, err
+	}
+	return sb.String(), hs, nil
+}
+
+// startPages selects the page style of the first page that RenderPages
+// starts.
+func (d *Document) startPages() error {
+	next := d.pageCount + 1
+	page, ok := d.pages.plan[next]
+	if !ok {
+		page = pageInfo{name: d.pageName, first: next == 1, groupFirst: true, left: next%2 == 0, number: next}
+	}
+	return d.applyPageStyle(page)
+}
+
+// prepareNextPage selects the page style of the page after the current
+// one. It is called when a page is started.
+func (d *Document) prepareNextPage() error {
+	next := d.pageCount + 1
+	page, ok := d.pages.plan[next]
+	if !ok {
+		page = pageInfo{name: d.pageName, left: next%2 == 0, number: next}
+	}
+	return d.applyPageStyle(page)
+}
+
+// pageSizes are the page sizes of the size property in portrait
+// orientation.
+var pageSizes = map[string][2]string{
+	"a3":     {"297mm", "420mm"},
+	"a4":     {"210mm", "297mm"},
+	"a5":     {"148mm", "210mm"},
+	"b4":     {"250mm", "353mm"},
+	"b5":     {"176mm", "250mm"},
+	"jis-b4": {"257mm", "364mm"},
+	"jis-b5": {"182mm", "257mm"},
+	"letter": {"8.5in", "11in"},
+	"legal":  {"8.5in", "14in"},
+	"ledger": {"11in", "17in"},
+}
+
+// PageSizeFor returns the dimensions of the pages selected by an @page
+// selector such as ":first", "chapter:left" or ":blank", as given by the
+// @page rules of the stylesheets. Pages are right pages unless the
+// selector contains :left; a page name with :first stands for the first
+// page of the named pages. The empty selector returns the size of the
+// pages that no other rule applies to. Pages default to A4 with 1cm
+// margins.
+func (d *Document) PageSizeFor(selector string) (PageDimensions, error) {
+	sels, ok := parsePageSelectors(selector)
+	if !ok || len(sels) != 1 {
+		return PageDimensions{}, fmt.Errorf("PageSizeFor: invalid page selector %q", selector)
+	}
+	sel := sels[0]
+	page := pageInfo{name: sel.name, first: sel.first && sel.name == "", groupFirst: sel.first, left: sel.left, blank: sel.blank}
+	return pageDimensions(d.pages.style(page))
+}
+
+// pageDimensions computes the page size and margins of an @page rule.
+func pageDimensions(style *cssBlock) (PageDimensions, error) {
+	size := pageSizes["a4"]
+	var dim PageDimensions
+	var err error
+	if dim.Width, err = bag.Sp(size[0]); err != nil {
+		return dim, err
+	}
+	if dim.Height, err = bag.Sp(size[1]); err != nil {
+		return dim, err
+	}
+	margin := bag.MustSP("1cm")
+	dim.MarginTop, dim.MarginRight, dim.MarginBottom, dim.MarginLeft = margin, margin, margin, margin
+	for _, decl := range style.decls {
+		switch decl.property {
+		case "size":
+			if err := pageSize(&dim, decl.value); err != nil {
+				return dim, err
+			}
+		case "margin":
+			// top, right, bottom, left
+			var f []string
+			switch v := strings.Fields(decl.value); len(v) {
+			case 1:
+				f = []string{v[0], v[0], v[0], v[0]}
+			case 2:
+				f = []string{v[0], v[1], v[0], v[1]}
+			case 3:
+				f = []string{v[0], v[1], v[2], v[1]}
+			case 4:
+				f = v
+			default:
+				return dim, fmt.Errorf("@page: invalid margin %q", decl.value)
+			}
+			for i, p := range []*bag.ScaledPoint{&dim.MarginTop, &dim.MarginRight, &dim.MarginBottom, &dim.MarginLeft} {
+				if *p, err = bag.Sp(f[i]); err != nil {
+					return dim, fmt.Errorf("@page: invalid margin %q", decl.value)
+				}
+			}
+		case "margin-top", "margin-right", "margin-bottom", "margin-left":
+			p := map[string]*bag.ScaledPoint{
+				"margin-top": &dim.MarginTop, "margin-right": &dim.MarginRight,
+				"margin-bottom": &dim.MarginBottom, "margin-left": &dim.MarginLeft,
+			}[decl.property]
+			if *p, err = bag.Sp(strings.TrimSpace(decl.value)); err != nil {
+				return dim, fmt.Errorf("@page: invalid %s %q", decl.property, decl.value)
+			}
+		}
+	}
+	dim.ContentWidth = dim.Width - dim.MarginLeft - dim.MarginRight
+	dim.ContentHeight = dim.Height - dim.MarginTop - dim.MarginBottom
+	return dim, nil
+}
+
+// pageSize applies the value of the size property to dim.
+func pageSize(dim *PageDimensions, value string) error {
+	var lengths []bag.ScaledPoint
+	orientation := ""
+	for _, f := range strings.Fields(strings.ToLower(value)) {
+		switch f {
+		case "auto":
+		case "portrait", "landscape":
+			orientation = f
+		default:
+			if size, ok := pageSizes[f]; ok {
+				dim.Width, dim.Height = bag.MustSP(size[0]), bag.MustSP(size[1])
+				continue
+			}
+			l, err := bag.Sp(f)
+			if err != nil {
+				return fmt.Errorf("@page: invalid size %q", value)
+			}
+			lengths = append(lengths, l)
+		}
+	}
+	switch len(lengths) {
+	case 0:
+	case 1:
+		dim.Width, dim.Height = lengths[0], lengths[0]
+	case 2:
+		dim.Width, dim.Height = lengths[0], lengths[1]
+	default:
+		return fmt.Errorf("@page: invalid size %q", value)
+	}
+	if orientation == "landscape" && dim.Width < dim.Height || orientation == "portrait" && dim.Width > dim.Height {
+		dim.Width, dim.Height = dim.Height, dim.Width
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package document
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"golang.org/x/net/html"
+)
+
+func TestParsePageSelectors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []pageSelector
+	}{
+		{"", []pageSelector{{}}},
+		{":first", []pageSelector{{first: true}}},
+		{"chapter", []pageSelector{{name: "chapter"}}},
+		{"chapter:first:right", []pageSelector{{name: "chapter", first: true, right: true}}},
+		{":left, :blank", []pageSelector{{left: true}, {blank: true}}},
+	}
+	for _, tt := range tests {
+		got, ok := parsePageSelectors(tt.in)
+		if !ok {
+			t.Errorf("%q: unexpected failure", tt.in)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.in, got, tt.want)
+			}
+		}
+	}
+	for _, in := range []string{":nth(2)", "1chapter", "chapter,", ":", "auto"} {
+		if _, ok := parsePageSelectors(in); ok {
+			t.Errorf("%q: expected failure", in)
+		}
+	}
+}
+
+func TestPageSelectorMatches(t *testing.T) {
+	first := pageInfo{first: true, groupFirst: true}
+	left := pageInfo{left: true}
+	chapterStart := pageInfo{name: "chapter", groupFirst: true, left: true}
+	tests := []struct {
+		sel  string
+		page pageInfo
+		want bool
+	}{
+		{":first", first, true},
+		{":first", left, false},
+		{":first", chapterStart, false},
+		{"chapter:first", chapterStart, true},
+		{"chapter:first", pageInfo{name: "chapter"}, false},
+		{":left", left, true},
+		{":right", left, false},
+		{":right", first, true},
+		{"chapter", left, false},
+		{":blank", pageInfo{blank: true}, true},
+		{":blank", first, false},
+	}
+	for _, tt := range tests {
+		sels, _ := parsePageSelectors(tt.sel)
+		if got := sels[0].matches(tt.page); got != tt.want {
+			t.Errorf("%q matches %+v: got %v, want %v", tt.sel, tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestExtractPageRules(t *testing.T) {
+	d := &Document{}
+	blocks := parseCSS(`@page { size: A4 }
+@page :first { margin-top: 5cm }
+@media print { @page chapter { size: A4 landscape } }
+section.chapter { page: chapter; color: red }
+h1 { page-break-before: right }
+h2 { page-break-before: always }`)
+	css := writeCSS(d.extractPageRules(blocks))
+	if strings.Contains(css, ":first") || strings.Contains(css, "@page chapter") || strings.Contains(css, "page:") {
+		t.Errorf("expected @page rules with selectors and page declarations to be removed:\n%s", css)
+	}
+	for _, want := range []string{"size: A4", "color: red", "page-break-before: right", "page-break-before: always"} {
+		if !strings.Contains(css, want) {
+			t.Errorf("expected %q to stay in:\n%s", want, css)
+		}
+	}
+	if len(d.pages.rules) != 3 || !d.pages.selected {
+		t.Errorf("expected 3 page rules, got %d", len(d.pages.rules))
+	}
+	if len(d.pages.props) != 2 {
+		t.Errorf("expected page and break-before right to be recorded, got %d properties", len(d.pages.props))
+	}
+}
+
+func TestPageStyle(t *testing.T) {
+	d := &Document{}
+	d.extractPageRules(parseCSS(`@page { margin: 1cm; @bottom-center { content: counter(page) } }
+@page :left { margin-left: 3cm }
+@page :first { margin-top: 5cm }
+@page chapter:first { @top-center { content: "Chapter" } }`))
+	style := d.pages.style(pageInfo{first: true, groupFirst: true})
+	if v, _ := style.get("margin-top"); v != "5cm" {
+		t.Errorf("first page: expected margin-top 5cm, got %q", v)
+	}
+	if _, ok := style.get("margin-left"); ok {
+		t.Error("first page is a right page")
+	}
+	css := writeCSS([]*cssBlock{style})
+	if !strings.Contains(css, "@bottom-center {\n    content: counter(page);") {
+		t.Errorf("expected the margin box of the base rule:\n%s", css)
+	}
+	if !strings.Contains(css, "@top-center {\n    content: \"\";") {
+		t.Errorf("expected the chapter margin box to be emptied:\n%s", css)
+	}
+	style = d.pages.style(pageInfo{name: "chapter", groupFirst: true, left: true})
+	if v, _ := style.get("margin-left"); v != "3cm" {
+		t.Errorf("left page: expected margin-left 3cm, got %q", v)
+	}
+	css = writeCSS([]*cssBlock{style})
+	if !strings.Contains(css, "content: \"Chapter\"") {
+		t.Errorf("expected the chapter margin box:\n%s", css)
+	}
+}
+
+func TestPageGroups(t *testing.T) {
+	d := &Document{}
+	d.extractPageRules(parseCSS(`section.chapter { page: chapter } h1.part { break-before: right }`))
+	doc, err := html.Parse(strings.NewReader(`<html><body class="b">
+<p>Cover</p>
+<section class="chapter">One</section>
+<section class="chapter">Two</section>
+<h6 data-bag-probe="x">Probe</h6><h1 class="part">Part</h1>
+<p style="page: wide">Table</p>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := d.pageGroups(doc)
+	want := []struct{ name, side, text string }{
+		{"", "", "Cover"},
+		{"chapter", "", "One"},
+		{"", "right", "Probe"},
+		{"wide", "", "Table"},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("expected %d groups, got %d", len(want), len(groups))
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.name != w.name || g.side != w.side || textContent(g.start) != w.text {
+			t.Errorf("group %d: got %q %q %q", i, g.name, g.side, textContent(g.start))
+		}
+	}
+}
+
+func TestPageSizeFor(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page { size: A4; margin: 2cm }
+@page :left { margin: 1cm 3cm }
+@page wide { size: A4 landscape }
+@page wide:first { size: 100mm 50mm; margin-top: 1cm }`); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sel                string
+		width, height      string
+		marginTop, marginL string
+	}{
+		{"", "210mm", "297mm", "2cm", "2cm"},
+		{":left", "210mm", "297mm", "1cm", "3cm"},
+		{"wide", "297mm", "210mm", "2cm", "2cm"},
+		{"wide:first", "100mm", "50mm", "1cm", "2cm"},
+	}
+	for _, tt := range tests {
+		dim, err := d.PageSizeFor(tt.sel)
+		if err != nil {
+			t.Errorf("%q: %v", tt.sel, err)
+			continue
+		}
+		if dim.Width != bag.MustSP(tt.width) || dim.Height != bag.MustSP(tt.height) {
+			t.Errorf("%q: got %v x %v, want %s x %s", tt.sel, dim.Width, dim.Height, tt.width, tt.height)
+		}
+		if dim.MarginTop != bag.MustSP(tt.marginTop) || dim.MarginLeft != bag.MustSP(tt.marginL) {
+			t.Errorf("%q: got margins %v, %v", tt.sel, dim.MarginTop, dim.MarginLeft)
+		}
+	}
+	if _, err := d.PageSizeFor("a, b"); err == nil {
+		t.Error("expected error for a selector list")
+	}
+	if err := d.AddCSS(`@page bad { size: huge }`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PageSizeFor("bad"); err == nil {
+		t.Error("expected error for an invalid size")
+	}
+}
+
+func TestGroupHTML(t *testing.T) {
+	// The document stays in one piece, so :first-child and the sibling
+	// combinators see all children of body.
+	d := &Document{}
+	d.extractPageRules(parseCSS(`section:first-child { page: cover } section.part { break-before: left }`))
+	src := `<html><head></head><body><section>Title</section><section class="part" style="color: red">One</section><section>Two</section></body></html>`
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := d.pageGroups(doc)
+	if len(groups) != 2 || groups[0].name != "cover" || groups[1].name != "" || groups[1].side != "left" {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+	out, hs, err := groupHTML(doc, groups, []bool{false, true}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<body><section>Title</section><div style="page-break-before: always"></div><section class="part" style="color: red; page-break-before: always">One</section><section>Two</section></body>`
+	if !strings.Contains(out, want) || hs != nil {
+		t.Errorf("expected a blank page and a break in one document, got %s", out)
+	}
+	if out, hs, err = groupHTML(doc, groups, []bool{false, false}, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 2 || attr(hs[1].node, groupAttr) != "1" || !strings.Contains(out, probeStyle+"; page-break-before: always") {
+		t.Errorf("expected a marker with a page break in front of the second group, got %s", out)
+	}
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != src {
+		t.Errorf("expected doc to be unchanged, got %s", sb.String())
+	}
+}
+
+func TestPlanGroups(t *testing.T) {
+	groups := []pageGroup{{name: "cover"}, {name: "chapter", side: "right"}, {name: "chapter", side: "right"}}
+	// layout pass without blank pages: the cover has one page, the
+	// chapters two each
+	blanks, plan := planGroups(groups, []int{1, 2, 4}, []bool{false, false, false}, 0, 5)
+	if !slices.Equal(blanks, []bool{false, true, false}) {
+		t.Fatalf("expected a blank page in front of the first chapter, got %v", blanks)
+	}
+	want := []pageInfo{
+		{name: "cover", first: true, groupFirst: true, number: 1},
+		{name: "chapter", left: true, blank: true, number: 2},
+		{name: "chapter", groupFirst: true, number: 3},
+		{name: "chapter", left: true, number: 4},
+		{name: "chapter", groupFirst: true, number: 5},
+		{name: "chapter", left: true, number: 6},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("expected %d pages, got %+v", len(want), plan)
+	}
+	for _, w := range want {
+		if plan[w.number] != w {
+			t.Errorf("page %d: expected %+v, got %+v", w.number, w, plan[w.number])
+		}
+	}
+	// With the page styles of the plan the first chapter needs three
+	// pages, so the second one starts on a left page and needs a blank
+	// page as well.
+	blanks, plan = planGroups(groups, []int{1, 3, 6}, blanks, 0, 7)
+	if !slices.Equal(blanks, []bool{false, true, true}) {
+		t.Fatalf("expected blank pages in front of both chapters, got %v", blanks)
+	}
+	if len(plan) != 8 || !plan[6].blank || !plan[7].groupFirst || plan[7].left || !plan[8].left {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	// The next layout pass confirms the plan.
+	again, plan2 := planGroups(groups, []int{1, 3, 7}, blanks, 0, 8)
+	if !slices.Equal(again, blanks) || !maps.Equal(plan, plan2) {
+		t.Errorf("expected a stable plan, got %v %+v", again, plan2)
+	}
+	// Pages inserted before the document count.
+	blanks, plan = planGroups(groups[:2], []int{4, 5}, []bool{false, false}, 3, 5)
+	if !slices.Equal(blanks, []bool{false, false}) || plan[4].first || !plan[4].groupFirst || !plan[5].groupFirst {
+		t.Errorf("unexpected plan after inserted pages: %v %+v", blanks, plan)
+	}
+}
+
+func TestApplyPageStyleOncePerStyle(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Finish()
+	if err := d.AddCSS(`@page { @top-center { content: "Page " counter(page) " of " counter(pages) } }
+@page :first { margin-top: 3cm }`); err != nil {
+		t.Fatal(err)
+	}
+	var applied []string
+	for n := 1; n <= 5; n++ {
+		if err := d.applyPageStyle(pageInfo{first: n == 1, left: n%2 == 0, number: n}); err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) == 0 || applied[len(applied)-1] != d.pages.applied {
+			applied = append(applied, d.pages.applied)
+		}
+	}
+	if len(applied) != 2 {
+		t.Errorf("expected the first page style and one style for the other pages, got %q", applied)
+	}
+	for _, css := range applied {
+		if strings.Contains(css, "Page") {
+			t.Errorf("expected margin box content to be left to bagme:\n%s", css)
+		}
+	}
+	if len(d.pages.infos) != 5 || !d.pages.infos[1].first || !d.pages.infos[4].left {
+		t.Errorf("unexpected page infos %+v", d.pages.infos)
+	}
+}
+
+func TestRenderPagesNamedPages(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page chapter { size: A5 } section { page: chapter; break-before: right }`); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []bag.ScaledPoint
+	d.PageInitCallback = func() {
+		ps, err := d.PageSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, ps.Width)
+	}
+	if err := d.RenderPages(`<p>Cover</p><section>Chapter</section>`); err != nil {
+		t.Fatal(err)
+	}
+	// cover, blank left page, chapter on a right page
+	if len(sizes) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(sizes))
+	}
+	if sizes[0] != bag.MustSP("210mm") || sizes[2] != bag.MustSP("148mm") {
+		t.Errorf("unexpected page widths %v", sizes)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderPagesLeftRight(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page { size: A5; margin: 2cm }
+@page :left { margin-left: 3cm }
+@page :right { margin-right: 3cm }
+p + p { page-break-before: always }`); err != nil {
+		t.Fatal(err)
+	}
+	var margins []bag.ScaledPoint
+	d.PageInitCallback = func() {
+		ps, err := d.PageSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		margins = append(margins, ps.MarginLeft)
+	}
+	if err := d.RenderPages(`<p>One</p><p>Two</p><p>Three</p><p>Four</p><p>Five</p><p>Six</p>`); err != nil {
+		t.Fatal(err)
+	}
+	if len(margins) != 6 {
+		t.Fatalf("expected 6 pages, got %d", len(margins))
+	}
+	for i, m := range margins {
+		want := bag.MustSP("2cm")
+		if i%2 == 1 {
+			want = bag.MustSP("3cm")
+		}
+		if m != want {
+			t.Errorf("page %d: expected margin-left %v, got %v", i+1, want, m)
+		}
+	}
+	if len(d.pages.masters) != 2 {
+		t.Errorf("expected one page master for left and one for right pages, got %d", len(d.pages.masters))
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
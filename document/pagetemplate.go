@@ -0,0 +1,96 @@
+package document
+
+import (
+	"fmt"
+
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// pageTemplate is a page of a PDF file that is drawn as the background of
+// pages, such as a letterhead.
+type pageTemplate struct {
+	filename string
+	page     int
+	img      *document.Image // loaded by New
+}
+
+// WithPageTemplate draws page number page (starting at 1) of the PDF file
+// filename as the background of every page, for example company
+// stationery. Together with WithFirstPageTemplate it applies to all pages
+// but the first. The template is scaled to the page size and drawn before
+// any other content of the page, in RenderPages as well as in OutputAt
+// mode. New returns an error if the file cannot be loaded or does not have
+// the page.
+func WithPageTemplate(filename string, page int) Option {
+	return func(c *config) {
+		if page < 1 {
+			c.setErr(fmt.Errorf("WithPageTemplate: invalid page number %d", page))
+			return
+		}
+		c.pageTemplate = &pageTemplate{filename: filename, page: page}
+	}
+}
+
+// WithFirstPageTemplate is WithPageTemplate for the first page of the
+// document, for example a letterhead with the full address block. Other
+// pages use the template of WithPageTemplate, if any.
+func WithFirstPageTemplate(filename string, page int) Option {
+	return func(c *config) {
+		if page < 1 {
+			c.setErr(fmt.Errorf("WithFirstPageTemplate: invalid page number %d", page))
+			return
+		}
+		c.firstPageTemplate = &pageTemplate{filename: filename, page: page}
+	}
+}
+
+// templateFor returns the template of the given page or nil.
+func (c *config) templateFor(page int) *pageTemplate {
+	if page == 1 && c.firstPageTemplate != nil {
+		return c.firstPageTemplate
+	}
+	return c.pageTemplate
+}
+
+// loadPageTemplates loads the PDF files of the page templates.
+func (d *Document) loadPageTemplates() error {
+	for _, t := range []*pageTemplate{d.cfg.firstPageTemplate, d.cfg.pageTemplate} {
+		if t == nil {
+			continue
+		}
+		f, err := d.Frontend.Doc.LoadImageFile(t.filename)
+		if err != nil {
+			return fmt.Errorf("page template: %w", err)
+		}
+		if t.page > f.NumberOfPages {
+			return fmt.Errorf("page template %s: page %d requested, the file has %d pages", t.filename, t.page, f.NumberOfPages)
+		}
+		t.img = d.Frontend.Doc.CreateImage(f, t.page, "/MediaBox")
+	}
+	return nil
+}
+
+// placePageTemplate draws the template of page on the current page unless
+// that page already has it.
+func (d *Document) placePageTemplate(page int) error {
+	cur := d.Frontend.Doc.CurrentPage
+	if cur == nil || cur == d.templatePage {
+		return nil
+	}
+	d.templatePage = cur
+	t := d.cfg.templateFor(page)
+	if t == nil {
+		return nil
+	}
+	dim, err := d.cssbuilder.PageSize()
+	if err != nil {
+		return err
+	}
+	img := node.NewImage()
+	img.Img = t.img
+	img.Width = dim.Width
+	img.Height = dim.Height
+	cur.OutputAt(0, dim.Height, node.Vpack(img))
+	return nil
+}
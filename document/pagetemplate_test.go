@@ -0,0 +1,121 @@
+package document
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+// letterheadPDF creates a one page PDF to be used as a page template.
+func letterheadPDF(t *testing.T) string {
+	t.Helper()
+	filename := tempPDF(t)
+	d, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OutputAt("<p>ACME Corp.</p>", bag.MustSP("10cm"), bag.MustSP("1cm"), bag.MustSP("28cm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestTemplateFor(t *testing.T) {
+	first := &pageTemplate{filename: "first.pdf", page: 1}
+	other := &pageTemplate{filename: "other.pdf", page: 2}
+	tests := []struct {
+		cfg   config
+		page  int
+		want  *pageTemplate
+		label string
+	}{
+		{config{}, 1, nil, "none"},
+		{config{pageTemplate: other}, 1, other, "every page, first"},
+		{config{pageTemplate: other}, 3, other, "every page, third"},
+		{config{firstPageTemplate: first}, 1, first, "first only, first"},
+		{config{firstPageTemplate: first}, 2, nil, "first only, second"},
+		{config{firstPageTemplate: first, pageTemplate: other}, 1, first, "both, first"},
+		{config{firstPageTemplate: first, pageTemplate: other}, 2, other, "both, second"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.templateFor(tt.page); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestPageTemplateErrors(t *testing.T) {
+	letterhead := letterheadPDF(t)
+	tests := []struct {
+		opt  Option
+		want string
+	}{
+		{WithPageTemplate(letterhead, 0), "invalid page number 0"},
+		{WithFirstPageTemplate(letterhead, -1), "invalid page number -1"},
+		{WithPageTemplate(letterhead, 2), "page 2 requested"},
+		{WithPageTemplate(tempPDF(t), 1), "page template"},
+	}
+	for _, tt := range tests {
+		_, err := New(tempPDF(t), tt.opt)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected error containing %q, got %v", tt.want, err)
+		}
+	}
+}
+
+func TestPageTemplateRenderPages(t *testing.T) {
+	letterhead := letterheadPDF(t)
+	d, err := New(tempPDF(t), WithFirstPageTemplate(letterhead, 1), WithPageTemplate(letterhead, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var templated []bool
+	d.PageInitCallback = func() {
+		templated = append(templated, d.templatePage == d.Frontend.Doc.CurrentPage)
+	}
+	if err := d.RenderPages(`<p>Page 1</p><p style="page-break-before: always">Page 2</p>`); err != nil {
+		t.Fatal(err)
+	}
+	if len(templated) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(templated))
+	}
+	for i, ok := range templated {
+		if !ok {
+			t.Errorf("page %d has no template", i+1)
+		}
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPageTemplateOutputAt(t *testing.T) {
+	letterhead := letterheadPDF(t)
+	d, err := New(tempPDF(t), WithPageTemplate(letterhead, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OutputAt("<p>Dear customer,</p>", bag.MustSP("15cm"), bag.MustSP("2cm"), bag.MustSP("20cm")); err != nil {
+		t.Fatal(err)
+	}
+	first := d.Frontend.Doc.CurrentPage
+	if d.templatePage != first {
+		t.Error("first page has no template")
+	}
+	if err := d.NewPage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.OutputAt("<p>Page 2</p>", bag.MustSP("15cm"), bag.MustSP("2cm"), bag.MustSP("27cm")); err != nil {
+		t.Fatal(err)
+	}
+	if d.templatePage == first || d.templatePage != d.Frontend.Doc.CurrentPage {
+		t.Error("second page has no template")
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,599 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+// ErrInvalidPayment is wrapped by the errors returned for invalid payment
+// data of a Swiss QR-bill or an EPC QR code.
+var ErrInvalidPayment = errors.New("invalid payment data")
+
+// Address is a structured postal address. Country is the two letter ISO
+// 3166-1 code.
+type Address struct {
+	Name        string
+	Street      string
+	HouseNumber string
+	PostalCode  string
+	City        string
+	Country     string
+}
+
+// SwissQRBill is the data of the payment part of a Swiss QR-bill as defined
+// by the Swiss Implementation Guidelines for the QR-bill, version 2.x.
+type SwissQRBill struct {
+	// Account is the IBAN or QR-IBAN of the creditor, CH or LI.
+	Account  string
+	Creditor Address
+	// Amount is a decimal number such as "1949.75". An empty amount
+	// leaves a field for the debtor to fill in.
+	Amount string
+	// Currency is CHF or EUR.
+	Currency string
+	// Debtor is optional; without a debtor the bill has a field for the
+	// name and address.
+	Debtor *Address
+	// Reference is a QR reference (27 digits), which requires a QR-IBAN,
+	// a creditor reference (ISO 11649, "RF…") or empty.
+	Reference string
+	// Message is an unstructured message, BillInformation structured
+	// billing information for the debtor's software. Together at most 140
+	// characters.
+	Message         string
+	BillInformation string
+	// Language of the headings: "de", "fr", "it" or "en" (default).
+	Language string
+}
+
+// EPCPayment is the data of an EPC QR code ("GiroCode") for a SEPA credit
+// transfer in euros, as defined by EPC069-12.
+type EPCPayment struct {
+	// Name of the beneficiary, at most 70 characters.
+	Name string
+	IBAN string
+	// BIC is optional within the EEA.
+	BIC string
+	// Amount in euros such as "12.50", empty lets the payer fill it in.
+	Amount string
+	// Purpose is an optional four letter purpose code.
+	Purpose string
+	// Reference is a structured creditor reference (ISO 11649), Text an
+	// unstructured remittance information of at most 140 characters. Only
+	// one of them may be set.
+	Reference string
+	Text      string
+}
+
+// AddSwissQRBill places the payment part with receipt of a Swiss QR-bill in
+// the bottom 105 mm of the last page of a following RenderPages, as
+// prescribed for A4 invoices. Keep this area free of content, for example
+// with a bottom margin or a spacer at the end of the document. The bill is
+// validated first; invalid data returns an error wrapping
+// ErrInvalidPayment.
+func (d *Document) AddSwissQRBill(bill SwissQRBill) error {
+	body, err := bill.html()
+	if err != nil {
+		return err
+	}
+	return d.OutputAtPage(LastPage, body, bag.MustSP("210mm"), 0, bag.MustSP("105mm"))
+}
+
+// AddEPCQRCode places an EPC QR code for the payment with the given width
+// at (x, y) on the last page of a following RenderPages. The EPC recommends
+// a width of at least 2 cm. Invalid payment data returns an error wrapping
+// ErrInvalidPayment.
+func (d *Document) AddEPCQRCode(p EPCPayment, width, x, y bag.ScaledPoint) error {
+	payload, err := p.Payload()
+	if err != nil {
+		return err
+	}
+	bc, err := encodeBarcode("qr", payload)
+	if err != nil {
+		return err
+	}
+	n := bc.Bounds().Dx() + 8
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%gpt" height="%gpt" viewBox="0 0 %d %d"><title>EPC QR code</title><path fill="black" d="%s"/></svg>`,
+		width.ToPT(), width.ToPT(), n, n, barcodePath(bc, 4))
+	return d.OutputAtPage(LastPage, svg, width, x, y)
+}
+
+// Payload returns the text encoded in the QR code of the bill.
+func (b SwissQRBill) Payload() (string, error) {
+	if err := b.Validate(); err != nil {
+		return "", err
+	}
+	amount, _ := normalizeAmount(b.Amount)
+	lines := []string{"SPC", "0200", "1", compactIBAN(b.Account)}
+	lines = append(lines, addressFields(&b.Creditor)...)
+	lines = append(lines, "", "", "", "", "", "", "") // ultimate creditor
+	lines = append(lines, amount, strings.ToUpper(b.Currency))
+	lines = append(lines, addressFields(b.Debtor)...)
+	lines = append(lines, b.referenceType(), compactReference(b.Reference), b.Message, "EPD")
+	if b.BillInformation != "" {
+		lines = append(lines, b.BillInformation)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Validate checks the account, the references, the amount and the length
+// and the characters of the fields of the bill. Text fields may only
+// contain the characters of the Swiss Implementation Guidelines: printable
+// ASCII and the accented letters of the Latin-1 supplement listed there.
+func (b SwissQRBill) Validate() error {
+	iban := compactIBAN(b.Account)
+	if err := validateIBAN(iban); err != nil {
+		return err
+	}
+	if len(iban) != 21 || iban[:2] != "CH" && iban[:2] != "LI" {
+		return paymentError("account %s: only CH and LI IBANs are allowed", b.Account)
+	}
+	if err := validateAddress("creditor", &b.Creditor); err != nil {
+		return err
+	}
+	if b.Debtor != nil {
+		if err := validateAddress("debtor", b.Debtor); err != nil {
+			return err
+		}
+	}
+	if _, err := normalizeAmount(b.Amount); err != nil {
+		return err
+	}
+	switch strings.ToUpper(b.Currency) {
+	case "CHF", "EUR":
+	default:
+		return paymentError("currency %q: only CHF and EUR are allowed", b.Currency)
+	}
+	ref := compactReference(b.Reference)
+	switch {
+	case isQRIBAN(iban) && ref == "":
+		return paymentError("a QR-IBAN requires a QR reference")
+	case isQRIBAN(iban):
+		if !validQRReference(ref) {
+			return paymentError("QR reference %s: wrong format or check digit", b.Reference)
+		}
+	case ref == "":
+	case strings.HasPrefix(ref, "RF"):
+		if !validCreditorReference(ref) {
+			return paymentError("creditor reference %s: wrong format or check digit", b.Reference)
+		}
+	default:
+		return paymentError("reference %s: a QR reference requires a QR-IBAN, other references must start with RF", b.Reference)
+	}
+	if err := checkCharacters("message", b.Message, sixCharacter); err != nil {
+		return err
+	}
+	if err := checkCharacters("bill information", b.BillInformation, sixCharacter); err != nil {
+		return err
+	}
+	if n := utf8.RuneCountInString(b.Message) + utf8.RuneCountInString(b.BillInformation); n > 140 {
+		return paymentError("message and bill information have %d characters, at most 140 are allowed", n)
+	}
+	return nil
+}
+
+// referenceType returns QRR, SCOR or NON.
+func (b SwissQRBill) referenceType() string {
+	switch ref := compactReference(b.Reference); {
+	case ref == "":
+		return "NON"
+	case strings.HasPrefix(ref, "RF"):
+		return "SCOR"
+	}
+	return "QRR"
+}
+
+// Payload returns the text encoded in the EPC QR code.
+func (p EPCPayment) Payload() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	amount, _ := normalizeAmount(p.Amount)
+	if amount != "" {
+		amount = "EUR" + amount
+	}
+	lines := []string{"BCD", "002", "1", "SCT", strings.ToUpper(strings.TrimSpace(p.BIC)), p.Name, compactIBAN(p.IBAN),
+		amount, strings.ToUpper(p.Purpose), compactReference(p.Reference), p.Text}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	payload := strings.Join(lines, "\n")
+	if len(payload) > 331 {
+		return "", paymentError("EPC payload has %d bytes, at most 331 are allowed", len(payload))
+	}
+	return payload, nil
+}
+
+var (
+	bicPattern     = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	purposePattern = regexp.MustCompile(`^[A-Z0-9]{4}$`)
+)
+
+// Validate checks the IBAN, BIC, amount, references and the length and the
+// characters of the fields of the payment. Name and Text may only contain
+// printable ISO 8859-1 characters.
+func (p EPCPayment) Validate() error {
+	if n := utf8.RuneCountInString(p.Name); n == 0 || n > 70 {
+		return paymentError("beneficiary name must have 1 to 70 characters")
+	}
+	if err := checkCharacters("beneficiary name", p.Name, epcCharacter); err != nil {
+		return err
+	}
+	if err := checkCharacters("text", p.Text, epcCharacter); err != nil {
+		return err
+	}
+	if err := validateIBAN(compactIBAN(p.IBAN)); err != nil {
+		return err
+	}
+	if bic := strings.ToUpper(strings.TrimSpace(p.BIC)); bic != "" && !bicPattern.MatchString(bic) {
+		return paymentError("BIC %s: wrong format", p.BIC)
+	}
+	if _, err := normalizeAmount(p.Amount); err != nil {
+		return err
+	}
+	if p.Purpose != "" && !purposePattern.MatchString(strings.ToUpper(p.Purpose)) {
+		return paymentError("purpose %q must be a four character code", p.Purpose)
+	}
+	ref := compactReference(p.Reference)
+	switch {
+	case ref != "" && p.Text != "":
+		return paymentError("only one of reference and text may be set")
+	case ref != "" && !validCreditorReference(ref):
+		return paymentError("creditor reference %s: wrong format or check digit", p.Reference)
+	case utf8.RuneCountInString(p.Text) > 140:
+		return paymentError("text must have at most 140 characters")
+	}
+	return nil
+}
+
+// sixExtraCharacters are the characters besides printable ASCII that the
+// Swiss Implementation Guidelines allow in the QR code.
+const sixExtraCharacters = "£´ÀÁÂÄÇÈÉÊËÌÍÎÏÑÒÓÔÖÙÚÛÜÝßàáâäçèéêëìíîïñòóôöùúûüý"
+
+// sixCharacter reports whether a QR-bill may contain r.
+func sixCharacter(r rune) bool {
+	return r >= 0x20 && r <= 0x7e || strings.ContainsRune(sixExtraCharacters, r)
+}
+
+// epcCharacter reports whether an EPC QR code may contain r. Line breaks
+// separate the fields of the payload.
+func epcCharacter(r rune) bool {
+	return r >= 0x20 && r <= 0x7e || r >= 0xa0 && r <= 0xff
+}
+
+// checkCharacters returns an error if the field value contains a
+// character that allowed rejects, such as a line break, which would start a
+// new field of the payload.
+func checkCharacters(field, value string, allowed func(rune) bool) error {
+	for _, r := range value {
+		if !allowed(r) {
+			return paymentError("%s contains the character %q, which is not allowed", field, r)
+		}
+	}
+	return nil
+}
+
+func paymentError(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPayment, fmt.Sprintf(format, a...))
+}
+
+// compactIBAN removes the spaces of a printed IBAN.
+func compactIBAN(iban string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(iban), ""))
+}
+
+// compactReference removes the spaces of a printed reference.
+func compactReference(ref string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(ref), ""))
+}
+
+var ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+
+// validateIBAN checks the format and the check digits of a compact IBAN.
+func validateIBAN(iban string) error {
+	if !ibanPattern.MatchString(iban) {
+		return paymentError("IBAN %s: wrong format", iban)
+	}
+	if mod97(iban[4:]+iban[:4]) != 1 {
+		return paymentError("IBAN %s: wrong check digits", iban)
+	}
+	return nil
+}
+
+// isQRIBAN reports whether the compact Swiss IBAN is a QR-IBAN, whose
+// institution id is in the range 30000 to 31999.
+func isQRIBAN(iban string) bool {
+	return len(iban) == 21 && (iban[4:6] == "30" || iban[4:6] == "31")
+}
+
+// mod97 returns s modulo 97, where letters count as the numbers 10 to 35
+// (ISO 7064 MOD 97-10 as used by IBANs and creditor references).
+func mod97(s string) int {
+	r := 0
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			r = (r*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			r = (r*100 + int(c-'A') + 10) % 97
+		default:
+			return -1
+		}
+	}
+	return r
+}
+
+var creditorReferencePattern = regexp.MustCompile(`^RF[0-9]{2}[A-Z0-9]{1,21}$`)
+
+// validCreditorReference checks an ISO 11649 creditor reference.
+func validCreditorReference(ref string) bool {
+	return creditorReferencePattern.MatchString(ref) && mod97(ref[4:]+ref[:4]) == 1
+}
+
+// validQRReference checks the format and the recursive modulo 10 check
+// digit of a 27 digit QR reference.
+func validQRReference(ref string) bool {
+	if len(ref) != 27 {
+		return false
+	}
+	table := [10]int{0, 9, 4, 6, 8, 2, 7, 1, 3, 5}
+	carry := 0
+	for _, c := range ref[:26] {
+		if c < '0' || c > '9' {
+			return false
+		}
+		carry = table[(carry+int(c-'0'))%10]
+	}
+	return int(ref[26]-'0') == (10-carry)%10
+}
+
+var amountPattern = regexp.MustCompile(`^[0-9]{1,9}(\.[0-9]{1,2})?$`)
+
+// normalizeAmount returns amount with two decimals. Amounts must be between
+// 0.01 and 999999999.99; an empty amount stays empty.
+func normalizeAmount(amount string) (string, error) {
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return "", nil
+	}
+	if !amountPattern.MatchString(amount) {
+		return "", paymentError("amount %q: use digits and a decimal point with up to two decimals", amount)
+	}
+	whole, frac, _ := strings.Cut(amount, ".")
+	frac = (frac + "00")[:2]
+	whole = strings.TrimLeft(whole, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	if whole == "0" && frac == "00" {
+		return "", paymentError("amount must be at least 0.01")
+	}
+	return whole + "." + frac, nil
+}
+
+// validateAddress checks the required fields and the field lengths of a
+// structured address.
+func validateAddress(role string, a *Address) error {
+	fields := []struct {
+		name, value string
+		max         int
+		required    bool
+	}{
+		{"name", a.Name, 70, true},
+		{"street", a.Street, 70, false},
+		{"house number", a.HouseNumber, 16, false},
+		{"postal code", a.PostalCode, 16, true},
+		{"city", a.City, 35, true},
+	}
+	for _, f := range fields {
+		n := utf8.RuneCountInString(strings.TrimSpace(f.value))
+		if f.required && n == 0 {
+			return paymentError("%s %s is missing", role, f.name)
+		}
+		if n > f.max {
+			return paymentError("%s %s has %d characters, at most %d are allowed", role, f.name, n, f.max)
+		}
+		if err := checkCharacters(role+" "+f.name, f.value, sixCharacter); err != nil {
+			return err
+		}
+	}
+	if len(a.Country) != 2 || strings.ToUpper(a.Country) != a.Country || strings.Trim(a.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return paymentError("%s country %q must be a two letter ISO code", role, a.Country)
+	}
+	return nil
+}
+
+// addressFields returns the seven payload fields of a structured address,
+// or seven empty fields for nil.
+func addressFields(a *Address) []string {
+	if a == nil {
+		return []string{"", "", "", "", "", "", ""}
+	}
+	return []string{"S", a.Name, a.Street, a.HouseNumber, a.PostalCode, a.City, a.Country}
+}
+
+// qrBillLabels are the headings of the QR-bill in the supported languages.
+var qrBillLabels = map[string][10]string{
+	"en": {"Receipt", "Payment part", "Account / Payable to", "Reference", "Additional information", "Payable by", "Payable by (name/address)", "Currency", "Amount", "Acceptance point"},
+	"de": {"Empfangsschein", "Zahlteil", "Konto / Zahlbar an", "Referenz", "Zusätzliche Informationen", "Zahlbar durch", "Zahlbar durch (Name/Adresse)", "Währung", "Betrag", "Annahmestelle"},
+	"fr": {"Récépissé", "Section paiement", "Compte / Payable à", "Référence", "Informations supplémentaires", "Payable par", "Payable par (nom/adresse)", "Monnaie", "Montant", "Point de dépôt"},
+	"it": {"Ricevuta", "Sezione pagamento", "Conto / Pagabile a", "Riferimento", "Informazioni supplementari", "Pagabile da", "Pagabile da (nome/indirizzo)", "Valuta", "Importo", "Punto di accettazione"},
+}
+
+const (
+	lblReceipt = iota
+	lblPaymentPart
+	lblAccount
+	lblReference
+	lblInformation
+	lblPayableBy
+	lblPayableByBlank
+	lblCurrency
+	lblAmount
+	lblAcceptance
+)
+
+// html returns the receipt and the payment part of the bill, 210 × 105 mm,
+// with the headings and font sizes of the style guide. Empty amount and
+// debtor fields are drawn as boxes.
+func (b SwissQRBill) html() (string, error) {
+	payload, err := b.Payload()
+	if err != nil {
+		return "", err
+	}
+	lbl, ok := qrBillLabels[strings.ToLower(b.Language)]
+	if !ok {
+		lbl = qrBillLabels["en"]
+	}
+	qr, err := swissQRCodeSVG(payload)
+	if err != nil {
+		return "", err
+	}
+	amount, _ := normalizeAmount(b.Amount)
+	esc := html.EscapeString
+	heading := func(size, text string) string {
+		return `<div style="font-size: ` + size + `; line-height: 1.2; font-weight: bold; margin: 0; padding-top: 1mm">` + esc(text) + `</div>`
+	}
+	value := func(size string, lines ...string) string {
+		var parts []string
+		for _, l := range lines {
+			if l != "" {
+				parts = append(parts, esc(l))
+			}
+		}
+		return `<div style="font-size: ` + size + `; line-height: 1.2; margin: 0">` + strings.Join(parts, "<br>") + `</div>`
+	}
+	box := func(w, h string) string {
+		return `<div style="width: ` + w + `; height: ` + h + `; border: 0.75pt solid black; margin-top: 1mm"></div>`
+	}
+	info := func(hs, vs string, receipt bool) string {
+		var sb strings.Builder
+		sb.WriteString(heading(hs, lbl[lblAccount]))
+		sb.WriteString(value(vs, append([]string{formatIBAN(compactIBAN(b.Account))}, addressLines(&b.Creditor)...)...))
+		if ref := compactReference(b.Reference); ref != "" {
+			sb.WriteString(heading(hs, lbl[lblReference]))
+			sb.WriteString(value(vs, formatReference(ref)))
+		}
+		if !receipt && (b.Message != "" || b.BillInformation != "") {
+			sb.WriteString(heading(hs, lbl[lblInformation]))
+			sb.WriteString(value(vs, b.Message, b.BillInformation))
+		}
+		if b.Debtor != nil {
+			sb.WriteString(heading(hs, lbl[lblPayableBy]))
+			sb.WriteString(value(vs, addressLines(b.Debtor)...))
+		} else {
+			sb.WriteString(heading(hs, lbl[lblPayableByBlank]))
+			if receipt {
+				sb.WriteString(box("52mm", "20mm"))
+			} else {
+				sb.WriteString(box("65mm", "25mm"))
+			}
+		}
+		return sb.String()
+	}
+	amountFields := func(hs, vs, boxW, boxH string) string {
+		a := value(vs, formatAmount(amount))
+		if amount == "" {
+			a = box(boxW, boxH)
+		}
+		return `<table style="border-collapse: collapse; margin-top: 2mm"><tr>` +
+			`<td style="padding: 0 4mm 0 0; vertical-align: top">` + heading(hs, lbl[lblCurrency]) + value(vs, strings.ToUpper(b.Currency)) + `</td>` +
+			`<td style="padding: 0; vertical-align: top">` + heading(hs, lbl[lblAmount]) + a + `</td></tr></table>`
+	}
+	var sb strings.Builder
+	sb.WriteString(`<table style="width: 210mm; border-collapse: collapse; font-family: sans-serif"><tr>`)
+	// Receipt
+	sb.WriteString(`<td style="width: 52mm; height: 95mm; padding: 5mm; vertical-align: top; border-top: 0.5pt dashed black; border-right: 0.5pt dashed black">`)
+	sb.WriteString(`<div style="font-size: 11pt; font-weight: bold; margin: 0 0 2mm 0">` + esc(lbl[lblReceipt]) + `</div>`)
+	sb.WriteString(info("6pt", "8pt", true))
+	sb.WriteString(amountFields("6pt", "8pt", "30mm", "10mm"))
+	sb.WriteString(`<div style="font-size: 6pt; font-weight: bold; text-align: right; margin-top: 3mm">` + esc(lbl[lblAcceptance]) + `</div>`)
+	sb.WriteString(`</td>`)
+	// Payment part
+	sb.WriteString(`<td style="width: 138mm; height: 95mm; padding: 5mm; vertical-align: top; border-top: 0.5pt dashed black">`)
+	sb.WriteString(`<table style="border-collapse: collapse"><tr><td style="width: 51mm; padding: 0; vertical-align: top">`)
+	sb.WriteString(`<div style="font-size: 11pt; font-weight: bold; margin: 0 0 5mm 0">` + esc(lbl[lblPaymentPart]) + `</div>`)
+	sb.WriteString(qr)
+	sb.WriteString(amountFields("8pt", "10pt", "40mm", "15mm"))
+	sb.WriteString(`</td><td style="width: 87mm; padding: 0; vertical-align: top">`)
+	sb.WriteString(info("8pt", "10pt", false))
+	sb.WriteString(`</td></tr></table></td></tr></table>`)
+	return sb.String(), nil
+}
+
+// swissQRCodeSVG returns the 46 × 46 mm QR code of a QR-bill with the Swiss
+// cross in the center.
+func swissQRCodeSVG(payload string) (string, error) {
+	bc, err := encodeBarcode("qr", payload)
+	if err != nil {
+		return "", err
+	}
+	n := float64(bc.Bounds().Dx())
+	// The cross logo is 7 mm wide: a white frame around a black square
+	// with the cross of the Swiss flag (20 × 20 units of a 32 unit square).
+	logo := n * 7 / 46
+	black := logo * 6 / 7
+	u := black / 32
+	c := n / 2
+	rect := func(x, y, w, h float64) string {
+		return fmt.Sprintf("M%g %gh%gv%gh%gz", x, y, w, h, -w)
+	}
+	cross := rect(c-10*u, c-3*u, 20*u, 6*u) + rect(c-3*u, c-10*u, 6*u, 20*u)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="46mm" height="46mm" viewBox="0 0 %g %g"><title>Swiss QR Code</title>`+
+		`<path fill="black" d="%s"/><path fill="white" d="%s"/><path fill="black" d="%s"/><path fill="white" d="%s"/></svg>`,
+		n, n, barcodePath(bc, 0), rect(c-logo/2, c-logo/2, logo, logo), rect(c-black/2, c-black/2, black, black), cross), nil
+}
+
+// addressLines returns the printed lines of an address.
+func addressLines(a *Address) []string {
+	return []string{
+		a.Name,
+		strings.TrimSpace(a.Street + " " + a.HouseNumber),
+		strings.TrimSpace(a.PostalCode + " " + a.City),
+	}
+}
+
+// formatIBAN groups a compact IBAN in blocks of four characters.
+func formatIBAN(iban string) string {
+	var parts []string
+	for len(iban) > 4 {
+		parts, iban = append(parts, iban[:4]), iban[4:]
+	}
+	return strings.Join(append(parts, iban), " ")
+}
+
+// formatReference groups a QR reference in blocks of five digits from the
+// right and a creditor reference in blocks of four characters.
+func formatReference(ref string) string {
+	if strings.HasPrefix(ref, "RF") {
+		return formatIBAN(ref)
+	}
+	var parts []string
+	for len(ref) > 5 {
+		parts, ref = append([]string{ref[len(ref)-5:]}, parts...), ref[:len(ref)-5]
+	}
+	return strings.Join(append([]string{ref}, parts...), " ")
+}
+
+// formatAmount groups the whole part of an amount such as 1949.75 in
+// thousands separated by spaces.
+func formatAmount(amount string) string {
+	if amount == "" {
+		return ""
+	}
+	whole, frac, _ := strings.Cut(amount, ".")
+	var sb strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String() + "." + frac
+}
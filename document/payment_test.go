@@ -0,0 +1,198 @@
+package document
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+func testBill() SwissQRBill {
+	return SwissQRBill{
+		Account:   "CH44 3199 9123 0008 8901 2",
+		Creditor:  Address{Name: "Robert Schneider AG", Street: "Rue du Lac", HouseNumber: "1268", PostalCode: "2501", City: "Biel", Country: "CH"},
+		Amount:    "1949.75",
+		Currency:  "CHF",
+		Debtor:    &Address{Name: "Pia-Maria Rutschmann-Schnyder", Street: "Grosse Marktgasse", HouseNumber: "28", PostalCode: "9400", City: "Rorschach", Country: "CH"},
+		Reference: "21 00000 00003 13947 14300 09017",
+		Message:   "Order of 15 June 2020",
+	}
+}
+
+func TestSwissQRBillPayload(t *testing.T) {
+	got, err := testBill().Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"SPC", "0200", "1", "CH4431999123000889012",
+		"S", "Robert Schneider AG", "Rue du Lac", "1268", "2501", "Biel", "CH",
+		"", "", "", "", "", "", "",
+		"1949.75", "CHF",
+		"S", "Pia-Maria Rutschmann-Schnyder", "Grosse Marktgasse", "28", "9400", "Rorschach", "CH",
+		"QRR", "210000000003139471430009017", "Order of 15 June 2020", "EPD",
+	}, "\n")
+	if got != want {
+		t.Errorf("unexpected payload\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestSwissQRBillValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(b *SwissQRBill)
+		ok     bool
+	}{
+		{"valid", func(b *SwissQRBill) {}, true},
+		{"open amount without debtor", func(b *SwissQRBill) { b.Amount, b.Debtor = "", nil }, true},
+		{"IBAN with creditor reference", func(b *SwissQRBill) {
+			b.Account, b.Reference = "CH93 0076 2011 6238 5295 7", "RF18 5390 0754 7034"
+		}, true},
+		{"IBAN without reference", func(b *SwissQRBill) { b.Account, b.Reference = "CH9300762011623852957", "" }, true},
+		{"wrong IBAN check digits", func(b *SwissQRBill) { b.Account = "CH45 3199 9123 0008 8901 2" }, false},
+		{"foreign IBAN", func(b *SwissQRBill) { b.Account, b.Reference = "DE89 3704 0044 0532 0130 00", "" }, false},
+		{"QR-IBAN without QR reference", func(b *SwissQRBill) { b.Reference = "" }, false},
+		{"QR-IBAN with creditor reference", func(b *SwissQRBill) { b.Reference = "RF18539007547034" }, false},
+		{"wrong QR reference check digit", func(b *SwissQRBill) { b.Reference = "210000000003139471430009018" }, false},
+		{"QR reference with IBAN", func(b *SwissQRBill) { b.Account = "CH9300762011623852957" }, false},
+		{"wrong creditor reference", func(b *SwissQRBill) { b.Account, b.Reference = "CH9300762011623852957", "RF19539007547034" }, false},
+		{"amount with three decimals", func(b *SwissQRBill) { b.Amount = "1.234" }, false},
+		{"amount zero", func(b *SwissQRBill) { b.Amount = "0.00" }, false},
+		{"amount with comma", func(b *SwissQRBill) { b.Amount = "1949,75" }, false},
+		{"currency", func(b *SwissQRBill) { b.Currency = "USD" }, false},
+		{"missing creditor city", func(b *SwissQRBill) { b.Creditor.City = "" }, false},
+		{"lower case country", func(b *SwissQRBill) { b.Debtor.Country = "ch" }, false},
+		{"message too long", func(b *SwissQRBill) { b.Message = strings.Repeat("x", 141) }, false},
+		{"accented letters", func(b *SwissQRBill) { b.Creditor.City, b.Message = "Zürich", "Commande 42, £ à payer" }, true},
+		{"line break in message", func(b *SwissQRBill) { b.Message = "Order\nEPD" }, false},
+		{"carriage return in message", func(b *SwissQRBill) { b.Message = "Order\r" }, false},
+		{"line break in bill information", func(b *SwissQRBill) { b.BillInformation = "//S1/10/1\n" }, false},
+		{"line break in creditor name", func(b *SwissQRBill) { b.Creditor.Name = "Robert\nSchneider" }, false},
+		{"line break in creditor street", func(b *SwissQRBill) { b.Creditor.Street = "Rue\r\ndu Lac" }, false},
+		{"line break in debtor house number", func(b *SwissQRBill) { b.Debtor.HouseNumber = "28\n" }, false},
+		{"line break in debtor postal code", func(b *SwissQRBill) { b.Debtor.PostalCode = "9400\n" }, false},
+		{"line break in debtor city", func(b *SwissQRBill) { b.Debtor.City = "Ror\nschach" }, false},
+		{"character outside the set in message", func(b *SwissQRBill) { b.Message = "Total €" }, false},
+		{"character outside the set in name", func(b *SwissQRBill) { b.Creditor.Name = "Łukasz" }, false},
+		{"tab in bill information", func(b *SwissQRBill) { b.BillInformation = "a\tb" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBill()
+			d := *b.Debtor
+			b.Debtor = &d
+			tt.modify(&b)
+			err := b.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidPayment) {
+				t.Errorf("expected ErrInvalidPayment, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEPCPayload(t *testing.T) {
+	p := EPCPayment{Name: "Red Cross of Belgium", IBAN: "BE72 0000 0000 1616", BIC: "bpotbeb1", Amount: "1", Text: "Urgency fund"}
+	got, err := p.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BCD\n002\n1\nSCT\nBPOTBEB1\nRed Cross of Belgium\nBE72000000001616\nEUR1.00\n\n\nUrgency fund"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	p.Text, p.Amount = "", ""
+	if got, _ := p.Payload(); got != "BCD\n002\n1\nSCT\nBPOTBEB1\nRed Cross of Belgium\nBE72000000001616" {
+		t.Errorf("trailing empty fields not removed: %q", got)
+	}
+}
+
+func TestEPCValidate(t *testing.T) {
+	valid := EPCPayment{Name: "ACME", IBAN: "DE89370400440532013000"}
+	tests := []struct {
+		name   string
+		modify func(p *EPCPayment)
+		ok     bool
+	}{
+		{"valid", func(p *EPCPayment) {}, true},
+		{"with reference", func(p *EPCPayment) { p.Reference, p.Purpose = "RF18539007547034", "GDDS" }, true},
+		{"no name", func(p *EPCPayment) { p.Name = "" }, false},
+		{"bad IBAN", func(p *EPCPayment) { p.IBAN = "DE88370400440532013000" }, false},
+		{"bad BIC", func(p *EPCPayment) { p.BIC = "COBA" }, false},
+		{"reference and text", func(p *EPCPayment) { p.Reference, p.Text = "RF18539007547034", "x" }, false},
+		{"purpose", func(p *EPCPayment) { p.Purpose = "GOODS" }, false},
+		{"amount too large", func(p *EPCPayment) { p.Amount = "1000000000" }, false},
+		{"accented name", func(p *EPCPayment) { p.Name, p.Text = "Müller & Söhne", "Rechnung Nr. 1 für Märzen" }, true},
+		{"line break in name", func(p *EPCPayment) { p.Name = "ACME\nDE00" }, false},
+		{"carriage return in name", func(p *EPCPayment) { p.Name = "ACME\r" }, false},
+		{"line break in text", func(p *EPCPayment) { p.Text = "Invoice\n42" }, false},
+		{"character outside the set in name", func(p *EPCPayment) { p.Name = "ACME €" }, false},
+		{"character outside the set in text", func(p *EPCPayment) { p.Text = "Invoice → 42" }, false},
+	}
+	for _, tt := range tests {
+		p := valid
+		tt.modify(&p)
+		err := p.Validate()
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: unexpected result %v", tt.name, err)
+		}
+	}
+}
+
+func TestPaymentFormatting(t *testing.T) {
+	if got := formatReference("210000000003139471430009017"); got != "21 00000 00003 13947 14300 09017" {
+		t.Errorf("unexpected QR reference %q", got)
+	}
+	if got := formatReference("RF18539007547034"); got != "RF18 5390 0754 7034" {
+		t.Errorf("unexpected creditor reference %q", got)
+	}
+	if got := formatIBAN("CH4431999123000889012"); got != "CH44 3199 9123 0008 8901 2" {
+		t.Errorf("unexpected IBAN %q", got)
+	}
+	if got := formatAmount("1949.75"); got != "1 949.75" {
+		t.Errorf("unexpected amount %q", got)
+	}
+	if got, _ := normalizeAmount("0050.5"); got != "50.50" {
+		t.Errorf("unexpected amount %q", got)
+	}
+}
+
+func TestSwissQRBillHTML(t *testing.T) {
+	b := testBill()
+	b.Language, b.Debtor, b.Amount = "de", nil, ""
+	s, err := b.html()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Empfangsschein", "Zahlteil", "Zahlbar durch (Name/Adresse)", `width="46mm" height="46mm"`, "CH44 3199 9123 0008 8901 2", "Rue du Lac 1268", "2501 Biel"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in bill", want)
+		}
+	}
+}
+
+func TestAddSwissQRBill(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddSwissQRBill(SwissQRBill{Account: "CH44"}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("expected ErrInvalidPayment, got %v", err)
+	}
+	if err := d.AddSwissQRBill(testBill()); err != nil {
+		t.Fatal(err)
+	}
+	p := EPCPayment{Name: "ACME", IBAN: "DE89370400440532013000", Amount: "12.5"}
+	if err := d.AddEPCQRCode(p, bag.MustSP("3cm"), bag.MustSP("15cm"), bag.MustSP("20cm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<h1>Invoice</h1>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Violation describes a single prerequisite of a PDF standard that the
+// document does not meet.
+type Violation struct {
+	// Rule is a short identifier such as "ua-title" or "ua-img-alt".
+	Rule string
+	// Element is the start tag of the offending HTML element. It is empty
+	// for document-level problems such as a missing title.
+	Element string
+	// Message is a human readable description of the problem.
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Element == "" {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", v.Rule, v.Element, v.Message)
+}
+
+// PreflightError lists every violation found by Preflight.
+type PreflightError struct {
+	Violations []Violation
+}
+
+func (e *PreflightError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("preflight failed with %d violation(s): %s", len(e.Violations), strings.Join(msgs, "; "))
+}
+
+// Preflight checks the prerequisites of the PDF standards selected with
+// WithPDFUA(), WithPDFUA2(), WithPDFA3b(), WithPDFX3() or WithPDFX4()
+// against the document metadata, the attachments and all HTML passed to
+// RenderPages and OutputAt so far. It returns nil or a *PreflightError.
+// Finish calls Preflight automatically when one of these standards is
+// requested.
+//
+// For PDF/UA the document must have a title and a language, every <img>
+// must have an alt attribute and heading levels must not be skipped. For
+// PDF/A every attachment needs a name and a MIME type. PDF/X requires a
+// title.
+func (d *Document) Preflight() error {
+	format := d.Frontend.Doc.Format
+	var vs []Violation
+	if format.IsPDFUA() {
+		if d.Title == "" {
+			vs = append(vs, Violation{Rule: "ua-title", Message: "PDF/UA requires a document title"})
+		}
+		if d.Language == "" {
+			vs = append(vs, Violation{Rule: "ua-language", Message: "PDF/UA requires a document language"})
+		}
+		vs = append(vs, d.preflightHTML()...)
+	}
+	if format.IsPDFA() {
+		for _, a := range d.attachments {
+			if a.Name == "" {
+				vs = append(vs, Violation{Rule: "a-attachment-name", Message: "PDF/A requires a file name for every attachment"})
+			}
+			if a.MimeType == "" {
+				vs = append(vs, Violation{Rule: "a-attachment-mime", Message: fmt.Sprintf("PDF/A requires a MIME type for attachment %q", a.Name)})
+			}
+		}
+	}
+	if format.PDFX != nil && d.Title == "" {
+		vs = append(vs, Violation{Rule: "x-title", Message: "PDF/X requires a document title"})
+	}
+	if len(vs) == 0 {
+		return nil
+	}
+	return &PreflightError{Violations: vs}
+}
+
+// preflightHTML checks the rendered HTML for accessibility problems. The
+// heading levels are checked for each HTML source on its own.
+func (d *Document) preflightHTML() []Violation {
+	var vs []Violation
+	var prevLevel int
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Img:
+				if !hasAttr(n, "alt") {
+					vs = append(vs, Violation{Rule: "ua-img-alt", Element: startTag(n), Message: "image without alt text"})
+				}
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				level := headingLevel(n)
+				if level > prevLevel+1 {
+					vs = append(vs, Violation{
+						Rule:    "ua-heading-level",
+						Element: startTag(n),
+						Message: fmt.Sprintf("heading level %d follows level %d", level, prevLevel),
+					})
+				}
+				prevLevel = level
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, src := range d.sources {
+		doc, err := html.Parse(strings.NewReader(src))
+		if err != nil {
+			// RenderPages and OutputAt have already reported the error.
+			continue
+		}
+		prevLevel = 0
+		walk(doc)
+	}
+	return vs
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// startTag formats the start tag of n including its attributes.
+func startTag(n *html.Node) string {
+	var sb strings.Builder
+	sb.WriteString("<")
+	sb.WriteString(n.Data)
+	for _, a := range n.Attr {
+		fmt.Fprintf(&sb, " %s=%q", a.Key, a.Val)
+	}
+	sb.WriteString(">")
+	return sb.String()
+}
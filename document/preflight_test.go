@@ -0,0 +1,174 @@
+package document
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testPNG writes a 1x1 PNG image and returns its path.
+func testPNG(t *testing.T) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "pixel.png")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func rules(t *testing.T, err error) []string {
+	t.Helper()
+	var pe *PreflightError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *PreflightError, got %v", err)
+	}
+	var r []string
+	for _, v := range pe.Violations {
+		r = append(r, v.Rule)
+	}
+	return r
+}
+
+func TestPreflightPDFUA(t *testing.T) {
+	d, err := New(tempPDF(t), WithPDFUA())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testPNG(t)
+	html := `<h1>Title</h1><img src="` + img + `"><h3>Skipped</h3><img src="` + img + `" alt="">`
+	if err := d.RenderPages(html); err != nil {
+		t.Fatal(err)
+	}
+	err = d.Finish()
+	got := rules(t, err)
+	want := []string{"ua-title", "ua-language", "ua-img-alt", "ua-heading-level"}
+	if len(got) != len(want) {
+		t.Fatalf("expected rules %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("violation %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+	var pe *PreflightError
+	errors.As(err, &pe)
+	if el := pe.Violations[2].Element; !strings.HasPrefix(el, `<img src=`) {
+		t.Errorf("unexpected offending element %q", el)
+	}
+	if el := pe.Violations[3].Element; el != `<h3>` {
+		t.Errorf("unexpected offending element %q", el)
+	}
+}
+
+func TestPreflightPDFUAValid(t *testing.T) {
+	d, err := New(tempPDF(t), WithPDFUA2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Title = "Valid"
+	d.Language = "en"
+	img := testPNG(t)
+	if err := d.RenderPages(`<h1>A</h1><h2>B</h2><img src="` + img + `" alt="X"><h1>C</h1>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Preflight(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreflightPDFA(t *testing.T) {
+	d, err := New(tempPDF(t), WithPDFA3b())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AttachFile(Attachment{Name: "data.bin", Data: []byte{1, 2, 3}})
+	if err := d.RenderPages("<p>Archive</p>"); err != nil {
+		t.Fatal(err)
+	}
+	got := rules(t, d.Preflight())
+	if len(got) != 1 || got[0] != "a-attachment-mime" {
+		t.Errorf("expected a-attachment-mime, got %v", got)
+	}
+}
+
+func TestPreflightPDFX(t *testing.T) {
+	d, err := New(tempPDF(t), WithPDFX4())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages("<p>Print</p>"); err != nil {
+		t.Fatal(err)
+	}
+	got := rules(t, d.Finish())
+	if len(got) != 1 || got[0] != "x-title" {
+		t.Errorf("expected x-title, got %v", got)
+	}
+}
+
+func TestPreflightPlainPDF(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testPNG(t)
+	if err := d.RenderPages(`<h3>No rules</h3><img src="` + img + `">`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Preflight(); err != nil {
+		t.Errorf("plain PDF should pass preflight, got %v", err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreflightHeadingLevelsPerSource(t *testing.T) {
+	d := &Document{sources: []string{
+		`<h1>A</h1><h2>B</h2><h3>C</h3>`,
+		`<h1>D</h1><h2>E</h2>`,
+		`<h2>F</h2>`,
+	}}
+	vs := d.preflightHTML()
+	if len(vs) != 1 {
+		t.Fatalf("expected one violation, got %v", vs)
+	}
+	if vs[0].Element != `<h2>` || vs[0].Message != "heading level 2 follows level 0" {
+		t.Errorf("unexpected violation %+v", vs[0])
+	}
+}
+
+func TestFinishPreflightRemovesLocalFiles(t *testing.T) {
+	png, err := os.ReadFile(testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(tempPDF(t), WithPDFUA(), WithResourceLoader(FSLoader(fstest.MapFS{"logo.png": {Data: png}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<img src="logo.png">`); err != nil {
+		t.Fatal(err)
+	}
+	dir := d.tempDir
+	if dir == "" {
+		t.Fatal("expected a temporary directory for the image")
+	}
+	var pe *PreflightError
+	if err := d.Finish(); !errors.As(err, &pe) {
+		t.Fatalf("expected a preflight error, got %v", err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %s to be removed, got %v", dir, err)
+	}
+}
@@ -0,0 +1,499 @@
+package document
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ResourceLoader returns the contents of the resource at url together with
+// its MIME type, which may be empty. url is the reference as written in the
+// HTML or CSS; references in files read with ReadCSSFile are prefixed with
+// the directory of the file. The caller closes the returned reader.
+type ResourceLoader func(ctx context.Context, url string) (io.ReadCloser, string, error)
+
+// ErrSandbox is returned when a sandboxed document references a resource
+// that only the file system could provide.
+var ErrSandbox = errors.New("file system access denied in sandbox mode")
+
+// WithResourceLoader fetches images (<img src>), url() references in CSS,
+// @import rules and @font-face sources through loader instead of reading
+// them from the file system. data: URIs are decoded directly.
+func WithResourceLoader(loader ResourceLoader) Option {
+	return func(c *config) { c.loader = loader }
+}
+
+// WithSandbox prevents the document from reading files referenced by HTML
+// and CSS. Resources are only available through the resource loader and as
+// data: URIs; any other reference fails with ErrSandbox. Files the caller
+// passes explicitly, for example to ReadCSSFile or AddFontFamily, are not
+// affected.
+func WithSandbox() Option {
+	return func(c *config) { c.sandbox = true }
+}
+
+// FSLoader returns a ResourceLoader that opens relative and root relative
+// paths in fsys, for example an embed.FS. Query strings and fragments are
+// ignored; URLs with a scheme other than file: are rejected. The MIME type
+// is derived from the file extension.
+func FSLoader(fsys fs.FS) ResourceLoader {
+	return func(ctx context.Context, ref string) (io.ReadCloser, string, error) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil, "", err
+		}
+		if u.Scheme != "" && u.Scheme != "file" {
+			return nil, "", fmt.Errorf("%s: unsupported URL scheme %q", ref, u.Scheme)
+		}
+		name := path.Clean("/" + u.Path)[1:]
+		if name == "" {
+			return nil, "", fmt.Errorf("%s: %w", ref, fs.ErrNotExist)
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, "", err
+		}
+		return f, mime.TypeByExtension(path.Ext(name)), nil
+	}
+}
+
+// localResources reports whether bagme fetches the resources referenced by
+// HTML and CSS itself instead of leaving them to the renderer, which reads
+// them from the file system.
+func (c *config) localResources() bool {
+	return c.loader != nil || c.sandbox
+}
+
+// readResource returns the contents and MIME type of a resource referenced
+// by HTML or CSS. data: URIs are decoded, other references go to the
+// resource loader. Without a loader, file: URLs, absolute paths and paths
+// relative to dir, the base file system or the working directory are read
+// unless the document is sandboxed. Relative references are resolved
+// against dir.
+func (c *config) readResource(ctx context.Context, ref, dir string) ([]byte, string, error) {
+	return c.read(ctx, ref, dir, 0)
+}
+
+// readImage is readResource for an image. Images larger than the
+// WithMaxImageBytes limit fail with ErrImageTooLarge; at most one byte more
+// than the limit is read.
+func (c *config) readImage(ctx context.Context, ref, dir string) ([]byte, string, error) {
+	return c.read(ctx, ref, dir, c.limits.maxImageBytes)
+}
+
+// read is readResource with a size limit. Zero means no limit.
+func (c *config) read(ctx context.Context, ref, dir string, max int64) ([]byte, string, error) {
+	data, mimeType, err := c.fetch(ctx, ref, dir, max)
+	if err == nil && max > 0 && int64(len(data)) > max {
+		err = fmt.Errorf("%w: %s exceeds %d bytes", ErrImageTooLarge, shortRef(ref), max)
+	}
+	return data, mimeType, err
+}
+
+// fetch returns the resource for read. If max is positive, it reads at most
+// max+1 bytes of files and of the resources returned by the loader.
+func (c *config) fetch(ctx context.Context, ref, dir string, max int64) ([]byte, string, error) {
+	if strings.HasPrefix(strings.ToLower(ref), "data:") {
+		return decodeDataURI(ref)
+	}
+	inDir := dir != "" && !isAbsoluteURL(ref)
+	if inDir {
+		ref = joinURL(dir, ref)
+	}
+	if c.loader != nil {
+		if res, ok := c.cache.get(ref); ok {
+			return res.data, res.mimeType, nil
+		}
+		r, mimeType, err := c.loader(ctx, ref)
+		if err != nil {
+			return nil, "", err
+		}
+		defer r.Close()
+		data, err := readAtMost(r, max)
+		if err == nil && (max <= 0 || int64(len(data)) <= max) {
+			c.cache.put(ref, cachedResource{data: data, mimeType: mimeType})
+		}
+		return data, mimeType, err
+	}
+	if c.sandbox {
+		return nil, "", fmt.Errorf("%s: %w", ref, ErrSandbox)
+	}
+	if hasScheme(ref) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil, "", err
+		}
+		if u.Scheme != "file" {
+			return nil, "", fmt.Errorf("%s: unsupported URL scheme %q", ref, u.Scheme)
+		}
+		data, err := readFileAtMost(nil, filepath.FromSlash(u.Path), max)
+		return data, "", err
+	}
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+	if p, err := url.PathUnescape(ref); err == nil {
+		ref = p
+	}
+	var data []byte
+	var err error
+	if c.baseFS != nil && !inDir && !isAbsoluteURL(ref) {
+		data, err = readFileAtMost(c.baseFS, path.Clean(ref), max)
+	} else {
+		data, err = readFileAtMost(nil, filepath.FromSlash(ref), max)
+	}
+	return data, "", err
+}
+
+// readAtMost reads r to the end, or max+1 bytes if max is positive.
+func readAtMost(r io.Reader, max int64) ([]byte, error) {
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	return io.ReadAll(r)
+}
+
+// readFileAtMost reads the file name of fsys, or of the operating system if
+// fsys is nil, with readAtMost.
+func readFileAtMost(fsys fs.FS, name string, max int64) ([]byte, error) {
+	if max <= 0 {
+		if fsys == nil {
+			return os.ReadFile(name)
+		}
+		return fs.ReadFile(fsys, name)
+	}
+	var f fs.File
+	var err error
+	if fsys == nil {
+		f, err = os.Open(name)
+	} else {
+		f, err = fsys.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readAtMost(f, max)
+}
+
+// maxCachedResources is the total size of the resources a resourceCache
+// keeps.
+const maxCachedResources = 64 << 20
+
+// resourceCache keeps the resources fetched by the resource loader for all
+// documents of a Template. A nil cache keeps nothing.
+type resourceCache struct {
+	mu    sync.RWMutex
+	m     map[string]cachedResource
+	total int
+}
+
+type cachedResource struct {
+	data     []byte
+	mimeType string
+}
+
+// get returns the resource cached for ref.
+func (rc *resourceCache) get(ref string) (cachedResource, bool) {
+	if rc == nil {
+		return cachedResource{}, false
+	}
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	res, ok := rc.m[ref]
+	return res, ok
+}
+
+// put caches res for ref unless the cache is full.
+func (rc *resourceCache) put(ref string, res cachedResource) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if _, ok := rc.m[ref]; ok || rc.total+len(res.data) > maxCachedResources {
+		return
+	}
+	if rc.m == nil {
+		rc.m = make(map[string]cachedResource)
+	}
+	rc.m[ref] = res
+	rc.total += len(res.data)
+}
+
+// decodeDataURI returns the payload and MIME type of a data: URI. The
+// payload is either base64 or percent encoded.
+func decodeDataURI(uri string) ([]byte, string, error) {
+	meta, payload, ok := strings.Cut(uri[len("data:"):], ",")
+	if !ok {
+		return nil, "", errors.New("malformed data: URI")
+	}
+	mimeType, _, _ := strings.Cut(meta, ";")
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		payload = strings.Map(func(r rune) rune {
+			if isCSSSpace(byte(r)) || r == '\\' {
+				return -1
+			}
+			return r
+		}, payload)
+		payload = strings.TrimRight(payload, "=")
+		data, err := base64.RawStdEncoding.DecodeString(payload)
+		return data, mimeType, err
+	}
+	s, err := url.PathUnescape(payload)
+	return []byte(s), mimeType, err
+}
+
+// localFile fetches ref and stores it in a temporary file that the renderer
+// can read. Files are cached by reference and removed by Finish.
+func (d *Document) localFile(ref string) (string, error) {
+	if fn, ok := d.localFiles[ref]; ok {
+		return fn, nil
+	}
+	data, mimeType, err := d.cfg.readImage(d.context(), ref, "")
+	if err != nil {
+		return "", err
+	}
+	ext := ""
+	if !strings.HasPrefix(strings.ToLower(ref), "data:") {
+		p := ref
+		if i := strings.IndexAny(p, "?#"); i >= 0 {
+			p = p[:i]
+		}
+		ext = strings.ToLower(path.Ext(p))
+	}
+	if ext == "" && mimeType != "" {
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	fn, err := d.tempFile(data, ext)
+	if err != nil {
+		return "", err
+	}
+	if d.localFiles == nil {
+		d.localFiles = make(map[string]string)
+	}
+	d.localFiles[ref] = fn
+	return fn, nil
+}
+
+// tempFile writes data to a new temporary file with the extension ext and
+// returns its name. The file is removed by Finish.
+func (d *Document) tempFile(data []byte, ext string) (string, error) {
+	if d.tempDir == "" {
+		var err error
+		if d.tempDir, err = os.MkdirTemp("", "bagme"); err != nil {
+			return "", err
+		}
+	}
+	d.tempFiles++
+	fn := filepath.Join(d.tempDir, fmt.Sprintf("res-%d%s", d.tempFiles, ext))
+	if err := os.WriteFile(fn, data, 0o600); err != nil {
+		return "", err
+	}
+	return fn, nil
+}
+
+// removeLocalFiles deletes the temporary files created by localFile.
+func (d *Document) removeLocalFiles() error {
+	if d.tempDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(d.tempDir)
+	d.tempDir = ""
+	d.localFiles = nil
+	return err
+}
+
+// localizeHTML replaces the image sources and the url() references in style
+// attributes of src with temporary files fetched by localFile. If fragment
+// is true, src is an HTML fragment as passed to OutputAt.
+func (d *Document) localizeHTML(src string, fragment bool) (string, error) {
+	return mapHTMLURLs(src, fragment, func(ref string) (string, error) {
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			return ref, nil
+		}
+		return d.localFile(ref)
+	})
+}
+
+// rebaseHTML resolves the relative image sources and url() references in
+// style attributes of src against the base directory, so that the renderer,
+// which reads them from the file system, does not resolve them against the
+// working directory. The resolved images are checked against the image size
+// limit.
+func (d *Document) rebaseHTML(src string, fragment bool) (string, error) {
+	return mapHTMLURLs(src, fragment, func(ref string) (string, error) {
+		if !isAbsoluteURL(ref) && d.cfg.baseDir != "" {
+			ref = joinURL(d.cfg.baseDir, ref)
+		}
+		if max := d.cfg.limits.maxImageBytes; max > 0 {
+			if err := checkImageFile(ref, max); err != nil {
+				return "", err
+			}
+		}
+		return ref, nil
+	})
+}
+
+// prepareResources localizes or rebases the resources referenced by src,
+// see localizeHTML and rebaseHTML.
+func (d *Document) prepareResources(src string, fragment bool) (string, error) {
+	switch {
+	case d.cfg.localResources():
+		return d.localizeHTML(src, fragment)
+	case d.cfg.baseDir != "" || d.cfg.limits.maxImageBytes > 0:
+		return d.rebaseHTML(src, fragment)
+	}
+	return src, nil
+}
+
+// mapHTMLURLs replaces the image sources and the url() references in style
+// attributes of src with the result of fn.
+func mapHTMLURLs(src string, fragment bool, fn func(ref string) (string, error)) (string, error) {
+	var nodes []*html.Node
+	if fragment {
+		body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		ns, err := html.ParseFragment(strings.NewReader(src), body)
+		if err != nil {
+			return "", err
+		}
+		nodes = ns
+	} else {
+		doc, err := html.Parse(strings.NewReader(src))
+		if err != nil {
+			return "", err
+		}
+		nodes = []*html.Node{doc}
+	}
+	var err error
+	for _, n := range nodes {
+		walkElements(n, func(e *html.Node) {
+			for i, a := range e.Attr {
+				if err != nil {
+					return
+				}
+				switch {
+				case a.Namespace == "" && a.Key == "src" && e.DataAtom == atom.Img:
+					e.Attr[i].Val, err = fn(strings.TrimSpace(a.Val))
+				case a.Namespace == "" && a.Key == "style":
+					e.Attr[i].Val, err = mapURLs(a.Val, fn)
+				}
+			}
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, n := range nodes {
+		if err := html.Render(&sb, n); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// importCSS replaces the @import rules in blocks with the stylesheets they
+// refer to, fetched with readResource. base is the location of the
+// stylesheet that blocks belong to relative to dir, the directory of the
+// stylesheet added to the document. Relative URLs in imported stylesheets
+// are rewritten to be relative to blocks. An import with a media query is
+// wrapped in an @media rule.
+func (d *Document) importCSS(blocks []*cssBlock, dir, base string) ([]*cssBlock, error) {
+	rest := blocks[:0:0]
+	for _, b := range blocks {
+		if b.atRule() != "import" || !b.statement {
+			rest = append(rest, b)
+			continue
+		}
+		ref, media := importTarget(b.prelude)
+		if ref == "" {
+			continue
+		}
+		full := ref
+		if base != "" && !isAbsoluteURL(ref) {
+			full = joinURL(base, ref)
+		}
+		if d.importing[full] {
+			return nil, fmt.Errorf("@import %s: circular import", ref)
+		}
+		data, _, err := d.cfg.readResource(d.context(), full, dir)
+		if err != nil {
+			return nil, fmt.Errorf("@import %s: %w", ref, err)
+		}
+		if d.importing == nil {
+			d.importing = make(map[string]bool)
+		}
+		d.importing[full] = true
+		imported, err := d.importCSS(parseCSS(string(data)), dir, urlDir(full))
+		delete(d.importing, full)
+		if err != nil {
+			return nil, err
+		}
+		rewriteBlockURLs(imported, urlDir(ref))
+		if media != "" {
+			imported = []*cssBlock{{prelude: "@media " + media, children: imported}}
+		}
+		rest = append(rest, imported...)
+	}
+	return rest, nil
+}
+
+// importTarget returns the URL and the media query of an @import rule.
+func importTarget(prelude string) (string, string) {
+	s := strings.TrimSpace(prelude[len("@import"):])
+	var ref string
+	if strings.HasPrefix(strings.ToLower(s), "url(") {
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return "", ""
+		}
+		ref, s = unquoteCSS(s[4:end]), s[end+1:]
+	} else if s != "" && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", ""
+		}
+		ref, s = s[1:end+1], s[end+2:]
+	}
+	return ref, strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), ";"))
+}
+
+// localizeBlockURLs replaces the url() references in the declarations of
+// blocks with temporary files fetched by localFile.
+func (d *Document) localizeBlockURLs(blocks []*cssBlock) error {
+	localize := func(ref string) (string, error) {
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			return ref, nil
+		}
+		return d.localFile(ref)
+	}
+	for _, b := range blocks {
+		for i := range b.decls {
+			v, err := mapURLs(b.decls[i].value, localize)
+			if err != nil {
+				return err
+			}
+			b.decls[i].value = v
+		}
+		if err := d.localizeBlockURLs(b.children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package document
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDecodeDataURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		want     string
+		mimeType string
+	}{
+		{"data:font/ttf;base64," + base64.StdEncoding.EncodeToString([]byte("font data")), "font data", "font/ttf"},
+		{"data:font/ttf;base64,Zm9udA", "font", "font/ttf"},
+		{"data:application/octet-stream,a%20b", "a b", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		got, mimeType, err := decodeDataURI(tt.uri)
+		if err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+			continue
+		}
+		if string(got) != tt.want || mimeType != tt.mimeType {
+			t.Errorf("%s: expected %q (%s), got %q (%s)", tt.uri, tt.want, tt.mimeType, got, mimeType)
+		}
+	}
+	if _, _, err := decodeDataURI("data:font/ttf;base64"); err == nil {
+		t.Error("expected error for data: URI without payload")
+	}
+}
+
+func TestFSLoader(t *testing.T) {
+	load := FSLoader(fstest.MapFS{"img/logo.png": {Data: []byte("png")}})
+	for _, ref := range []string{"img/logo.png", "/img/logo.png", "./img/logo.png?v=1", "file:///img/logo.png", "../img/logo.png"} {
+		r, mimeType, err := load(context.Background(), ref)
+		if err != nil {
+			t.Errorf("%s: %v", ref, err)
+			continue
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if string(data) != "png" || mimeType != "image/png" {
+			t.Errorf("%s: unexpected %q (%s)", ref, data, mimeType)
+		}
+	}
+	if _, _, err := load(context.Background(), "missing.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	if _, _, err := load(context.Background(), "https://example.com/logo.png"); err == nil {
+		t.Error("expected error for https URL")
+	}
+}
+
+func TestReadResourceSandbox(t *testing.T) {
+	cfg := config{sandbox: true}
+	if _, _, err := cfg.readResource(context.Background(), "/etc/passwd", ""); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected ErrSandbox, got %v", err)
+	}
+	if _, _, err := cfg.readResource(context.Background(), "file:///etc/passwd", ""); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected ErrSandbox for file URL, got %v", err)
+	}
+	data, _, err := cfg.readResource(context.Background(), "data:,ok", "")
+	if err != nil || string(data) != "ok" {
+		t.Errorf("data: URI in sandbox: %q, %v", data, err)
+	}
+	var requested []string
+	cfg.loader = func(ctx context.Context, url string) (io.ReadCloser, string, error) {
+		requested = append(requested, url)
+		return io.NopCloser(strings.NewReader("loaded")), "", nil
+	}
+	if _, _, err := cfg.readResource(context.Background(), "fonts/a.woff2", "css"); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 1 || requested[0] != "css/fonts/a.woff2" {
+		t.Errorf("unexpected loader requests %q", requested)
+	}
+}
+
+func TestImportCSS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/main.css":       {Data: []byte(`@import "parts/base.css"; h1 { color: red }`)},
+		"css/parts/base.css": {Data: []byte(`@import url(more.css) print; p { background-image: url(bg.png) }`)},
+		"css/parts/more.css": {Data: []byte(`li { list-style-image: url("../img/dot.png") }`)},
+		"css/loop.css":       {Data: []byte(`@import "loop.css";`)},
+	}
+	d := &Document{cfg: config{loader: FSLoader(fsys)}}
+	blocks, err := d.importCSS(parseCSS(`@import url(main.css); body { margin: 0 }`), "css", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"@media print {\n  li {\n    list-style-image: url(\"img/dot.png\");\n  }\n}\n",
+		"p {\n  background-image: url(parts/bg.png);\n}\n",
+		"h1 {\n  color: red;\n}\n",
+		"body {\n  margin: 0;\n}\n",
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("expected %d blocks, got\n%s", len(want), writeCSS(blocks))
+	}
+	for i, b := range blocks {
+		if got := writeCSS([]*cssBlock{b}); got != want[i] {
+			t.Errorf("block %d: expected\n%s\ngot\n%s", i, want[i], got)
+		}
+	}
+	if _, err := d.importCSS(parseCSS(`@import "loop.css";`), "css", ""); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Errorf("expected circular import error, got %v", err)
+	}
+}
+
+func TestLocalizeHTML(t *testing.T) {
+	d := &Document{cfg: config{loader: FSLoader(fstest.MapFS{"logo.png": {Data: []byte("png")}})}}
+	defer d.removeLocalFiles()
+	got, err := d.localizeHTML(`<img src="logo.png" alt="Logo"><div style="background-image: url('logo.png')">x</div>`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := d.localFiles["logo.png"]
+	if fn == "" || !strings.HasSuffix(fn, ".png") {
+		t.Fatalf("expected a local .png file, got %q", fn)
+	}
+	if want := `<img src="` + fn + `" alt="Logo"/><div style="background-image: url(&#39;` + fn + `&#39;)">x</div>`; got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if data, err := os.ReadFile(fn); err != nil || string(data) != "png" {
+		t.Errorf("unexpected local file contents %q, %v", data, err)
+	}
+	if err := d.removeLocalFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fn); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected local files to be removed")
+	}
+	if _, err := d.localizeHTML(`<img src="missing.png">`, true); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestRebaseHTML(t *testing.T) {
+	d := &Document{cfg: config{baseDir: "/srv/docs"}}
+	got, err := d.prepareResources(`<img src="img/logo.png"><img src="/abs.png"><img src="data:image/png;base64,AA=="><div style="background: url(bg.png)">x</div>`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<img src="/srv/docs/img/logo.png"/><img src="/abs.png"/><img src="data:image/png;base64,AA=="/><div style="background: url(/srv/docs/bg.png)">x</div>`
+	if got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	d = &Document{}
+	if got, _ := d.prepareResources(`<img src="logo.png">`, true); got != `<img src="logo.png">` {
+		t.Errorf("expected src to be unchanged without base directory, got %s", got)
+	}
+}
+
+func TestResourceLoader(t *testing.T) {
+	png, err := os.ReadFile(testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(tempPDF(t), WithSandbox(), WithResourceLoader(FSLoader(fstest.MapFS{"img/pixel.png": {Data: png}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<p>Pixel: <img src="img/pixel.png" alt="Pixel" style="width: 1cm"></p>`); err != nil {
+		t.Fatal(err)
+	}
+	tempDir := d.tempDir
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tempDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected Finish to remove the temporary files")
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	d, err := New(tempPDF(t), WithSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderPages(`<img src="` + testPNG(t) + `" alt="Pixel">`); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected ErrSandbox for image, got %v", err)
+	}
+	if err := d.AddCSS(`body { background-image: url(/etc/bg.png) }`); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected ErrSandbox for CSS, got %v", err)
+	}
+}
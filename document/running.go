@@ -0,0 +1,475 @@
+package document
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// runningContent holds the named strings (string-set) and running elements
+// (position: running()) of the document being rendered, which margin boxes
+// show with string() and element().
+type runningContent struct {
+	elements []runningElement          // removed from the flow, by placeholder number
+	named    map[string][]runningValue // named strings in document order
+	runners  map[string][]runningValue // running elements in document order
+	lastPage int                       // for counter(pages)
+	pages    map[int][]placedBox       // typeset margin boxes by page number
+}
+
+// runningElement is an element with position: running(name).
+type runningElement struct {
+	name string
+	html string
+}
+
+// runningValue is a value assigned to a named string or running element on
+// page.
+type runningValue struct {
+	page  int
+	value string // text of a named string, HTML of a running element
+}
+
+// marginBox is a margin box of a page with its content resolved.
+type marginBox struct {
+	box   string // prelude such as "@top-center"
+	html  string // content
+	style string // declarations of the box other than content
+}
+
+// placedBox is a typeset margin box at its position on the page.
+type placedBox struct {
+	x, y  bag.ScaledPoint
+	vlist *node.VList
+	html  string
+}
+
+// runningPlaceholder is the comment that takes the place of a running
+// element in the HTML.
+const runningPlaceholder = "bag-running "
+
+// runningName returns the name of a position value running(name) or "".
+func runningName(v string) string {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(strings.ToLower(v), "running(") || !strings.HasSuffix(v, ")") {
+		return ""
+	}
+	return pageName(strings.TrimSpace(v[len("running(") : len(v)-1]))
+}
+
+// hasRunningContent reports whether a margin box of the @page rule b uses
+// string(), element() or counter(pages), which bagme resolves for every
+// page.
+func hasRunningContent(b *cssBlock) bool {
+	for _, box := range b.children {
+		if v, ok := box.get("content"); ok && isRunningContent(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRunningContent(v string) bool {
+	for _, tok := range contentTokens(v) {
+		switch tok.name {
+		case "string", "element":
+			return true
+		case "counter":
+			if strings.TrimSpace(tok.args[0]) == "pages" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractRunningElements removes the elements with position: running() from
+// src and replaces them with placeholder comments, so they neither take up
+// space in the flow nor in layout passes.
+func (d *Document) extractRunningElements(src string) (string, error) {
+	d.running = runningContent{}
+	if !strings.Contains(src, "running(") && !d.pages.has("position") {
+		return src, nil
+	}
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	var found []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && inBody(n) {
+			if name := runningName(d.pages.value(n, "position")); name != "" {
+				found = append(found, n)
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if len(found) == 0 {
+		return src, nil
+	}
+	for i, n := range found {
+		var sb strings.Builder
+		if err := html.Render(&sb, n); err != nil {
+			return "", err
+		}
+		d.running.elements = append(d.running.elements, runningElement{name: runningName(d.pages.value(n, "position")), html: sb.String()})
+		n.Parent.InsertBefore(&html.Node{Type: html.CommentNode, Data: runningPlaceholder + strconv.Itoa(i)}, n)
+		n.Parent.RemoveChild(n)
+	}
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// has reports whether a style rule sets property.
+func (p *pageStyles) has(property string) bool {
+	for _, prop := range p.props {
+		if prop.property == property {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareRunning runs a layout pass of src to find out on which pages the
+// named strings are set and the running elements appear, and how many pages
+// src needs.
+func (d *Document) prepareRunning(src string) error {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return err
+	}
+	type assignment struct {
+		node    *html.Node
+		element bool
+		name    string
+		value   string
+	}
+	var assignments []assignment
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.CommentNode:
+			if i, err := strconv.Atoi(strings.TrimPrefix(n.Data, runningPlaceholder)); err == nil && strings.HasPrefix(n.Data, runningPlaceholder) && i < len(d.running.elements) {
+				e := d.running.elements[i]
+				assignments = append(assignments, assignment{node: n, element: true, name: e.name, value: e.html})
+			}
+		case html.ElementNode:
+			if v := d.pages.value(n, "string-set"); v != "" && v != "none" && inBody(n) {
+				for _, s := range stringSetValues(v, n) {
+					assignments = append(assignments, assignment{node: n, name: s[0], value: s[1]})
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var probes []*html.Node
+	probeOf := make([]int, len(assignments))
+	for i, a := range assignments {
+		if i > 0 && a.node == assignments[i-1].node {
+			probeOf[i] = probeOf[i-1]
+			continue
+		}
+		probe := newElement(atom.H6, probeAttr, strconv.Itoa(len(probes)), "style", probeStyle)
+		probe.AppendChild(&html.Node{Type: html.TextNode, Data: fmt.Sprintf("bag-running-probe-%d", len(probes))})
+		block := flowAncestor(a.node)
+		block.Parent.InsertBefore(probe, block)
+		probeOf[i] = len(probes)
+		probes = append(probes, probe)
+	}
+	hs := collectHeadings(doc, nil)
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return err
+	}
+	for _, p := range probes {
+		p.Parent.RemoveChild(p)
+	}
+	scratch, err := d.scratchPass(sb.String())
+	if err != nil {
+		return err
+	}
+	// Like layoutPass, page numbers count the pages d already has.
+	entries := scratch.Headings()
+	for i := range entries {
+		entries[i].PageNumber += d.pageCount
+	}
+	probePages := make([]int, len(probes))
+	for i, page := range headingPages(hs, entries) {
+		if n, err := strconv.Atoi(attr(hs[i].node, probeAttr)); err == nil && n < len(probePages) {
+			probePages[n] = page
+		}
+	}
+	d.running.named = map[string][]runningValue{}
+	d.running.runners = map[string][]runningValue{}
+	for i, a := range assignments {
+		v := runningValue{page: probePages[probeOf[i]], value: a.value}
+		if a.element {
+			d.running.runners[a.name] = append(d.running.runners[a.name], v)
+		} else {
+			d.running.named[a.name] = append(d.running.named[a.name], v)
+		}
+	}
+	d.running.lastPage = scratch.pageCount
+	return d.prepareMarginBoxes(scratch.pages.infos)
+}
+
+// stringSetValues evaluates the string-set value v, for example
+// "chapter content(), title attr(title)", for the element n and returns
+// the names and the values assigned to them.
+func stringSetValues(v string, n *html.Node) [][2]string {
+	var out [][2]string
+	for _, part := range splitCSSList(v) {
+		part = strings.TrimSpace(part)
+		end := strings.IndexFunc(part, func(r rune) bool { return r < 0x80 && isCSSSpace(byte(r)) })
+		if end < 0 {
+			continue
+		}
+		name := part[:end]
+		if !isPageName(name) {
+			continue
+		}
+		var sb strings.Builder
+		for _, tok := range contentTokens(part[end:]) {
+			switch tok.name {
+			case "":
+				sb.WriteString(tok.str)
+			case "content":
+				// before, after and first-letter have no text in bagme.
+				if arg := strings.TrimSpace(tok.args[0]); arg == "" || arg == "text" {
+					sb.WriteString(strings.Join(strings.Fields(textContent(n)), " "))
+				}
+			case "attr":
+				sb.WriteString(attr(n, strings.TrimSpace(tok.args[0])))
+			}
+		}
+		out = append(out, [2]string{name, sb.String()})
+	}
+	return out
+}
+
+// valueOn returns the value of a named string or running element on page,
+// chosen by the keyword of string() or element(): the first assignment on
+// the page (first, the default), the last one (last), or none if the page
+// has one (first-except). Without assignments on the page, the last value
+// of the pages before is used. start is treated like first, since the
+// position of the assigning element on its page is not known.
+func valueOn(values []runningValue, page int, keyword string) string {
+	entry := ""
+	var onPage []string
+	for _, v := range values {
+		switch {
+		case v.page < page:
+			entry = v.value
+		case v.page == page:
+			onPage = append(onPage, v.value)
+		}
+	}
+	if len(onPage) == 0 {
+		return entry
+	}
+	switch keyword {
+	case "last":
+		return onPage[len(onPage)-1]
+	case "first-except":
+		return ""
+	}
+	return onPage[0]
+}
+
+// resolveMarginBoxes returns the margin boxes of style that have content
+// on page, with string(), element(), counter(page) and counter(pages)
+// replaced by their values. Text is HTML escaped; a box with element()
+// shows the running element only.
+func (d *Document) resolveMarginBoxes(style *cssBlock, page int) []marginBox {
+	var boxes []marginBox
+	for _, box := range style.children {
+		v, ok := box.get("content")
+		if !ok {
+			continue
+		}
+		var text strings.Builder
+		var elem strings.Builder
+		for _, tok := range contentTokens(v) {
+			keyword := ""
+			if len(tok.args) > 1 {
+				keyword = strings.ToLower(strings.TrimSpace(tok.args[1]))
+			}
+			switch tok.name {
+			case "":
+				text.WriteString(tok.str)
+			case "counter":
+				n := page
+				if strings.TrimSpace(tok.args[0]) == "pages" {
+					n = d.running.lastPage
+				}
+				text.WriteString(formatCounter(n, keyword))
+			case "string":
+				text.WriteString(valueOn(d.running.named[strings.TrimSpace(tok.args[0])], page, keyword))
+			case "element":
+				elem.WriteString(valueOn(d.running.runners[strings.TrimSpace(tok.args[0])], page, keyword))
+			}
+		}
+		content := html.EscapeString(text.String())
+		if elem.Len() > 0 {
+			content = elem.String()
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		var decls []string
+		for _, decl := range box.decls {
+			if decl.property != "content" {
+				decls = append(decls, decl.property+": "+decl.value)
+			}
+		}
+		boxes = append(boxes, marginBox{box: box.prelude, html: content, style: strings.Join(decls, "; ")})
+	}
+	return boxes
+}
+
+// marginBoxArea is the area of a margin box on the page. y is the top edge,
+// measured from the bottom of the page.
+type marginBoxArea struct {
+	x, y, width, height bag.ScaledPoint
+	align               string // text-align of the box content
+	valign              string // "top", "middle" or "bottom"
+}
+
+// boxArea returns the area of the margin box with the prelude box, such as
+// "@top-center", on a page with the dimensions dim. The top and bottom
+// boxes span the width of the page area and align their content to the
+// side in their name.
+func boxArea(box string, dim PageDimensions) (marginBoxArea, bool) {
+	name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(box), "@"))
+	a := marginBoxArea{align: "center", valign: "middle"}
+	switch {
+	case strings.HasSuffix(name, "-corner"):
+		a.width = dim.MarginLeft
+		if strings.Contains(name, "-right-") {
+			a.x, a.width = dim.Width-dim.MarginRight, dim.MarginRight
+		}
+		a.y, a.height = dim.Height, dim.MarginTop
+		if strings.HasPrefix(name, "bottom-") {
+			a.y, a.height = dim.MarginBottom, dim.MarginBottom
+		}
+	case strings.HasPrefix(name, "top-"), strings.HasPrefix(name, "bottom-"):
+		a.x, a.width = dim.MarginLeft, dim.ContentWidth
+		a.y, a.height = dim.Height, dim.MarginTop
+		if strings.HasPrefix(name, "bottom-") {
+			a.y, a.height = dim.MarginBottom, dim.MarginBottom
+		}
+		switch {
+		case strings.HasSuffix(name, "-left"):
+			a.align = "left"
+		case strings.HasSuffix(name, "-right"):
+			a.align = "right"
+		}
+	case strings.HasPrefix(name, "left-"), strings.HasPrefix(name, "right-"):
+		a.width = dim.MarginLeft
+		if strings.HasPrefix(name, "right-") {
+			a.x, a.width = dim.Width-dim.MarginRight, dim.MarginRight
+		}
+		a.y, a.height = dim.Height-dim.MarginTop, dim.ContentHeight
+		switch {
+		case strings.HasSuffix(name, "-top"):
+			a.valign = "top"
+		case strings.HasSuffix(name, "-bottom"):
+			a.valign = "bottom"
+		}
+	default:
+		return a, false
+	}
+	return a, a.width > 0
+}
+
+// prepareMarginBoxes typesets the margin boxes of the pages in infos, as
+// recorded by the layout pass of prepareRunning. This happens before the
+// pages are rendered, so starting a page only outputs the prepared boxes.
+// Every page gets boxes of its own, since a box cannot be output twice.
+func (d *Document) prepareMarginBoxes(infos map[int]pageInfo) error {
+	d.running.pages = map[int][]placedBox{}
+	for _, n := range slices.Sorted(maps.Keys(infos)) {
+		if n > d.running.lastPage {
+			// the style of the page after the last one, selected when
+			// the last page was started
+			continue
+		}
+		style := d.pages.style(infos[n])
+		dim, err := pageDimensions(style)
+		if err != nil {
+			return err
+		}
+		for _, mb := range d.resolveMarginBoxes(style, n) {
+			a, ok := boxArea(mb.box, dim)
+			if !ok {
+				continue
+			}
+			src := fmt.Sprintf(`<div style="text-align: %s; %s">%s</div>`, a.align, html.EscapeString(mb.style), mb.html)
+			te, err := d.cssbuilder.HTMLToText(src)
+			if err != nil {
+				return err
+			}
+			vl, err := d.cssbuilder.CreateVlist(te, a.width)
+			if err != nil {
+				return err
+			}
+			y := a.y
+			switch a.valign {
+			case "middle":
+				y -= (a.height - vl.Height) / 2
+			case "bottom":
+				y -= a.height - vl.Height
+			}
+			d.running.pages[n] = append(d.running.pages[n], placedBox{x: a.x, y: y, vlist: vl, html: mb.html})
+		}
+	}
+	return nil
+}
+
+// checkRunning reports a warning if RenderPages, which started after page
+// first, rendered another number of pages than the layout pass of
+// prepareRunning. The probes of the layout pass can move a page break, and
+// then the margin boxes show the values of the layout pass and pages after
+// its last page have none.
+func (d *Document) checkRunning(first int) error {
+	if !d.pages.running || d.pageCount == d.running.lastPage {
+		return nil
+	}
+	return d.report([]Diagnostic{{
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf("margin boxes were prepared for %d pages, but %d pages were rendered; string(), element() and counter(pages) may show wrong values", d.running.lastPage-first, d.pageCount-first),
+	}})
+}
+
+// placeMarginBoxes outputs the margin boxes prepared for the current page.
+func (d *Document) placeMarginBoxes() {
+	for _, b := range d.running.pages[d.pageCount] {
+		d.Frontend.Doc.CurrentPage.OutputAt(b.x, b.y, b.vlist)
+	}
+}
+
+// PageNumber returns the number of the current page while RenderPages runs,
+// for example in PageInitCallback. Pages inserted with InsertPDF count as
+// well.
+func (d *Document) PageNumber() int {
+	return d.pageCount
+}
@@ -0,0 +1,352 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRunningName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"running(header)", "header"},
+		{" Running( footer ) ", "footer"},
+		{"running()", ""},
+		{"absolute", ""},
+		{"running(header", ""},
+	}
+	for _, tt := range tests {
+		if got := runningName(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractRunningRules(t *testing.T) {
+	d := &Document{}
+	css := writeCSS(d.extractPageRules(parseCSS(`@page { @top-center { content: string(chapter) } }
+h1 { string-set: chapter content(); color: red }
+.header { position: running(header) }
+.box { position: relative }`)))
+	if strings.Contains(css, "string-set") || strings.Contains(css, "running(") {
+		t.Errorf("expected string-set and running positions to be removed:\n%s", css)
+	}
+	for _, want := range []string{"color: red", "position: relative", "string(chapter)"} {
+		if !strings.Contains(css, want) {
+			t.Errorf("expected %q to stay in:\n%s", want, css)
+		}
+	}
+	if !d.pages.running || !d.pages.active() {
+		t.Error("expected running content to activate the page styles")
+	}
+	if !d.pages.has("string-set") || !d.pages.has("position") {
+		t.Errorf("unexpected properties %+v", d.pages.props)
+	}
+
+	d = &Document{}
+	d.extractPageRules(parseCSS(`@page { @bottom-center { content: "Page " counter(page) } }`))
+	if d.pages.running {
+		t.Error("counter(page) alone is left to the CSS builder")
+	}
+}
+
+func TestStringSetValues(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<h1 title="Intro">1.  Getting
+  started</h1>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1 := findElement(doc, func(n *html.Node) bool { return n.Data == "h1" })
+	got := stringSetValues(`chapter content(), title "» " attr(title), bad, before content(before)`, h1)
+	want := [][2]string{{"chapter", "1. Getting started"}, {"title", "» Intro"}, {"before", ""}}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %q, want %q", got[i], want[i])
+		}
+	}
+}
+
+func TestValueOn(t *testing.T) {
+	values := []runningValue{{2, "One"}, {4, "Two"}, {4, "Three"}}
+	tests := []struct {
+		page    int
+		keyword string
+		want    string
+	}{
+		{1, "", ""},
+		{2, "", "One"},
+		{3, "", "One"},
+		{4, "", "Two"},
+		{4, "first", "Two"},
+		{4, "start", "Two"},
+		{4, "last", "Three"},
+		{4, "first-except", ""},
+		{5, "first-except", "Three"},
+		{5, "", "Three"},
+	}
+	for _, tt := range tests {
+		if got := valueOn(values, tt.page, tt.keyword); got != tt.want {
+			t.Errorf("page %d %q: got %q, want %q", tt.page, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestResolveMarginBoxes(t *testing.T) {
+	d := &Document{}
+	d.extractPageRules(parseCSS(`@page {
+  @top-left { content: string(chapter, last); font-size: 8pt }
+  @top-right { content: "Ignored " element(header) }
+  @bottom-center { content: "Page " counter(page) " of " counter(pages, upper-roman) }
+  @bottom-left { content: "Draft" }
+  @bottom-right { content: string(missing) }
+}`))
+	d.running.named = map[string][]runningValue{"chapter": {{1, `Say <"hi">`}, {2, "Second"}}}
+	d.running.runners = map[string][]runningValue{"header": {{1, "<div>ACME</div>"}}}
+	d.running.lastPage = 4
+	tests := []struct {
+		page int
+		want []marginBox
+	}{
+		{1, []marginBox{
+			{"@top-left", "Say &lt;&#34;hi&#34;&gt;", "font-size: 8pt"},
+			{"@top-right", "<div>ACME</div>", ""},
+			{"@bottom-center", "Page 1 of IV", ""},
+			{"@bottom-left", "Draft", ""},
+		}},
+		{2, []marginBox{
+			{"@top-left", "Second", "font-size: 8pt"},
+			{"@top-right", "<div>ACME</div>", ""},
+			{"@bottom-center", "Page 2 of IV", ""},
+			{"@bottom-left", "Draft", ""},
+		}},
+	}
+	for _, tt := range tests {
+		got := d.resolveMarginBoxes(d.pages.style(pageInfo{first: tt.page == 1, number: tt.page}), tt.page)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("page %d: got %+v, want %+v", tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestBoxArea(t *testing.T) {
+	dim := PageDimensions{
+		Width: 1000, Height: 2000,
+		MarginTop: 100, MarginRight: 50, MarginBottom: 80, MarginLeft: 60,
+		ContentWidth: 890, ContentHeight: 1820,
+	}
+	tests := []struct {
+		box  string
+		want marginBoxArea
+	}{
+		{"@top-left", marginBoxArea{60, 2000, 890, 100, "left", "middle"}},
+		{"@top-center", marginBoxArea{60, 2000, 890, 100, "center", "middle"}},
+		{"@bottom-right", marginBoxArea{60, 80, 890, 80, "right", "middle"}},
+		{"@top-right-corner", marginBoxArea{950, 2000, 50, 100, "center", "middle"}},
+		{"@bottom-left-corner", marginBoxArea{0, 80, 60, 80, "center", "middle"}},
+		{"@left-top", marginBoxArea{0, 1900, 60, 1820, "center", "top"}},
+		{"@right-bottom", marginBoxArea{950, 1900, 50, 1820, "center", "bottom"}},
+	}
+	for _, tt := range tests {
+		got, ok := boxArea(tt.box, dim)
+		if !ok || got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.box, got, tt.want)
+		}
+	}
+	if _, ok := boxArea("@footnote", dim); ok {
+		t.Error("expected no area for an unknown box")
+	}
+}
+
+func TestExtractRunningElements(t *testing.T) {
+	d := &Document{}
+	d.extractPageRules(parseCSS(`.header { position: running(header) }`))
+	out, err := d.extractRunningElements(`<html><body>
+<div class="header">ACME <b>Corp.</b></div>
+<p>Text</p>
+<p style="position: running(footer)">Footer</p>
+</body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "ACME") || strings.Contains(out, "Footer") {
+		t.Errorf("expected running elements to be removed:\n%s", out)
+	}
+	if !strings.Contains(out, "<!--bag-running 0-->") || !strings.Contains(out, "<!--bag-running 1-->") {
+		t.Errorf("expected placeholders:\n%s", out)
+	}
+	if len(d.running.elements) != 2 {
+		t.Fatalf("expected 2 running elements, got %+v", d.running.elements)
+	}
+	if e := d.running.elements[0]; e.name != "header" || e.html != `<div class="header">ACME <b>Corp.</b></div>` {
+		t.Errorf("unexpected running element %+v", e)
+	}
+	if d.running.elements[1].name != "footer" {
+		t.Errorf("unexpected running element %+v", d.running.elements[1])
+	}
+}
+
+func TestRenderPagesRunningHeaders(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page {
+  size: A5; margin: 2cm;
+  @top-center { content: string(chapter) }
+  @top-right { content: element(header) }
+  @bottom-center { content: counter(page) " / " counter(pages) }
+}
+h1 { string-set: chapter content() }
+.header { position: running(header) }`); err != nil {
+		t.Fatal(err)
+	}
+	var numbers []int
+	d.PageInitCallback = func() {
+		numbers = append(numbers, d.PageNumber())
+	}
+	if err := d.RenderPages(`<div class="header">ACME</div><h1>One</h1><p>Text</p><h1 style="page-break-before: always">Two</h1><p>Text</p>`); err != nil {
+		t.Fatal(err)
+	}
+	if len(numbers) != 2 || numbers[0] != 1 || numbers[1] != 2 {
+		t.Errorf("unexpected page numbers %v", numbers)
+	}
+	if d.running.lastPage != 2 {
+		t.Errorf("expected 2 pages for counter(pages), got %d", d.running.lastPage)
+	}
+	chapters := d.running.named["chapter"]
+	if len(chapters) != 2 || chapters[0] != (runningValue{1, "One"}) || chapters[1] != (runningValue{2, "Two"}) {
+		t.Errorf("unexpected named strings %+v", chapters)
+	}
+	if got := valueOn(d.running.runners["header"], 2, ""); !strings.Contains(got, "ACME") {
+		t.Errorf("expected the running header on page 2, got %q", got)
+	}
+	for _, h := range d.Headings() {
+		if h.Text == "ACME" {
+			t.Error("running element rendered in the flow")
+		}
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderPagesRunningHeadersManyPages(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page {
+  size: A6; margin: 2cm;
+  @top-center { content: string(chapter) }
+  @top-right { content: element(header) }
+  @bottom-center { content: counter(page) " / " counter(pages) }
+}
+h1 { string-set: chapter content() }
+h1 + p + h1 { page-break-before: always }
+.header { position: running(header) }`); err != nil {
+		t.Fatal(err)
+	}
+	chapters := []string{"One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight"}
+	var sb strings.Builder
+	sb.WriteString(`<div class="header">ACME</div>`)
+	for _, c := range chapters {
+		sb.WriteString("<h1>" + c + "</h1><p>Text</p>")
+	}
+	if err := d.RenderPages(sb.String()); err != nil {
+		t.Fatal(err)
+	}
+	n := len(chapters)
+	if d.pageCount != n || d.running.lastPage != n {
+		t.Fatalf("expected %d pages, got %d, last page %d", n, d.pageCount, d.running.lastPage)
+	}
+	for _, page := range []int{2, n} {
+		var contents []string
+		for _, b := range d.running.pages[page] {
+			contents = append(contents, b.html)
+		}
+		want := []string{chapters[page-1], "ACME", fmt.Sprintf("%d / %d", page, n)}
+		for _, w := range want {
+			if !slices.ContainsFunc(contents, func(c string) bool { return strings.Contains(c, w) }) {
+				t.Errorf("page %d: expected %q in the margin boxes %q", page, w, contents)
+			}
+		}
+	}
+	if strings.Contains(d.pages.applied, chapters[n-1]) {
+		t.Errorf("expected margin box content to stay out of the CSS builder:\n%s", d.pages.applied)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRunning(t *testing.T) {
+	d := &Document{}
+	d.pages.running = true
+	d.running.lastPage, d.pageCount = 3, 3
+	if err := d.checkRunning(0); err != nil || len(d.Warnings()) != 0 {
+		t.Errorf("expected no warning for matching page counts, got %v %v", err, d.Warnings())
+	}
+	d.pageCount = 4
+	if err := d.checkRunning(0); err != nil {
+		t.Fatal(err)
+	}
+	if w := d.Warnings(); len(w) != 1 || !strings.Contains(w[0].Message, "prepared for 3 pages, but 4 pages were rendered") {
+		t.Errorf("expected a warning about the page count, got %v", w)
+	}
+	d = &Document{cfg: config{strict: true}}
+	d.pages.running = true
+	d.running.lastPage, d.pageCount = 4, 3
+	var derr *DiagnosticsError
+	if err := d.checkRunning(1); !errors.As(err, &derr) {
+		t.Errorf("expected a DiagnosticsError in strict mode, got %v", err)
+	}
+}
+
+func TestRenderPagesRunningProbeShift(t *testing.T) {
+	// The probe in front of the long paragraph keeps it from starting at the
+	// bottom of the first page in the layout pass. If that moves a page
+	// break, RenderPages has to say so.
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`@page {
+  size: A6; margin: 1cm;
+  @top-center { content: string(section) }
+  @bottom-center { content: counter(page) " / " counter(pages) }
+}
+p { margin: 0 }
+.filler { height: 9cm }
+.section { string-set: section "Terms" }`); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("The terms apply to all orders. ", 60)
+	if err := d.RenderPages(`<div class="filler"></div><p class="section">` + long + `</p>`); err != nil {
+		t.Fatal(err)
+	}
+	warned := slices.ContainsFunc(d.Warnings(), func(w Diagnostic) bool { return strings.Contains(w.Message, "margin boxes were prepared") })
+	if d.pageCount != d.running.lastPage && !warned {
+		t.Errorf("layout pass found %d pages, RenderPages rendered %d without a warning", d.running.lastPage, d.pageCount)
+	}
+	if d.pageCount == d.running.lastPage && warned {
+		t.Error("unexpected warning for matching page counts")
+	}
+	for page := 2; page <= d.running.lastPage; page++ {
+		for i, b := range d.running.pages[page] {
+			if prev := d.running.pages[page-1]; i < len(prev) && prev[i].vlist == b.vlist {
+				t.Errorf("page %d: margin box shares its vlist with the page before", page)
+			}
+		}
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
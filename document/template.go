@@ -0,0 +1,176 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Template holds options, fonts and stylesheets shared by many documents,
+// for example all invoices of a nightly batch. Work that does not depend on
+// the document is done once, when fonts and stylesheets are added to the
+// template: font files are read, and stylesheets are checked, their @import
+// rules (with a resource loader or in sandbox mode) and @font-face sources
+// are resolved and fetched, and the rules that bagme implements itself,
+// such as @page rules with selectors and cross-references, are extracted.
+// Resources fetched by the resource loader, including images, are cached
+// for all documents of the template until they take up 64 MiB.
+//
+// Each document still has its own PDF writer and CSS builder. The builder
+// loads the default fonts and parses the remaining CSS of the template's
+// stylesheets, the document registers the template's fonts from the data
+// held in memory, and images are embedded per document. Without a resource
+// loader, images are read from the file system by the renderer.
+//
+// A Template is safe for concurrent use: New and NewWriter may be called
+// from any number of goroutines, and each returned Document is independent
+// of the others. A single Document must still be used by one goroutine at a
+// time.
+type Template struct {
+	mu          sync.RWMutex
+	opts        []Option
+	stylesheets []*preparedCSS
+	fonts       []fontFamily
+	cache       *resourceCache
+}
+
+// fontFamily is a font family of a Template or a Document. css is set for
+// families declared by @font-face rules, which need no regular face.
+type fontFamily struct {
+	name  string
+	faces []FontFace
+	css   bool
+}
+
+// NewTemplate creates a template. The options are applied to every document
+// created from it, before the options passed to New or NewWriter.
+func NewTemplate(opts ...Option) *Template {
+	return &Template{opts: opts, cache: &resourceCache{}}
+}
+
+// AddFontFamily adds a font family to the template. The font files are read
+// once; every document registers the family from that data. See
+// Document.AddFontFamily.
+func (t *Template) AddFontFamily(name string, faces ...FontFace) error {
+	loaded := make([]FontFace, len(faces))
+	for i, f := range faces {
+		if f.Data == nil && f.Filename != "" {
+			data, err := os.ReadFile(f.Filename)
+			if err != nil {
+				return fmt.Errorf("font family %q: %w", name, err)
+			}
+			f.Data = data
+		}
+		loaded[i] = f
+	}
+	t.mu.Lock()
+	t.fonts = append(t.fonts, fontFamily{name: name, faces: loaded})
+	t.mu.Unlock()
+	return nil
+}
+
+// LoadFontsFromFS adds all font files in fsys to the template. See
+// Document.LoadFontsFromFS.
+func (t *Template) LoadFontsFromFS(fsys fs.FS) error {
+	families, err := fontFamiliesFromFS(fsys)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(families) {
+		if err := t.AddFontFamily(name, families[name]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddCSS adds CSS instructions to the template. @font-face sources are
+// resolved with the WithBaseDir, WithBaseFS, WithResourceLoader and
+// WithSandbox options of the template.
+func (t *Template) AddCSS(css string) error {
+	return t.add(stylesheet{css: css})
+}
+
+// ReadCSSFile reads the CSS file at the given path and adds it to the
+// template. Relative URLs in the file are resolved against the directory of
+// the file.
+func (t *Template) ReadCSSFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return t.add(stylesheet{css: string(data), dir: filepath.Dir(filename), name: filename})
+}
+
+// add prepares the stylesheet and loads the fonts of its @font-face rules.
+func (t *Template) add(ss stylesheet) error {
+	var cfg config
+	for _, o := range t.options(nil) {
+		o(&cfg)
+	}
+	p, err := prepareCSS(context.Background(), &cfg, parseCSS(ss.css), ss)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.stylesheets = append(t.stylesheets, p)
+	t.mu.Unlock()
+	return nil
+}
+
+// New creates a PDF file at the given path with the options and stylesheets
+// of the template.
+func (t *Template) New(filename string, opts ...Option) (*Document, error) {
+	d, err := New(filename, t.options(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return d, t.apply(d)
+}
+
+// NewWriter creates a document that writes to w with the options and
+// stylesheets of the template. See the package level NewWriter.
+func (t *Template) NewWriter(w io.Writer, opts ...Option) (*Document, error) {
+	d, err := NewWriter(w, t.options(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return d, t.apply(d)
+}
+
+// options returns the template options followed by opts.
+func (t *Template) options(opts []Option) []Option {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	all := make([]Option, 0, len(t.opts)+len(opts)+1)
+	all = append(all, withResourceCache(t.cache))
+	all = append(all, t.opts...)
+	return append(all, opts...)
+}
+
+// withResourceCache shares the resources fetched by the resource loader
+// through rc.
+func withResourceCache(rc *resourceCache) Option {
+	return func(c *config) { c.cache = rc }
+}
+
+// apply adds the fonts and stylesheets of the template to d.
+func (t *Template) apply(d *Document) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, ff := range t.fonts {
+		if err := d.AddFontFamily(ff.name, ff.faces...); err != nil {
+			return err
+		}
+	}
+	for _, p := range t.stylesheets {
+		if err := d.applyCSS(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
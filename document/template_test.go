@@ -0,0 +1,156 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestTemplate(t *testing.T) *Template {
+	t.Helper()
+	tmpl := NewTemplate(WithCreationDate(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+	if err := tmpl.AddCSS(`@page { size: A5; margin: 1cm; } body { font-family: serif; }`); err != nil {
+		t.Fatal(err)
+	}
+	cssFile := filepath.Join(t.TempDir(), "invoice.css")
+	if err := os.WriteFile(cssFile, []byte(`h1 { font-size: 18pt; }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.ReadCSSFile(cssFile); err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func renderInvoice(tmpl *Template, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	d, err := tmpl.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	d.SetGenerateOutline(false)
+	if err := d.RenderPages(fmt.Sprintf("<h1>Invoice %d</h1><p>Amount due: %d.00 EUR</p>", n%2, n%2*100)); err != nil {
+		return nil, err
+	}
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestTemplateConcurrent(t *testing.T) {
+	tmpl := newTestTemplate(t)
+	want := make([][]byte, 2)
+	for i := range want {
+		var err error
+		if want[i], err = renderInvoice(tmpl, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const n = 16
+	results := make([][]byte, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = renderInvoice(tmpl, i)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("document %d: %v", i, errs[i])
+		}
+		if !bytes.Equal(results[i], want[i%2]) {
+			t.Errorf("document %d differs from sequential rendering", i)
+		}
+	}
+}
+
+func TestTemplateDocumentsAreIndependent(t *testing.T) {
+	tmpl := newTestTemplate(t)
+	d1, err := tmpl.New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d1.AddCSS(`a.ref::after { content: target-counter(attr(href), page) }`); err != nil {
+		t.Fatal(err)
+	}
+	d2, err := tmpl.New(tempPDF(t), WithPDFA3b())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d2.xrefs) != 0 {
+		t.Error("CSS added to one document leaked into another")
+	}
+	if !d2.Frontend.Doc.Format.IsPDFA() {
+		t.Error("expected per-document option to apply")
+	}
+	if len(d1.stylesheets) != 3 || len(d2.stylesheets) != 2 {
+		t.Errorf("unexpected stylesheet counts %d and %d", len(d1.stylesheets), len(d2.stylesheets))
+	}
+}
+
+func TestTemplateReadCSSFileNotFound(t *testing.T) {
+	if err := NewTemplate().ReadCSSFile("/nonexistent/style.css"); err == nil {
+		t.Fatal("expected error for nonexistent CSS file")
+	}
+}
+
+func TestTemplateCachesResources(t *testing.T) {
+	png, err := os.ReadFile(testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"base.css": {Data: []byte(`h1 { background-image: url(bg.png) }`)},
+		"bg.png":   {Data: png},
+		"logo.png": {Data: png},
+	}
+	var mu sync.Mutex
+	fetched := make(map[string]int)
+	loader := func(ctx context.Context, ref string) (io.ReadCloser, string, error) {
+		mu.Lock()
+		fetched[ref]++
+		mu.Unlock()
+		return FSLoader(fsys)(ctx, ref)
+	}
+	tmpl := NewTemplate(WithResourceLoader(loader))
+	if err := tmpl.AddCSS(`@import "base.css"; @page :first { margin: 2cm } a::after { content: target-counter(attr(href), page) }`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		d, err := tmpl.NewWriter(io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.xrefs) != 1 || len(d.pages.rules) != 1 {
+			t.Fatalf("document %d: expected the extracted rules of the template, got %d cross-references and %d page rules", i, len(d.xrefs), len(d.pages.rules))
+		}
+		if !strings.Contains(d.stylesheets[0].css, d.tempDir) {
+			t.Errorf("document %d: expected url() to refer to a local file, got %q", i, d.stylesheets[0].css)
+		}
+		if err := d.RenderPages(`<h1>Invoice</h1><p><img src="logo.png" alt="Logo"></p>`); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ref := range []string{"base.css", "bg.png", "logo.png"} {
+		if fetched[ref] != 1 {
+			t.Errorf("expected %s to be fetched once, got %d", ref, fetched[ref])
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package document
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Attributes of the table of contents placeholder element.
+const (
+	tocAttr      = "data-bag-toc"       // marks the placeholder, e.g. <nav data-bag-toc>
+	tocDepthAttr = "data-bag-toc-depth" // deepest heading level listed, default 3
+	tocClassAttr = "data-bag-toc-class" // class prefix, default "bag-toc"
+)
+
+const (
+	defaultTOCClass = "bag-toc"
+	defaultTOCDepth = 3
+)
+
+// defaultTOCCSS styles the generated table when no custom class is given.
+const defaultTOCCSS = `table.bag-toc { width: 100%; }
+table.bag-toc td.bag-toc-page { text-align: right; }
+tr.bag-toc-level-2 td.bag-toc-title { padding-left: 1em; }
+tr.bag-toc-level-3 td.bag-toc-title { padding-left: 2em; }
+tr.bag-toc-level-4 td.bag-toc-title { padding-left: 3em; }
+tr.bag-toc-level-5 td.bag-toc-title { padding-left: 4em; }
+tr.bag-toc-level-6 td.bag-toc-title { padding-left: 5em; }
+`
+
+// tocHeading is a heading element of the document in tree order.
+type tocHeading struct {
+	node  *html.Node
+	level int
+	text  string
+	inTOC bool // inside the placeholder, never listed
+}
+
+// insertTOC fills the first element with a data-bag-toc attribute with a
+// table of contents. Each row links to its heading (headings without an id
+// get one) and shows the page number of the heading. The page numbers are
+// determined by layout passes that are repeated until they are stable, so
+// they are correct even if the table of contents itself shifts the content.
+//
+// The generated markup is
+//
+//	<table class="bag-toc">
+//	  <tr class="bag-toc-level-1">
+//	    <td class="bag-toc-title"><a href="#id">Heading</a></td>
+//	    <td class="bag-toc-page">3</td>
+//	  </tr>
+//	</table>
+//
+// The class prefix can be changed with data-bag-toc-class, in which case no
+// default styling is applied. data-bag-toc-depth limits the heading levels
+// (default 3). Existing content of the placeholder, for example a heading,
+// is kept in front of the table; a table generated before is replaced.
+// Headings whose page is not found get an empty page cell and a warning.
+// Without a placeholder src is returned unchanged.
+func (d *Document) insertTOC(src string) (string, error) {
+	if !strings.Contains(src, tocAttr) {
+		return src, nil
+	}
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	nav := findElement(doc, func(n *html.Node) bool { return hasAttr(n, tocAttr) })
+	if nav == nil {
+		return src, nil
+	}
+	depth := defaultTOCDepth
+	if v := attr(nav, tocDepthAttr); v != "" {
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < 1 || depth > 6 {
+			return "", fmt.Errorf("%s: invalid heading depth %q", tocDepthAttr, v)
+		}
+	}
+	class := attr(nav, tocClassAttr)
+	if class == "" {
+		class = defaultTOCClass
+		if !d.tocCSSAdded {
+			if err := d.AddCSS(defaultTOCCSS); err != nil {
+				return "", err
+			}
+			d.tocCSSAdded = true
+		}
+	}
+	headings := collectHeadings(doc, nav)
+	for i, h := range headings {
+		if !h.inTOC && attr(h.node, "id") == "" {
+			setAttr(h.node, "id", fmt.Sprintf("bag-toc-%d", i+1))
+		}
+	}
+
+	if last := nav.LastChild; last != nil && last.DataAtom == atom.Table && attr(last, "class") == class {
+		nav.RemoveChild(last)
+	}
+
+	var pages []int
+	var table *html.Node
+	for pass := 1; ; pass++ {
+		if table != nil {
+			nav.RemoveChild(table)
+		}
+		table = buildTOC(headings, pages, depth, class)
+		nav.AppendChild(table)
+		var sb strings.Builder
+		if err := html.Render(&sb, doc); err != nil {
+			return "", err
+		}
+		out := sb.String()
+		if pass > maxLayoutPasses {
+			return out, d.reportUnknownPages(headings, pages, depth)
+		}
+		entries, err := d.layoutPass(out)
+		if err != nil {
+			return "", err
+		}
+		newPages := headingPages(headings, entries)
+		if slices.Equal(newPages, pages) {
+			return out, d.reportUnknownPages(headings, pages, depth)
+		}
+		pages = newPages
+	}
+}
+
+// reportUnknownPages reports the headings listed in the table of contents
+// whose page was not found.
+func (d *Document) reportUnknownPages(hs []tocHeading, pages []int, depth int) error {
+	var diags []Diagnostic
+	for i, h := range hs {
+		if !h.inTOC && h.level <= depth && pages[i] == 0 {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning,
+				Element:  elementPath(h.node),
+				Message:  fmt.Sprintf("page of heading %q not found, the table of contents leaves it empty", h.text),
+			})
+		}
+	}
+	return d.report(diags)
+}
+
+// elementPath returns the path of n below body, such as "body > div > h2".
+func elementPath(n *html.Node) string {
+	var names []string
+	for ; n != nil && n.Type == html.ElementNode && n.DataAtom != atom.Html; n = n.Parent {
+		names = append([]string{n.Data}, names...)
+	}
+	return strings.Join(names, " > ")
+}
+
+// collectHeadings returns all h1–h6 elements in tree order.
+func collectHeadings(doc, nav *html.Node) []tocHeading {
+	var hs []tocHeading
+	var walk func(n *html.Node, inTOC bool)
+	walk = func(n *html.Node, inTOC bool) {
+		if n == nav {
+			inTOC = true
+		}
+		if level := headingLevel(n); level > 0 {
+			hs = append(hs, tocHeading{
+				node:  n,
+				level: level,
+				text:  strings.Join(strings.Fields(textContent(n)), " "),
+				inTOC: inTOC,
+			})
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inTOC)
+		}
+	}
+	walk(doc, false)
+	return hs
+}
+
+// headingPages maps the headings of the DOM to the headings recorded during
+// a layout pass and returns their page numbers. Entries are matched in order
+// by their text, so headings the renderer does not record are skipped
+// without shifting the others. Unmatched headings get page 0.
+func headingPages(hs []tocHeading, entries []HeadingEntry) []int {
+	pages := make([]int, len(hs))
+	next := 0
+	for i, h := range hs {
+		for k := next; k < len(entries); k++ {
+			if strings.Join(strings.Fields(entries[k].Text), " ") == h.text {
+				pages[i] = entries[k].PageNumber
+				next = k + 1
+				break
+			}
+		}
+	}
+	return pages
+}
+
+// buildTOC creates the table of contents. pages is nil in the first pass,
+// which uses 0 as a placeholder for the page numbers. Headings with page 0
+// get an empty page cell.
+func buildTOC(hs []tocHeading, pages []int, depth int, class string) *html.Node {
+	table := newElement(atom.Table, "class", class)
+	for i, h := range hs {
+		if h.inTOC || h.level > depth {
+			continue
+		}
+		page := "0"
+		if pages != nil {
+			page = ""
+			if pages[i] > 0 {
+				page = strconv.Itoa(pages[i])
+			}
+		}
+		a := newElement(atom.A, "href", "#"+attr(h.node, "id"))
+		a.AppendChild(&html.Node{Type: html.TextNode, Data: h.text})
+		title := newElement(atom.Td, "class", class+"-title")
+		title.AppendChild(a)
+		num := newElement(atom.Td, "class", class+"-page")
+		num.AppendChild(&html.Node{Type: html.TextNode, Data: page})
+		tr := newElement(atom.Tr, "class", fmt.Sprintf("%s-level-%d", class, h.level))
+		tr.AppendChild(title)
+		tr.AppendChild(num)
+		table.AppendChild(tr)
+	}
+	return table
+}
+
+// headingLevel returns 1–6 for h1–h6 elements and 0 otherwise.
+func headingLevel(n *html.Node) int {
+	if n.Type != html.ElementNode {
+		return 0
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return int(n.Data[1] - '0')
+	}
+	return 0
+}
+
+// findElement returns the first element in tree order for which match
+// returns true.
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// newElement creates an element with the given attribute key/value pairs.
+func newElement(a atom.Atom, kv ...string) *html.Node {
+	n := &html.Node{Type: html.ElementNode, DataAtom: a, Data: a.String()}
+	for i := 0; i+1 < len(kv); i += 2 {
+		n.Attr = append(n.Attr, html.Attribute{Key: kv[i], Val: kv[i+1]})
+	}
+	return n
+}
+
+// setAttr sets or replaces the attribute key of n.
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,208 @@
+package document
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const tocHTML = `<nav data-bag-toc><h2>Contents</h2></nav>
+<h1>Introduction</h1><p>Text</p>
+<h2 id="details">Details</h2><p>More text</p>
+<h3>Fine print</h3><p>Small text</p>
+<h1>Appendix</h1><p>The end</p>`
+
+// tocRows returns the title and page texts of the generated TOC rows.
+func tocRows(t *testing.T, src, class string) (titles, pages []string) {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "td" {
+			switch attr(n, "class") {
+			case class + "-title":
+				titles = append(titles, textContent(n))
+			case class + "-page":
+				pages = append(pages, textContent(n))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return titles, pages
+}
+
+func TestInsertTOC(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`h1 { page-break-before: always; }`); err != nil {
+		t.Fatal(err)
+	}
+	out, err := d.insertTOC(tocHTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles, pages := tocRows(t, out, "bag-toc")
+	wantTitles := []string{"Introduction", "Details", "Fine print", "Appendix"}
+	wantPages := []string{"2", "2", "2", "3"}
+	if strings.Join(titles, "|") != strings.Join(wantTitles, "|") {
+		t.Errorf("expected titles %v, got %v", wantTitles, titles)
+	}
+	if strings.Join(pages, "|") != strings.Join(wantPages, "|") {
+		t.Errorf("expected pages %v, got %v", wantPages, pages)
+	}
+	if !strings.Contains(out, `href="#details"`) {
+		t.Error("expected link to existing id")
+	}
+	if !strings.Contains(out, `id="bag-toc-2"`) || !strings.Contains(out, `href="#bag-toc-2"`) {
+		t.Error("expected generated id and link for heading without id")
+	}
+	if !d.tocCSSAdded {
+		t.Error("expected default TOC CSS")
+	}
+	if err := d.RenderPages(tocHTML); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertTOCDepthAndClass(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := strings.Replace(tocHTML, "data-bag-toc>", `data-bag-toc data-bag-toc-depth="1" data-bag-toc-class="contents">`, 1)
+	out, err := d.insertTOC(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles, _ := tocRows(t, out, "contents")
+	if strings.Join(titles, "|") != "Introduction|Appendix" {
+		t.Errorf("expected only h1 entries, got %v", titles)
+	}
+	if d.tocCSSAdded {
+		t.Error("custom class should not add default CSS")
+	}
+}
+
+func TestInsertTOCInvalidDepth(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.insertTOC(`<nav data-bag-toc data-bag-toc-depth="seven"></nav><h1>A</h1>`); err == nil {
+		t.Error("expected error for invalid depth")
+	}
+}
+
+func TestInsertTOCWithoutPlaceholder(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `<h1>Plain</h1><p>No table of contents</p>`
+	out, err := d.insertTOC(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != src {
+		t.Errorf("expected unchanged HTML, got %q", out)
+	}
+}
+
+func TestHeadingPages(t *testing.T) {
+	hs := []tocHeading{{text: "A"}, {text: "Skipped"}, {text: "B"}}
+	entries := []HeadingEntry{{Text: "A", PageNumber: 1}, {Text: " B ", PageNumber: 4}}
+	got := headingPages(hs, entries)
+	want := []int{1, 0, 4}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("heading %d: expected page %d, got %d", i, want[i], got[i])
+		}
+	}
+}
+
+func TestBuildTOCUnknownPage(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div><h1 id="a">A</h1><h2 id="b">B</h2></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := collectHeadings(doc, nil)
+	var sb strings.Builder
+	if err := html.Render(&sb, buildTOC(hs, nil, 3, "bag-toc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, pages := tocRows(t, sb.String(), "bag-toc"); strings.Join(pages, "|") != "0|0" {
+		t.Errorf("expected placeholders in the first pass, got %v", pages)
+	}
+	sb.Reset()
+	if err := html.Render(&sb, buildTOC(hs, []int{2, 0}, 3, "bag-toc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, pages := tocRows(t, sb.String(), "bag-toc"); strings.Join(pages, "|") != "2|" {
+		t.Errorf("expected an empty cell for the unknown page, got %v", pages)
+	}
+
+	d := &Document{}
+	if err := d.reportUnknownPages(hs, []int{2, 0}, 3); err != nil {
+		t.Fatal(err)
+	}
+	w := d.Warnings()
+	if len(w) != 1 || w[0].Severity != SeverityWarning || w[0].Element != "body > div > h2" || !strings.Contains(w[0].Message, `"B"`) {
+		t.Errorf("unexpected diagnostics %v", w)
+	}
+	d = &Document{cfg: config{strict: true}}
+	if err := d.reportUnknownPages(hs, []int{2, 0}, 3); err == nil {
+		t.Error("expected an error in strict mode")
+	}
+	if err := d.reportUnknownPages(hs, []int{2, 0}, 1); err != nil {
+		t.Errorf("headings below the depth are not listed: %v", err)
+	}
+}
+
+func TestInsertTOCWithXrefs(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`h1 { page-break-before: always; }
+a.ref::after { content: " (page " target-counter(attr(href), page) ")" }`); err != nil {
+		t.Fatal(err)
+	}
+	src := `<nav data-bag-toc></nav>
+<h1 id="intro">Introduction</h1><p>See <a class="ref" href="#end">the end</a>.</p>
+<h1 id="end">End</h1><p>Text</p>`
+	out, err := d.insertGenerated(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out, `<table class="bag-toc">`); n != 1 {
+		t.Errorf("expected one table of contents, got %d", n)
+	}
+	if n := strings.Count(out, `class="bag-xref"`); n != 1 {
+		t.Errorf("expected one cross-reference, got %d", n)
+	}
+	if !strings.Contains(out, `<span class="bag-xref"> (page 3)</span>`) {
+		t.Errorf("expected page reference in output, got %s", out)
+	}
+	if _, pages := tocRows(t, out, "bag-toc"); strings.Join(pages, "|") != "2|3" {
+		t.Errorf("unexpected TOC pages %v", pages)
+	}
+	again, err := d.insertGenerated(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != out {
+		t.Errorf("expected generated content to be replaced, got\n%s\nwant\n%s", again, out)
+	}
+}
@@ -0,0 +1,324 @@
+package document
+
+import (
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// xrefRule is a ::before or ::after rule whose content refers to the target
+// of a link, for example
+//
+//	a.pageref::after { content: " (page " target-counter(attr(href), page) ")" }
+type xrefRule struct {
+	sel     cascadia.Sel
+	before  bool
+	content string
+}
+
+// isXrefContent reports whether a content value depends on a link target.
+func isXrefContent(v string) bool {
+	return strings.Contains(v, "target-counter(") || strings.Contains(v, "target-text(")
+}
+
+// extractXrefs moves content declarations of ::before and ::after rules that
+// use target-counter() or target-text() from blocks to d.xrefs. RenderPages
+// resolves them, the other declarations go to the CSS builder unchanged.
+func (d *Document) extractXrefs(blocks []*cssBlock) {
+	for _, b := range blocks {
+		switch b.atRule() {
+		case "media":
+			d.extractXrefs(b.children)
+			continue
+		case "":
+		default:
+			continue
+		}
+		content, ok := b.get("content")
+		if !ok || !isXrefContent(content) {
+			continue
+		}
+		group, err := cascadia.ParseGroupWithPseudoElements(b.prelude)
+		if err != nil {
+			// leave it to the CSS builder
+			continue
+		}
+		found := false
+		for _, sel := range group {
+			pe := sel.PseudoElement()
+			if pe != "before" && pe != "after" {
+				continue
+			}
+			d.xrefs = append(d.xrefs, xrefRule{sel: sel, before: pe == "before", content: content})
+			found = true
+		}
+		if found {
+			decls := b.decls[:0]
+			for _, decl := range b.decls {
+				if decl.property != "content" {
+					decls = append(decls, decl)
+				}
+			}
+			b.decls = decls
+		}
+	}
+}
+
+// resolveXrefs inserts the generated content of all cross-reference rules
+// as <span class="bag-xref"> into the matching elements, replacing spans
+// inserted before. Page numbers are found with layout passes that are
+// repeated until they no longer change.
+func (d *Document) resolveXrefs(src string) (string, error) {
+	if len(d.xrefs) == 0 {
+		return src, nil
+	}
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	type ref struct {
+		n    *html.Node
+		rule xrefRule
+		span *html.Node
+	}
+	var refs []ref
+	for _, rule := range d.xrefs {
+		for _, n := range cascadia.QueryAll(doc, rule.sel) {
+			r := ref{n: n, rule: rule}
+			old := n.LastChild
+			if rule.before {
+				old = n.FirstChild
+			}
+			if isXrefSpan(old) {
+				r.span = old
+			}
+			refs = append(refs, r)
+		}
+	}
+	if len(refs) == 0 {
+		return src, nil
+	}
+	texts := map[string]string{}
+	walkElements(doc, func(n *html.Node) {
+		if id := attr(n, "id"); id != "" {
+			texts[id] = strings.Join(strings.Fields(textContent(n)), " ")
+		}
+	})
+
+	var pages map[string]int
+	for pass := 1; ; pass++ {
+		for i := range refs {
+			r := &refs[i]
+			if r.span != nil {
+				r.n.RemoveChild(r.span)
+			}
+			r.span = newElement(atom.Span, "class", "bag-xref")
+			r.span.AppendChild(&html.Node{Type: html.TextNode, Data: xrefText(r.rule.content, r.n, pages, texts)})
+			if r.rule.before {
+				r.n.InsertBefore(r.span, r.n.FirstChild)
+			} else {
+				r.n.AppendChild(r.span)
+			}
+		}
+		var sb strings.Builder
+		if err := html.Render(&sb, doc); err != nil {
+			return "", err
+		}
+		if pass > maxLayoutPasses {
+			maps.Copy(d.anchors, pages)
+			return sb.String(), nil
+		}
+		newPages, err := d.anchorPass(doc)
+		if err != nil {
+			return "", err
+		}
+		if pages != nil && maps.Equal(newPages, pages) {
+			maps.Copy(d.anchors, pages)
+			return sb.String(), nil
+		}
+		pages = newPages
+	}
+}
+
+// isXrefSpan reports whether n is a span inserted by resolveXrefs.
+func isXrefSpan(n *html.Node) bool {
+	return n != nil && n.DataAtom == atom.Span && attr(n, "class") == "bag-xref"
+}
+
+// xrefText evaluates the CSS content value for the element n. pages and
+// texts map element ids to their page number and text. Unknown targets are
+// shown as "??".
+func xrefText(content string, n *html.Node, pages map[string]int, texts map[string]string) string {
+	var sb strings.Builder
+	for _, tok := range contentTokens(content) {
+		switch tok.name {
+		case "":
+			sb.WriteString(tok.str)
+		case "attr":
+			sb.WriteString(attr(n, strings.TrimSpace(tok.args[0])))
+		case "target-counter":
+			if len(tok.args) < 2 || strings.TrimSpace(tok.args[1]) != "page" {
+				continue
+			}
+			page, ok := pages[targetID(tok.args[0], n)]
+			if !ok || page == 0 {
+				sb.WriteString("??")
+				continue
+			}
+			style := "decimal"
+			if len(tok.args) > 2 {
+				style = strings.TrimSpace(tok.args[2])
+			}
+			sb.WriteString(formatCounter(page, style))
+		case "target-text":
+			txt, ok := texts[targetID(tok.args[0], n)]
+			if !ok {
+				txt = "??"
+			}
+			sb.WriteString(txt)
+		}
+	}
+	return sb.String()
+}
+
+// contentToken is a string (name is empty) or a function of a CSS content
+// value.
+type contentToken struct {
+	str  string
+	name string
+	args []string
+}
+
+// contentTokens splits a CSS content value into strings and functions.
+// Keywords such as open-quote are ignored, an unterminated function ends
+// the value. Functions have at least one, possibly empty, argument.
+func contentTokens(v string) []contentToken {
+	var toks []contentToken
+	for i := 0; i < len(v); {
+		c := v[i]
+		switch {
+		case isCSSSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			i++
+			for i < len(v) && v[i] != c {
+				if v[i] == '\\' && i+1 < len(v) {
+					i++
+				}
+				sb.WriteByte(v[i])
+				i++
+			}
+			i++
+			toks = append(toks, contentToken{str: sb.String()})
+		default:
+			start := i
+			for i < len(v) && !isCSSSpace(v[i]) && v[i] != '(' && v[i] != '"' && v[i] != '\'' {
+				i++
+			}
+			name := strings.ToLower(v[start:i])
+			if i >= len(v) || v[i] != '(' {
+				if i == start {
+					i++
+				}
+				continue
+			}
+			depth := 0
+			argStart := i + 1
+			var args []string
+			for ; i < len(v); i++ {
+				switch v[i] {
+				case '(':
+					depth++
+				case ')':
+					depth--
+				case ',':
+					if depth == 1 {
+						args = append(args, v[argStart:i])
+						argStart = i + 1
+					}
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			if depth != 0 {
+				// unterminated function, drop it
+				return toks
+			}
+			args = append(args, v[argStart:i])
+			i++
+			toks = append(toks, contentToken{name: name, args: args})
+		}
+	}
+	return toks
+}
+
+// targetID returns the id that a target-counter() or target-text() argument
+// such as attr(href) or url(#id) points to.
+func targetID(arg string, n *html.Node) string {
+	arg = strings.TrimSpace(arg)
+	switch {
+	case strings.HasPrefix(arg, "attr(") && strings.HasSuffix(arg, ")"):
+		arg = attr(n, strings.TrimSpace(arg[5:len(arg)-1]))
+	case strings.HasPrefix(arg, "url(") && strings.HasSuffix(arg, ")"):
+		arg = strings.TrimSpace(arg[4 : len(arg)-1])
+	}
+	arg = strings.Trim(arg, `"'`)
+	return strings.TrimPrefix(arg, "#")
+}
+
+// formatCounter formats n in the CSS list-style-type style.
+func formatCounter(n int, style string) string {
+	switch style {
+	case "lower-roman":
+		return strings.ToLower(roman(n))
+	case "upper-roman":
+		return roman(n)
+	case "lower-alpha", "lower-latin":
+		return alpha(n, 'a')
+	case "upper-alpha", "upper-latin":
+		return alpha(n, 'A')
+	}
+	return strconv.Itoa(n)
+}
+
+func roman(n int) string {
+	if n <= 0 || n >= 4000 {
+		return strconv.Itoa(n)
+	}
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var sb strings.Builder
+	for i, v := range values {
+		for n >= v {
+			sb.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return sb.String()
+}
+
+func alpha(n int, first byte) string {
+	if n <= 0 {
+		return strconv.Itoa(n)
+	}
+	var b []byte
+	for n > 0 {
+		n--
+		b = append([]byte{first + byte(n%26)}, b...)
+		n /= 26
+	}
+	return string(b)
+}
+
+// Anchors returns the page number of elements by their id attribute. After
+// RenderPages it contains every heading that has an id and, if a stylesheet
+// uses target-counter() or target-text(), every element with an id.
+func (d *Document) Anchors() map[string]int {
+	return d.anchors
+}
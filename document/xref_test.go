@@ -0,0 +1,161 @@
+package document
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestContentTokens(t *testing.T) {
+	toks := contentTokens(`"see page " target-counter(attr(href), page, lower-roman) ' \'x\'' open-quote`)
+	if len(toks) != 3 {
+		t.Fatalf("expected 3 tokens, got %+v", toks)
+	}
+	if toks[0].str != "see page " {
+		t.Errorf("unexpected string %q", toks[0].str)
+	}
+	if toks[1].name != "target-counter" || len(toks[1].args) != 3 || strings.TrimSpace(toks[1].args[2]) != "lower-roman" {
+		t.Errorf("unexpected function %+v", toks[1])
+	}
+	if toks[2].str != " 'x'" {
+		t.Errorf("unexpected escaped string %q", toks[2].str)
+	}
+}
+
+func TestContentTokensMalformed(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []contentToken
+	}{
+		{`target-counter(`, nil},
+		{`"a" attr(`, []contentToken{{str: "a"}}},
+		{`"a" attr(title`, []contentToken{{str: "a"}}},
+		{`counter(page) string(x, (`, []contentToken{{name: "counter", args: []string{"page"}}}},
+		{`attr()`, []contentToken{{name: "attr", args: []string{""}}}},
+		{`target-counter(,)`, []contentToken{{name: "target-counter", args: []string{"", ""}}}},
+		{`"unterminated`, []contentToken{{str: "unterminated"}}},
+		{`"x\`, []contentToken{{str: "x\\"}}},
+		{`(`, nil},
+		{``, nil},
+	}
+	for _, tt := range tests {
+		got := contentTokens(tt.in)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].str != tt.want[i].str || got[i].name != tt.want[i].name || strings.Join(got[i].args, "|") != strings.Join(tt.want[i].args, "|") {
+				t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+			}
+		}
+	}
+	// margin boxes and string-set use the same tokenizer
+	if isRunningContent(`string(`) || len(stringSetValues(`chapter content(`, &html.Node{})) != 1 {
+		t.Error("unexpected result for unterminated functions")
+	}
+}
+
+func TestXrefText(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<a href="#intro">link</a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a *html.Node
+	walkElements(doc, func(n *html.Node) {
+		if n.Data == "a" {
+			a = n
+		}
+	})
+	pages := map[string]int{"intro": 4}
+	texts := map[string]string{"intro": "Introduction"}
+	tests := []struct {
+		content, want string
+	}{
+		{`" (page " target-counter(attr(href), page) ")"`, " (page 4)"},
+		{`target-counter(attr(href), page, upper-roman)`, "IV"},
+		{`"see " target-text(attr(href))`, "see Introduction"},
+		{`target-counter(url(#missing), page)`, "??"},
+		{`attr(href)`, "#intro"},
+	}
+	for _, tt := range tests {
+		if got := xrefText(tt.content, a, pages, texts); got != tt.want {
+			t.Errorf("xrefText(%s) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCounter(t *testing.T) {
+	tests := []struct {
+		n     int
+		style string
+		want  string
+	}{
+		{12, "decimal", "12"},
+		{1994, "upper-roman", "MCMXCIV"},
+		{9, "lower-roman", "ix"},
+		{28, "lower-alpha", "ab"},
+	}
+	for _, tt := range tests {
+		if got := formatCounter(tt.n, tt.style); got != tt.want {
+			t.Errorf("formatCounter(%d, %s) = %q, want %q", tt.n, tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestExtractXrefs(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddCSS(`a.ref::after { content: " (p. " target-counter(attr(href), page) ")"; color: blue }
+a.plain::after { content: "*" }`); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.xrefs) != 1 {
+		t.Fatalf("expected 1 cross-reference rule, got %d", len(d.xrefs))
+	}
+	if d.xrefs[0].before {
+		t.Error("expected ::after rule")
+	}
+}
+
+func TestCrossReferences(t *testing.T) {
+	d, err := New(tempPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	css := `.chapter { page-break-before: always; }
+a.ref::after { content: " (page " target-counter(attr(href), page) ")" }
+a.title::before { content: target-text(attr(href)) ": " }`
+	if err := d.AddCSS(css); err != nil {
+		t.Fatal(err)
+	}
+	src := `<p>See <a class="ref" href="#results">results</a> and <a class="title" href="#methods">here</a>.</p>
+<div class="chapter"><h1 id="methods">Methods</h1><p>Text</p></div>
+<div class="chapter"><p id="results">Results paragraph</p></div>`
+	out, err := d.resolveXrefs(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `<span class="bag-xref"> (page 3)</span>`) {
+		t.Errorf("expected page reference in output, got %s", out)
+	}
+	if !strings.Contains(out, `<span class="bag-xref">Methods: </span>`) {
+		t.Errorf("expected target text in output, got %s", out)
+	}
+	if strings.Contains(out, probeAttr) {
+		t.Error("probes must not remain in the output")
+	}
+	if err := d.RenderPages(src); err != nil {
+		t.Fatal(err)
+	}
+	anchors := d.Anchors()
+	if anchors["results"] != 3 || anchors["methods"] != 2 {
+		t.Errorf("unexpected anchors %v", anchors)
+	}
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,395 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/document"
+)
+
+// ErrInvalidEInvoice is wrapped by the errors New and NewWriter return for
+// XML invoice data passed to WithZUGFeRD or WithEInvoice that is malformed,
+// does not belong to the standard or does not match the profile or the
+// expected total.
+var ErrInvalidEInvoice = errors.New("invalid e-invoice")
+
+// EInvoiceStandard is the standard of an XML invoice embedded in the PDF. It
+// determines the attachment file name and the XMP metadata.
+type EInvoiceStandard int
+
+const (
+	// FacturX is Factur-X 1.0, which is identical to ZUGFeRD 2.1 and later.
+	FacturX EInvoiceStandard = iota
+	// ZUGFeRD2 is ZUGFeRD 2.0.
+	ZUGFeRD2
+	// ZUGFeRD1 is ZUGFeRD 1.0 with the CrossIndustryDocument syntax.
+	ZUGFeRD1
+	// XRechnung is an XRechnung (CII syntax) embedded as defined by
+	// Factur-X for the XRECHNUNG profile.
+	XRechnung
+)
+
+// AFRelationship values of the embedded invoice. Factur-X requires Data for
+// the MINIMUM and BASIC WL profiles, which are no complete invoices, and
+// allows Alternative or Source for the others.
+const (
+	AFRelationshipData        = "Data"
+	AFRelationshipAlternative = "Alternative"
+	AFRelationshipSource      = "Source"
+)
+
+// EInvoice is an XML invoice to embed with WithEInvoice.
+type EInvoice struct {
+	XML      []byte
+	Standard EInvoiceStandard
+	// Profile is the conformance level, for example "EN 16931". See
+	// WithZUGFeRD for the profiles of Factur-X; ZUGFeRD 1.0 has BASIC,
+	// COMFORT and EXTENDED, XRechnung has XRECHNUNG.
+	Profile string
+	// AFRelationship is the relationship of the attachment to the PDF.
+	// Empty selects the default of the standard and profile.
+	AFRelationship string
+}
+
+// eInvoiceSpec describes how an invoice of a standard is embedded and
+// where bagme finds its guideline and total.
+type eInvoiceSpec struct {
+	name          string
+	fileName      string
+	schema        string
+	namespace     string
+	prefix        string
+	version       string
+	rootName      string
+	rootNamespace string
+	guidelinePath string
+	totalPath     string
+	profiles      map[string][]string // guideline identifiers by profile
+	// guidelinePrefixes replace profiles for XRechnung, whose identifiers
+	// end with the XRechnung version.
+	guidelinePrefixes []string
+}
+
+const (
+	ciiGuidelinePath = "CrossIndustryInvoice/ExchangedDocumentContext/GuidelineSpecifiedDocumentContextParameter/ID"
+	ciiTotalPath     = "CrossIndustryInvoice/SupplyChainTradeTransaction/ApplicableHeaderTradeSettlement/SpecifiedTradeSettlementHeaderMonetarySummation/GrandTotalAmount"
+	// ciiNamespace is the namespace of the UN/CEFACT Cross Industry
+	// Invoice used by Factur-X, ZUGFeRD 2 and XRechnung.
+	ciiNamespace = "urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"
+)
+
+var eInvoiceSpecs = map[EInvoiceStandard]eInvoiceSpec{
+	FacturX: {
+		name:          "Factur-X",
+		fileName:      "factur-x.xml",
+		schema:        "Factur-X PDFA Extension Schema",
+		namespace:     "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#",
+		prefix:        "fx",
+		version:       "1.0",
+		rootName:      "CrossIndustryInvoice",
+		rootNamespace: ciiNamespace,
+		guidelinePath: ciiGuidelinePath,
+		totalPath:     ciiTotalPath,
+		profiles: map[string][]string{
+			"MINIMUM":  {"urn:factur-x.eu:1p0:minimum"},
+			"BASIC WL": {"urn:factur-x.eu:1p0:basicwl"},
+			"BASIC":    {"urn:cen.eu:en16931:2017#compliant#urn:factur-x.eu:1p0:basic"},
+			"EN 16931": {"urn:cen.eu:en16931:2017"},
+			"EXTENDED": {"urn:cen.eu:en16931:2017#conformant#urn:factur-x.eu:1p0:extended"},
+		},
+	},
+	ZUGFeRD2: {
+		name:          "ZUGFeRD 2.0",
+		fileName:      "zugferd-invoice.xml",
+		schema:        "ZUGFeRD PDFA Extension Schema",
+		namespace:     "urn:zugferd:pdfa:CrossIndustryDocument:invoice:2p0#",
+		prefix:        "fx",
+		version:       "1.0",
+		rootName:      "CrossIndustryInvoice",
+		rootNamespace: ciiNamespace,
+		guidelinePath: ciiGuidelinePath,
+		totalPath:     ciiTotalPath,
+		profiles: map[string][]string{
+			"MINIMUM":  {"urn:zugferd.de:2p0:minimum"},
+			"BASIC WL": {"urn:zugferd.de:2p0:basicwl"},
+			"BASIC":    {"urn:cen.eu:en16931:2017#compliant#urn:zugferd.de:2p0:basic"},
+			"EN 16931": {"urn:cen.eu:en16931:2017"},
+			"EXTENDED": {"urn:cen.eu:en16931:2017#conformant#urn:zugferd.de:2p0:extended"},
+		},
+	},
+	ZUGFeRD1: {
+		name:          "ZUGFeRD 1.0",
+		fileName:      "ZUGFeRD-invoice.xml",
+		schema:        "ZUGFeRD PDFA Extension Schema",
+		namespace:     "urn:ferd:pdfa:CrossIndustryDocument:invoice:1p0#",
+		prefix:        "zf",
+		version:       "1.0",
+		rootName:      "CrossIndustryDocument",
+		rootNamespace: "urn:ferd:CrossIndustryDocument:invoice:1p0",
+		guidelinePath: "CrossIndustryDocument/SpecifiedExchangedDocumentContext/GuidelineSpecifiedDocumentContextParameter/ID",
+		totalPath:     "CrossIndustryDocument/SpecifiedSupplyChainTradeTransaction/ApplicableSupplyChainTradeSettlement/SpecifiedTradeSettlementMonetarySummation/GrandTotalAmount",
+		profiles: map[string][]string{
+			"BASIC":    {"urn:ferd:CrossIndustryDocument:invoice:1p0:basic"},
+			"COMFORT":  {"urn:ferd:CrossIndustryDocument:invoice:1p0:comfort"},
+			"EXTENDED": {"urn:ferd:CrossIndustryDocument:invoice:1p0:extended"},
+		},
+	},
+	XRechnung: {
+		name:          "XRechnung",
+		fileName:      "xrechnung.xml",
+		schema:        "Factur-X PDFA Extension Schema",
+		namespace:     "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#",
+		prefix:        "fx",
+		version:       "1.0",
+		rootName:      "CrossIndustryInvoice",
+		rootNamespace: ciiNamespace,
+		guidelinePath: ciiGuidelinePath,
+		totalPath:     ciiTotalPath,
+		profiles:      map[string][]string{"XRECHNUNG": nil},
+		guidelinePrefixes: []string{
+			"urn:cen.eu:en16931:2017#compliant#urn:xeinkauf.de:kosit:xrechnung_",
+			"urn:cen.eu:en16931:2017#compliant#urn:xoev-de:kosit:standard:xrechnung_",
+		},
+	},
+}
+
+// profileNames returns the profiles of the standard for error messages.
+func (s eInvoiceSpec) profileNames() string {
+	var names []string
+	for _, p := range []string{"MINIMUM", "BASIC WL", "BASIC", "COMFORT", "EN 16931", "EXTENDED", "XRECHNUNG"} {
+		if _, ok := s.profiles[p]; ok {
+			names = append(names, p)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// normalizeProfile returns the official XMP conformance level for profile
+// and common spellings of it, or an error for profiles the standard does
+// not have.
+func (s eInvoiceSpec) normalizeProfile(profile string) (string, error) {
+	p := strings.ToUpper(strings.TrimSpace(profile))
+	switch p {
+	case "EN16931", "COMFORT":
+		if _, ok := s.profiles["COMFORT"]; !ok {
+			p = "EN 16931"
+		}
+	case "BASICWL":
+		p = "BASIC WL"
+	}
+	if _, ok := s.profiles[p]; ok {
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: unknown %s profile %q, use %s", ErrInvalidEInvoice, s.name, profile, s.profileNames())
+}
+
+// matchesProfile reports whether the guideline identifier belongs to the
+// normalized profile.
+func (s eInvoiceSpec) matchesProfile(guideline, profile string) bool {
+	if len(s.guidelinePrefixes) > 0 {
+		for _, prefix := range s.guidelinePrefixes {
+			if strings.HasPrefix(guideline, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, g := range s.profiles[profile] {
+		if guideline == g {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultAFRelationship returns the relationship for an invoice of the
+// profile.
+func defaultAFRelationship(profile string) string {
+	if profile == "MINIMUM" || profile == "BASIC WL" {
+		return AFRelationshipData
+	}
+	return AFRelationshipAlternative
+}
+
+// xmlInvoice is the part of an XML invoice that bagme checks.
+type xmlInvoice struct {
+	rootName      string
+	rootNamespace string
+	guideline     string
+	grandTotal    string
+}
+
+// parseInvoice checks that data is well-formed XML and returns its root
+// element together with the guideline identifier and the grand total found
+// at the paths of spec.
+func parseInvoice(data []byte, spec eInvoiceSpec) (xmlInvoice, error) {
+	var inv xmlInvoice
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var path []string
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return inv, fmt.Errorf("%w: %v", ErrInvalidEInvoice, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(path) == 0 && inv.rootName == "" {
+				inv.rootName, inv.rootNamespace = t.Name.Local, t.Name.Space
+			}
+			path = append(path, t.Name.Local)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			switch strings.Join(path, "/") {
+			case spec.guidelinePath:
+				inv.guideline += string(t)
+			case spec.totalPath:
+				inv.grandTotal += string(t)
+			}
+		}
+	}
+	if inv.rootName == "" {
+		return inv, fmt.Errorf("%w: no XML element", ErrInvalidEInvoice)
+	}
+	inv.guideline = strings.TrimSpace(inv.guideline)
+	inv.grandTotal = strings.TrimSpace(inv.grandTotal)
+	return inv, nil
+}
+
+// detectEInvoiceStandard returns the standard of the XML invoice data, or
+// Factur-X if it cannot be told.
+func detectEInvoiceStandard(data []byte, profile string) EInvoiceStandard {
+	inv, err := parseInvoice(data, eInvoiceSpecs[FacturX])
+	switch {
+	case err != nil:
+		return FacturX
+	case inv.rootName == eInvoiceSpecs[ZUGFeRD1].rootName:
+		return ZUGFeRD1
+	case strings.Contains(inv.guideline, "urn:zugferd.de:2p0:"):
+		return ZUGFeRD2
+	case strings.Contains(inv.guideline, "xrechnung"), strings.EqualFold(strings.TrimSpace(profile), "XRECHNUNG"):
+		return XRechnung
+	}
+	return FacturX
+}
+
+// validateEInvoice checks the XML invoice data against the standard and the
+// normalized profile.
+func validateEInvoice(data []byte, spec eInvoiceSpec, profile string) (xmlInvoice, error) {
+	inv, err := parseInvoice(data, spec)
+	if err != nil {
+		return inv, err
+	}
+	if inv.rootName != spec.rootName || inv.rootNamespace != spec.rootNamespace {
+		return inv, fmt.Errorf("%w: %s requires the root element %s in namespace %s, got %s", ErrInvalidEInvoice, spec.name, spec.rootName, spec.rootNamespace, inv.rootName)
+	}
+	if inv.guideline == "" {
+		return inv, fmt.Errorf("%w: GuidelineSpecifiedDocumentContextParameter/ID is missing", ErrInvalidEInvoice)
+	}
+	if !spec.matchesProfile(inv.guideline, profile) {
+		return inv, fmt.Errorf("%w: %s profile %s does not match the guideline %s of the XML", ErrInvalidEInvoice, spec.name, profile, inv.guideline)
+	}
+	return inv, nil
+}
+
+// WithEInvoice embeds an XML invoice of the given standard. Like
+// WithZUGFeRD it sets the format to PDF/A-3b and adds the XMP extension
+// schema; the attachment name, the XMP namespace and version follow the
+// standard. New and NewWriter return an error wrapping ErrInvalidEInvoice
+// if the profile is unknown or the XML does not belong to the standard and
+// profile.
+func WithEInvoice(e EInvoice) Option {
+	spec, ok := eInvoiceSpecs[e.Standard]
+	var profile string
+	var inv xmlInvoice
+	var err error
+	if !ok {
+		err = fmt.Errorf("%w: unknown standard %d", ErrInvalidEInvoice, e.Standard)
+	} else if profile, err = spec.normalizeProfile(e.Profile); err == nil {
+		inv, err = validateEInvoice(e.XML, spec, profile)
+	}
+	rel := e.AFRelationship
+	switch rel {
+	case "":
+		rel = defaultAFRelationship(profile)
+	case AFRelationshipData, AFRelationshipAlternative, AFRelationshipSource:
+	default:
+		if err == nil {
+			err = fmt.Errorf("%w: AFRelationship must be Data, Alternative or Source, got %q", ErrInvalidEInvoice, rel)
+		}
+	}
+	return func(c *config) {
+		if err != nil {
+			c.setErr(err)
+			return
+		}
+		c.einvoice = &inv
+		// Additive: only the PDF/A sub-conformance is set. If the caller
+		// also passed WithPDFUA()/WithPDFUA2(), that PDFUA setting is
+		// preserved — the resulting PDF declares both standards.
+		c.format.PDFA = &document.PDFAConf{Part: 3, Level: document.PDFALevelB}
+		c.attachments = append(c.attachments, document.Attachment{
+			Name:           spec.fileName,
+			Description:    spec.name + " invoice",
+			MimeType:       "text/xml",
+			AFRelationship: rel,
+			Data:           e.XML,
+		})
+		c.xmpExtensions = append(c.xmpExtensions, document.XMPExtension{
+			Schema:       spec.schema,
+			NamespaceURI: spec.namespace,
+			Prefix:       spec.prefix,
+			Properties: []document.XMPExtensionProperty{
+				{Name: "DocumentFileName", ValueType: "Text", Category: "external", Description: "name of the embedded XML invoice file"},
+				{Name: "DocumentType", ValueType: "Text", Category: "external", Description: "INVOICE"},
+				{Name: "Version", ValueType: "Text", Category: "external", Description: "The actual version of the " + spec.name + " data"},
+				{Name: "ConformanceLevel", ValueType: "Text", Category: "external", Description: "The conformance level of the " + spec.name + " data"},
+			},
+			Values: map[string]string{
+				"ConformanceLevel": profile,
+				"DocumentFileName": spec.fileName,
+				"DocumentType":     "INVOICE",
+				"Version":          spec.version,
+			},
+		})
+	}
+}
+
+// WithZUGFeRDTotal makes New and NewWriter check that the grand total
+// (GrandTotalAmount, including tax) of the invoice passed to WithZUGFeRD or
+// WithEInvoice equals total, a decimal number such as "1234.50". Use this
+// to make sure the embedded XML belongs to the rendered invoice.
+func WithZUGFeRDTotal(total string) Option {
+	return func(c *config) { c.einvoiceTotal = total }
+}
+
+// checkEInvoiceTotal compares the grand total of the invoice with the
+// total given by WithZUGFeRDTotal.
+func (c *config) checkEInvoiceTotal() error {
+	if c.einvoiceTotal == "" {
+		return nil
+	}
+	if c.einvoice == nil {
+		return errors.New("WithZUGFeRDTotal requires WithZUGFeRD or WithEInvoice")
+	}
+	want, ok := new(big.Rat).SetString(strings.TrimSpace(c.einvoiceTotal))
+	if !ok {
+		return fmt.Errorf("WithZUGFeRDTotal: %q is not a number", c.einvoiceTotal)
+	}
+	got, ok := new(big.Rat).SetString(c.einvoice.grandTotal)
+	if !ok {
+		return fmt.Errorf("%w: GrandTotalAmount %q is missing or not a number", ErrInvalidEInvoice, c.einvoice.grandTotal)
+	}
+	if got.Cmp(want) != 0 {
+		return fmt.Errorf("%w: GrandTotalAmount is %s, expected %s", ErrInvalidEInvoice, c.einvoice.grandTotal, c.einvoiceTotal)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// testGuidelines are guideline identifiers for each profile.
+var testGuidelines = map[string]string{
+	"MINIMUM":   "urn:factur-x.eu:1p0:minimum",
+	"BASIC WL":  "urn:factur-x.eu:1p0:basicwl",
+	"BASIC":     "urn:cen.eu:en16931:2017#compliant#urn:factur-x.eu:1p0:basic",
+	"EN 16931":  "urn:cen.eu:en16931:2017",
+	"EXTENDED":  "urn:cen.eu:en16931:2017#conformant#urn:factur-x.eu:1p0:extended",
+	"XRECHNUNG": "urn:cen.eu:en16931:2017#compliant#urn:xeinkauf.de:kosit:xrechnung_3.0",
+}
+
+// testCII returns a minimal Cross Industry Invoice with the guideline and
+// the grand total.
+func testCII(guideline, total string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<rsm:CrossIndustryInvoice xmlns:rsm="urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"
+    xmlns:ram="urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:100">
+  <rsm:ExchangedDocumentContext>
+    <ram:GuidelineSpecifiedDocumentContextParameter>
+      <ram:ID>%s</ram:ID>
+    </ram:GuidelineSpecifiedDocumentContextParameter>
+  </rsm:ExchangedDocumentContext>
+  <rsm:SupplyChainTradeTransaction>
+    <ram:ApplicableHeaderTradeSettlement>
+      <ram:SpecifiedTradeSettlementHeaderMonetarySummation>
+        <ram:GrandTotalAmount>%s</ram:GrandTotalAmount>
+      </ram:SpecifiedTradeSettlementHeaderMonetarySummation>
+    </ram:ApplicableHeaderTradeSettlement>
+  </rsm:SupplyChainTradeTransaction>
+</rsm:CrossIndustryInvoice>`, guideline, total))
+}
+
+func TestWithZUGFeRDValidation(t *testing.T) {
+	basic := testCII(testGuidelines["BASIC"], "119.00")
+	tests := []struct {
+		name string
+		opts []Option
+		ok   bool
+	}{
+		{"valid", []Option{WithZUGFeRD(basic, "basic")}, true},
+		{"ZUGFeRD 2.0 guideline", []Option{WithZUGFeRD(testCII("urn:cen.eu:en16931:2017#compliant#urn:zugferd.de:2p0:basic", "1"), "BASIC")}, true},
+		{"XRechnung 2.3", []Option{WithZUGFeRD(testCII("urn:cen.eu:en16931:2017#compliant#urn:xoev-de:kosit:standard:xrechnung_2.3", "1"), "XRECHNUNG")}, true},
+		{"unknown profile", []Option{WithZUGFeRD(basic, "EN1631")}, false},
+		{"profile mismatch", []Option{WithZUGFeRD(basic, "EN 16931")}, false},
+		{"malformed XML", []Option{WithZUGFeRD([]byte("<rsm:CrossIndustryInvoice>"), "BASIC")}, false},
+		{"not CII", []Option{WithZUGFeRD([]byte(`<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"/>`), "BASIC")}, false},
+		{"empty", []Option{WithZUGFeRD(nil, "BASIC")}, false},
+		{"missing guideline", []Option{WithZUGFeRD(testCII("", "1"), "BASIC")}, false},
+		{"total matches", []Option{WithZUGFeRD(basic, "BASIC"), WithZUGFeRDTotal("119")}, true},
+		{"total option first", []Option{WithZUGFeRDTotal("119.0"), WithZUGFeRD(basic, "BASIC")}, true},
+		{"total differs", []Option{WithZUGFeRD(basic, "BASIC"), WithZUGFeRDTotal("118.99")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyOptions(tt.opts)
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidEInvoice) {
+				t.Errorf("expected ErrInvalidEInvoice, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWithZUGFeRDTotalRequiresInvoice(t *testing.T) {
+	if _, err := applyOptions([]Option{WithZUGFeRDTotal("1")}); err == nil {
+		t.Error("expected error without WithZUGFeRD")
+	}
+}
+
+func TestNewRejectsInvalidZUGFeRD(t *testing.T) {
+	if _, err := New(tempPDF(t), WithZUGFeRD(testCII(testGuidelines["MINIMUM"], "1"), "EXTENDED")); !errors.Is(err, ErrInvalidEInvoice) {
+		t.Errorf("expected ErrInvalidEInvoice from New, got %v", err)
+	}
+	if _, err := NewWriter(io.Discard, WithZUGFeRD([]byte("no xml"), "BASIC")); !errors.Is(err, ErrInvalidEInvoice) {
+		t.Errorf("expected ErrInvalidEInvoice from NewWriter, got %v", err)
+	}
+}
+
+// testZUGFeRD1 returns a minimal ZUGFeRD 1.0 invoice of the profile.
+func testZUGFeRD1(profile string) []byte {
+	return []byte(`<rsm:CrossIndustryDocument xmlns:rsm="urn:ferd:CrossIndustryDocument:invoice:1p0"
+    xmlns:ram="urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:12">
+  <rsm:SpecifiedExchangedDocumentContext>
+    <ram:GuidelineSpecifiedDocumentContextParameter><ram:ID>urn:ferd:CrossIndustryDocument:invoice:1p0:` + profile + `</ram:ID></ram:GuidelineSpecifiedDocumentContextParameter>
+  </rsm:SpecifiedExchangedDocumentContext>
+  <rsm:SpecifiedSupplyChainTradeTransaction>
+    <ram:ApplicableSupplyChainTradeSettlement>
+      <ram:SpecifiedTradeSettlementMonetarySummation><ram:GrandTotalAmount currencyID="EUR">42.00</ram:GrandTotalAmount></ram:SpecifiedTradeSettlementMonetarySummation>
+    </ram:ApplicableSupplyChainTradeSettlement>
+  </rsm:SpecifiedSupplyChainTradeTransaction>
+</rsm:CrossIndustryDocument>`)
+}
+
+func TestEInvoiceStandards(t *testing.T) {
+	tests := []struct {
+		name            string
+		opt             Option
+		file, ns, level string
+		rel             string
+	}{
+		{"Factur-X", WithZUGFeRD(testCII(testGuidelines["EN 16931"], "1"), "EN16931"),
+			"factur-x.xml", "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#", "EN 16931", "Alternative"},
+		{"Factur-X MINIMUM", WithZUGFeRD(testCII(testGuidelines["MINIMUM"], "1"), "MINIMUM"),
+			"factur-x.xml", "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#", "MINIMUM", "Data"},
+		{"ZUGFeRD 2.0 detected", WithZUGFeRD(testCII("urn:zugferd.de:2p0:basicwl", "1"), "BASIC WL"),
+			"zugferd-invoice.xml", "urn:zugferd:pdfa:CrossIndustryDocument:invoice:2p0#", "BASIC WL", "Data"},
+		{"ZUGFeRD 1.0 detected", WithZUGFeRD(testZUGFeRD1("comfort"), "COMFORT"),
+			"ZUGFeRD-invoice.xml", "urn:ferd:pdfa:CrossIndustryDocument:invoice:1p0#", "COMFORT", "Alternative"},
+		{"XRechnung detected", WithZUGFeRD(testCII(testGuidelines["XRECHNUNG"], "1"), "XRECHNUNG"),
+			"xrechnung.xml", "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#", "XRECHNUNG", "Alternative"},
+		{"explicit relationship", WithEInvoice(EInvoice{XML: testCII(testGuidelines["EXTENDED"], "1"), Standard: FacturX, Profile: "EXTENDED", AFRelationship: AFRelationshipSource}),
+			"factur-x.xml", "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#", "EXTENDED", "Source"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := applyOptions([]Option{tt.opt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := cfg.attachments[0]
+			if a.Name != tt.file || a.AFRelationship != tt.rel {
+				t.Errorf("expected attachment %s (%s), got %s (%s)", tt.file, tt.rel, a.Name, a.AFRelationship)
+			}
+			ext := cfg.xmpExtensions[0]
+			if ext.NamespaceURI != tt.ns || ext.Values["ConformanceLevel"] != tt.level || ext.Values["DocumentFileName"] != tt.file {
+				t.Errorf("unexpected XMP extension %s %v", ext.NamespaceURI, ext.Values)
+			}
+		})
+	}
+}
+
+func TestWithEInvoiceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		e    EInvoice
+	}{
+		{"Factur-X XML as ZUGFeRD 1.0", EInvoice{XML: testCII(testGuidelines["BASIC"], "1"), Standard: ZUGFeRD1, Profile: "BASIC"}},
+		{"ZUGFeRD 1.0 XML as Factur-X", EInvoice{XML: testZUGFeRD1("basic"), Standard: FacturX, Profile: "BASIC"}},
+		{"COMFORT is no ZUGFeRD 1.0 EN 16931", EInvoice{XML: testZUGFeRD1("comfort"), Standard: ZUGFeRD1, Profile: "EN 16931"}},
+		{"Factur-X guideline as ZUGFeRD 2.0", EInvoice{XML: testCII(testGuidelines["BASIC"], "1"), Standard: ZUGFeRD2, Profile: "BASIC"}},
+		{"XRechnung with EN 16931 guideline", EInvoice{XML: testCII(testGuidelines["EN 16931"], "1"), Standard: XRechnung, Profile: "XRECHNUNG"}},
+		{"unknown standard", EInvoice{XML: testCII(testGuidelines["BASIC"], "1"), Standard: 99, Profile: "BASIC"}},
+		{"unknown relationship", EInvoice{XML: testCII(testGuidelines["BASIC"], "1"), Profile: "BASIC", AFRelationship: "Supplement"}},
+	}
+	for _, tt := range tests {
+		if _, err := applyOptions([]Option{WithEInvoice(tt.e)}); !errors.Is(err, ErrInvalidEInvoice) {
+			t.Errorf("%s: expected ErrInvalidEInvoice, got %v", tt.name, err)
+		}
+	}
+	if _, err := applyOptions([]Option{WithZUGFeRD(testZUGFeRD1("basic"), "BASIC"), WithZUGFeRDTotal("42")}); err != nil {
+		t.Errorf("ZUGFeRD 1.0 total: unexpected error %v", err)
+	}
+}
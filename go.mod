@@ -3,14 +3,17 @@ module github.com/boxesandglue/bagme
 go 1.24.0
 
 require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/boxesandglue/boxesandglue v0.2.33
 	github.com/boxesandglue/csshtml v0.0.12
 	github.com/boxesandglue/htmlbag v0.0.32
+	github.com/speedata/barcode v1.1.1
+	golang.org/x/net v0.48.0
 )
 
 require (
 	github.com/PuerkitoBio/goquery v1.11.0 // indirect
-	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/boxesandglue/baseline-pdf v1.1.16 // indirect
 	github.com/boxesandglue/gofpdi v1.0.23 // indirect
 	github.com/boxesandglue/svgreader v0.0.4 // indirect
@@ -18,8 +21,6 @@ require (
 	github.com/clipperhouse/stringish v0.1.1 // indirect
 	github.com/clipperhouse/uax29/v2 v2.5.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/speedata/barcode v1.1.1 // indirect
 	github.com/speedata/css v1.0.5 // indirect
 	github.com/speedata/hyphenation v1.0.2 // indirect
-	golang.org/x/net v0.48.0 // indirect
 )
@@ -0,0 +1,557 @@
+// Package pdfread reads the objects of a PDF file. It is a small parser for
+// the needs of bagme, not a general PDF library: objects are located by
+// scanning the file instead of following the cross-reference table, which
+// also copes with files whose offsets are wrong. Encrypted files are not
+// supported.
+package pdfread
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+// ErrFormat is returned for data that is not a PDF file the parser can read.
+var ErrFormat = errors.New("pdfread: invalid PDF")
+
+// maxDecoded limits the size of a decoded stream and the total size of the
+// object streams decoded by Parse.
+const maxDecoded = 64 << 20
+
+// Object types. Strings are returned as Go strings holding the raw bytes,
+// integers as int and reals as float64; booleans and null as bool and nil.
+type (
+	// Name is a PDF name without the slash, with #xx escapes decoded.
+	Name string
+	// Dict is a PDF dictionary.
+	Dict map[Name]any
+	// Array is a PDF array.
+	Array []any
+	// Ref is an indirect reference.
+	Ref struct{ Num, Gen int }
+	// Stream is a stream object with its undecoded data.
+	Stream struct {
+		Dict Dict
+		Raw  []byte
+	}
+)
+
+// File is a parsed PDF file.
+type File struct {
+	objects map[int]any
+	trailer Dict
+}
+
+var objPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// Parse reads all objects of the PDF data.
+func Parse(data []byte) (*File, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: missing %%PDF header", ErrFormat)
+	}
+	f := &File{objects: make(map[int]any)}
+	var objStreams []Stream
+	pos := 0
+	for {
+		loc := objPattern.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		p := &parser{data: data, pos: pos + loc[1]}
+		obj, err := p.object()
+		if err != nil {
+			pos += loc[1]
+			continue
+		}
+		if d, ok := obj.(Dict); ok {
+			p.skipSpace()
+			if p.keyword("stream") {
+				s, err := p.stream(d)
+				if err != nil {
+					pos += loc[1]
+					continue
+				}
+				obj = s
+				switch s.Dict["Type"] {
+				case Name("ObjStm"):
+					objStreams = append(objStreams, s)
+				case Name("XRef"):
+					f.trailer = s.Dict
+				}
+			}
+		}
+		f.objects[num] = obj
+		pos = p.pos
+	}
+	budget := maxDecoded
+	for _, s := range objStreams {
+		budget -= f.readObjectStream(s, budget)
+		if budget <= 0 {
+			break
+		}
+	}
+	if i := bytes.LastIndex(data, []byte("trailer")); i >= 0 {
+		p := &parser{data: data, pos: i + len("trailer")}
+		if obj, err := p.object(); err == nil {
+			if d, ok := obj.(Dict); ok {
+				f.trailer = d
+			}
+		}
+	}
+	if len(f.objects) == 0 {
+		return nil, fmt.Errorf("%w: no objects", ErrFormat)
+	}
+	if f.trailer != nil && f.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("%w: encrypted files are not supported", ErrFormat)
+	}
+	return f, nil
+}
+
+// readObjectStream adds the objects compressed in s that are not defined
+// directly in the file. The stream is decoded to at most limit bytes; the
+// number of decoded bytes is returned.
+func (f *File) readObjectStream(s Stream, limit int) int {
+	data, err := s.decode(limit)
+	if err != nil {
+		return 0
+	}
+	n, _ := f.Resolve(s.Dict["N"]).(int)
+	first, _ := f.Resolve(s.Dict["First"]).(int)
+	if first < 0 || first > len(data) {
+		return len(data)
+	}
+	header := &parser{data: data[:first]}
+	for i := 0; i < n; i++ {
+		num, err1 := header.object()
+		offset, err2 := header.object()
+		if err1 != nil || err2 != nil {
+			return len(data)
+		}
+		objNum, ok1 := num.(int)
+		off, ok2 := offset.(int)
+		if !ok1 || !ok2 || off < 0 || first+off > len(data) {
+			return len(data)
+		}
+		if _, exists := f.objects[objNum]; exists {
+			continue
+		}
+		p := &parser{data: data, pos: first + off}
+		if obj, err := p.object(); err == nil {
+			f.objects[objNum] = obj
+		}
+	}
+	return len(data)
+}
+
+// Trailer returns the trailer dictionary, or the dictionary of the
+// cross-reference stream. It may be nil for damaged files.
+func (f *File) Trailer() Dict {
+	return f.trailer
+}
+
+// Object returns the object with the given number or nil.
+func (f *File) Object(num int) any {
+	return f.objects[num]
+}
+
+// Resolve follows indirect references. Missing objects resolve to nil.
+func (f *File) Resolve(v any) any {
+	for i := 0; i < 32; i++ {
+		r, ok := v.(Ref)
+		if !ok {
+			return v
+		}
+		v = f.objects[r.Num]
+	}
+	return nil
+}
+
+// Catalog returns the document catalog.
+func (f *File) Catalog() (Dict, error) {
+	if f.trailer != nil {
+		if d, ok := f.Resolve(f.trailer["Root"]).(Dict); ok {
+			return d, nil
+		}
+	}
+	for _, obj := range f.objects {
+		if d, ok := obj.(Dict); ok && d["Type"] == Name("Catalog") {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no catalog", ErrFormat)
+}
+
+// Decode returns the data of the stream with its filters applied.
+// FlateDecode and ASCIIHexDecode are supported, without predictors. Data
+// that decodes to more than 64 MiB is rejected.
+func (s Stream) Decode() ([]byte, error) {
+	return s.decode(maxDecoded)
+}
+
+// decode is Decode with a limit for the size of the decoded data.
+func (s Stream) decode(limit int) ([]byte, error) {
+	var filters []any
+	switch f := s.Dict["Filter"].(type) {
+	case nil:
+	case Name:
+		filters = []any{f}
+	case Array:
+		filters = f
+	default:
+		return nil, fmt.Errorf("%w: invalid filter %v", ErrFormat, f)
+	}
+	data := s.Raw
+	for _, filter := range filters {
+		switch filter {
+		case Name("FlateDecode"), Name("Fl"):
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			if data, err = io.ReadAll(io.LimitReader(zr, int64(limit)+1)); err != nil {
+				return nil, err
+			}
+			if len(data) > limit {
+				return nil, fmt.Errorf("%w: stream exceeds %d bytes", ErrFormat, limit)
+			}
+		case Name("ASCIIHexDecode"), Name("AHx"):
+			end := bytes.IndexByte(data, '>')
+			if end < 0 {
+				end = len(data)
+			}
+			data = decodeHex(data[:end])
+		default:
+			return nil, fmt.Errorf("pdfread: unsupported filter %v", filter)
+		}
+	}
+	return data, nil
+}
+
+// TextString decodes a PDF text string: UTF-16BE or UTF-8 with byte order
+// mark, otherwise PDFDocEncoding, which is treated as Latin-1.
+func TextString(s string) string {
+	b := []byte(s)
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		b = b[2:]
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		}
+		return string(utf16.Decode(u))
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return string(b[3:])
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// parser reads PDF objects from data starting at pos.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// skipSpace skips white space and comments.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case isSpace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// keyword consumes the keyword kw if it is next in the input.
+func (p *parser) keyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.data) || string(p.data[p.pos:end]) != kw {
+		return false
+	}
+	if end < len(p.data) && !isSpace(p.data[end]) && !isDelimiter(p.data[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// token returns the next regular token (number or keyword).
+func (p *parser) token() string {
+	start := p.pos
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// maxDepth limits the nesting of arrays and dictionaries.
+const maxDepth = 100
+
+// object parses the next direct object or reference.
+func (p *parser) object() (any, error) {
+	return p.objectDepth(0)
+}
+
+func (p *parser) objectDepth(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: nesting too deep", ErrFormat)
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrFormat)
+	}
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		p.pos++
+		return Name(decodeName(p.token())), nil
+	case c == '(':
+		return p.literalString()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		p.pos += 2
+		d := Dict{}
+		for {
+			p.skipSpace()
+			if p.pos+1 < len(p.data) && p.data[p.pos] == '>' && p.data[p.pos+1] == '>' {
+				p.pos += 2
+				return d, nil
+			}
+			key, err := p.objectDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(Name)
+			if !ok {
+				return nil, fmt.Errorf("%w: dictionary key %v is not a name", ErrFormat, key)
+			}
+			val, err := p.objectDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			d[name] = val
+		}
+	case c == '<':
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated hex string", ErrFormat)
+		}
+		s := decodeHex(p.data[p.pos+1 : p.pos+end])
+		p.pos += end + 1
+		return string(s), nil
+	case c == '[':
+		p.pos++
+		var a Array
+		for {
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == ']' {
+				p.pos++
+				return a, nil
+			}
+			v, err := p.objectDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+	case isDelimiter(c):
+		return nil, fmt.Errorf("%w: unexpected %q", ErrFormat, c)
+	}
+	tok := p.token()
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(tok); err == nil {
+		// A reference is "num gen R".
+		save := p.pos
+		p.skipSpace()
+		if gen, err := strconv.Atoi(p.token()); err == nil {
+			p.skipSpace()
+			if p.keyword("R") {
+				return Ref{n, gen}, nil
+			}
+		}
+		p.pos = save
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected token %q", ErrFormat, tok)
+}
+
+// literalString parses a string in parentheses.
+func (p *parser) literalString() (string, error) {
+	p.pos++
+	var b []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(b), nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				break
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return "", fmt.Errorf("%w: unterminated string", ErrFormat)
+}
+
+// stream reads the data of a stream whose dictionary is d. The keyword
+// "stream" has been consumed.
+func (p *parser) stream(d Dict) (Stream, error) {
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+	// Use the length if it is direct and followed by endstream, otherwise
+	// search for the keyword.
+	if n, ok := d["Length"].(int); ok && n >= 0 && start+n <= len(p.data) {
+		q := &parser{data: p.data, pos: start + n}
+		q.skipSpace()
+		if q.keyword("endstream") {
+			p.pos = q.pos
+			return Stream{Dict: d, Raw: p.data[start : start+n]}, nil
+		}
+	}
+	// Prefer an endstream that is followed by endobj, since the data may
+	// contain the keyword.
+	end := -1
+	for i := start; ; {
+		j := bytes.Index(p.data[i:], []byte("endstream"))
+		if j < 0 {
+			break
+		}
+		if end < 0 {
+			end = i + j - start
+		}
+		q := &parser{data: p.data, pos: i + j + len("endstream")}
+		q.skipSpace()
+		if q.keyword("endobj") {
+			end = i + j - start
+			break
+		}
+		i += j + len("endstream")
+	}
+	if end < 0 {
+		return Stream{}, fmt.Errorf("%w: missing endstream", ErrFormat)
+	}
+	raw := p.data[start : start+end]
+	raw = bytes.TrimSuffix(raw, []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	p.pos = start + end + len("endstream")
+	return Stream{Dict: d, Raw: raw}, nil
+}
+
+// decodeName resolves the #xx escapes of a name.
+func decodeName(s string) string {
+	if !bytes.ContainsRune([]byte(s), '#') {
+		return s
+	}
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+3 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b = append(b, byte(v))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// decodeHex decodes hexadecimal digits, ignoring white space. A missing
+// last digit counts as 0.
+func decodeHex(h []byte) []byte {
+	var digits []byte
+	for _, c := range h {
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		v, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			break
+		}
+		out = append(out, byte(v))
+	}
+	return out
+}
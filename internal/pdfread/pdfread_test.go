@@ -0,0 +1,223 @@
+package pdfread
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func deflate(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseClassic(t *testing.T) {
+	data := []byte(`%PDF-1.7
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R /Title (a \(b\) c\101) /Hex <4869> /N#20a 5 /Arr [1 -2.5 true null] >>
+endobj
+2 0 obj
+<< /Length 3 0 R >>
+stream
+hello endstream world
+endstream
+endobj
+3 0 obj
+21
+endobj
+trailer
+<< /Root 1 0 R /Size 4 >>
+%%EOF
+`)
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat, err := f.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cat["Title"] != "a (b) cA" {
+		t.Errorf("literal string: got %q", cat["Title"])
+	}
+	if cat["Hex"] != "Hi" {
+		t.Errorf("hex string: got %q", cat["Hex"])
+	}
+	if cat["N a"] != 5 {
+		t.Errorf("escaped name: got %v", cat["N a"])
+	}
+	arr, ok := cat["Arr"].(Array)
+	if !ok || len(arr) != 4 || arr[0] != 1 || arr[1] != -2.5 || arr[2] != true || arr[3] != nil {
+		t.Errorf("array: got %#v", cat["Arr"])
+	}
+	s, ok := f.Resolve(cat["Pages"]).(Stream)
+	if !ok {
+		t.Fatalf("expected stream, got %T", f.Resolve(cat["Pages"]))
+	}
+	if string(s.Raw) != "hello endstream world" {
+		t.Errorf("stream data: got %q", s.Raw)
+	}
+}
+
+func TestParseObjectStream(t *testing.T) {
+	objects := "5 0 6 11 << /A 1 >> (packed)"
+	packed := deflate(t, objects)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%%PDF-1.7\n4 0 obj\n<< /Type /ObjStm /N 2 /First 9 /Filter /FlateDecode /Length %d >>\nstream\n", len(packed))
+	buf.Write(packed)
+	buf.WriteString("\nendstream\nendobj\n7 0 obj\n<< /Type /XRef /Root 5 0 R /Length 0 >>\nstream\n\nendstream\nendobj\n")
+	f, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Trailer()["Type"] != Name("XRef") {
+		t.Errorf("expected the XRef stream dictionary as trailer, got %v", f.Trailer())
+	}
+	cat, err := f.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cat["A"] != 1 {
+		t.Errorf("expected /A 1 in the compressed catalog, got %v", cat)
+	}
+	if f.Object(6) != "packed" {
+		t.Errorf("expected compressed string, got %v", f.Object(6))
+	}
+}
+
+// objectStreamPDF returns a PDF with an object stream that has the given
+// header, /First and objects.
+func objectStreamPDF(t *testing.T, header string, first int, objects string) []byte {
+	t.Helper()
+	packed := deflate(t, header+objects)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%%PDF-1.7\n4 0 obj\n<< /Type /ObjStm /N 1 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", first, len(packed))
+	buf.Write(packed)
+	buf.WriteString("\nendstream\nendobj\n")
+	return buf.Bytes()
+}
+
+func TestParseObjectStreamMalformed(t *testing.T) {
+	tests := []struct {
+		name, header string
+		first        int
+	}{
+		{"negative first", "5 0 ", -4},
+		{"negative offset", "5 -3 ", 5},
+		{"first beyond data", "5 0 ", 1000},
+		{"offset beyond data", "5 1000 ", 7},
+	}
+	for _, tt := range tests {
+		f, err := Parse(objectStreamPDF(t, tt.header, tt.first, "<< /A 1 >>"))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if f.Object(5) != nil {
+			t.Errorf("%s: expected no compressed object, got %v", tt.name, f.Object(5))
+		}
+	}
+}
+
+func TestStreamDecodeLimit(t *testing.T) {
+	s := Stream{Dict: Dict{"Filter": Name("FlateDecode")}, Raw: deflate(t, strings.Repeat("x", 100))}
+	if data, err := s.decode(100); err != nil || len(data) != 100 {
+		t.Errorf("expected 100 bytes, got %d, %v", len(data), err)
+	}
+	if _, err := s.decode(99); !errors.Is(err, ErrFormat) {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestDecodeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Plain", "Plain"},
+		{"N#20a", "N a"},
+		{"A#23", "A#"},
+		{"#41#42", "AB"},
+		{"A#2", "A#2"},
+		{"A#", "A#"},
+		{"A#zz", "A#zz"},
+	}
+	for _, tt := range tests {
+		if got := decodeName(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog /N#20a [1 (x) <41>] >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n"))
+	f.Add([]byte("%PDF-1.7\n4 0 obj\n<< /Type /ObjStm /N 1 /First -4 /Length 4 >>\nstream\n5 0 \nendstream\nendobj\n"))
+	f.Add([]byte("%PDF-1.7\n2 0 obj\n<< /Filter /AHx /Length 5 >>\nstream\n4142>\nendstream\nendobj\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pdf, err := Parse(data)
+		if err != nil {
+			return
+		}
+		pdf.Catalog()
+		for num := range pdf.objects {
+			if s, ok := pdf.Resolve(Ref{Num: num}).(Stream); ok {
+				s.Decode()
+			}
+		}
+	})
+}
+
+func TestStreamDecode(t *testing.T) {
+	s := Stream{Dict: Dict{"Filter": Array{Name("AHx"), Name("FlateDecode")}}}
+	var hex bytes.Buffer
+	for _, c := range deflate(t, "decoded") {
+		fmt.Fprintf(&hex, "%02x ", c)
+	}
+	hex.WriteString(">")
+	s.Raw = hex.Bytes()
+	data, err := s.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "decoded" {
+		t.Errorf("got %q", data)
+	}
+	s = Stream{Dict: Dict{"Filter": Name("LZWDecode")}}
+	if _, err := s.Decode(); err == nil {
+		t.Error("expected error for unsupported filter")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"not a pdf",
+		"%PDF-1.7\n",
+		"%PDF-1.7\n1 0 obj\n<< >>\nendobj\ntrailer\n<< /Root 1 0 R /Encrypt 2 0 R >>\n",
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt)); !errors.Is(err, ErrFormat) {
+			t.Errorf("%q: expected ErrFormat, got %v", tt, err)
+		}
+	}
+}
+
+func TestTextString(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"\xfe\xff\x00R\x00\xe9", "Ré"},
+		{"\xef\xbb\xbfRé", "Ré"},
+		{"R\xe9", "Ré"},
+	}
+	for _, tt := range tests {
+		if got := TextString(tt.in); got != tt.want {
+			t.Errorf("TextString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
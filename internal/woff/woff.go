@@ -0,0 +1,175 @@
+// Package woff converts WOFF and WOFF2 web fonts to plain OpenType/TrueType
+// (sfnt) data.
+package woff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Font signatures.
+const (
+	sigWOFF  = 0x774F4646 // "wOFF"
+	sigWOFF2 = 0x774F4632 // "wOF2"
+	sigTTCF  = 0x74746366 // "ttcf"
+)
+
+// ErrFormat is returned for data that is not a valid WOFF or WOFF2 font.
+var ErrFormat = errors.New("woff: invalid font data")
+
+// IsWOFF reports whether data starts with a WOFF or WOFF2 signature.
+func IsWOFF(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	sig := binary.BigEndian.Uint32(data)
+	return sig == sigWOFF || sig == sigWOFF2
+}
+
+// Decode converts a WOFF or WOFF2 font to sfnt data. Other data is returned
+// unchanged.
+func Decode(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return data, nil
+	}
+	switch binary.BigEndian.Uint32(data) {
+	case sigWOFF:
+		return decodeWOFF(data)
+	case sigWOFF2:
+		return decodeWOFF2(data)
+	}
+	return data, nil
+}
+
+// decodeWOFF converts a WOFF 1.0 font.
+func decodeWOFF(data []byte) ([]byte, error) {
+	const headerSize, entrySize = 44, 20
+	if len(data) < headerSize {
+		return nil, ErrFormat
+	}
+	flavor := binary.BigEndian.Uint32(data[4:])
+	numTables := int(binary.BigEndian.Uint16(data[12:]))
+	if len(data) < headerSize+numTables*entrySize {
+		return nil, ErrFormat
+	}
+	total := 0
+	for i := 0; i < numTables; i++ {
+		total += int(binary.BigEndian.Uint32(data[headerSize+i*entrySize+12:]))
+		if total > maxDecompressed {
+			return nil, fmt.Errorf("%w: decompressed size exceeds %d bytes", ErrFormat, maxDecompressed)
+		}
+	}
+	tables := make([]table, 0, numTables)
+	for i := 0; i < numTables; i++ {
+		e := data[headerSize+i*entrySize:]
+		tag := binary.BigEndian.Uint32(e)
+		offset := int(binary.BigEndian.Uint32(e[4:]))
+		compLength := int(binary.BigEndian.Uint32(e[8:]))
+		origLength := int(binary.BigEndian.Uint32(e[12:]))
+		if offset < 0 || compLength < 0 || offset+compLength > len(data) || compLength > origLength {
+			return nil, fmt.Errorf("%w: table %s out of bounds", ErrFormat, tagString(tag))
+		}
+		raw := data[offset : offset+compLength]
+		if compLength < origLength {
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("woff: table %s: %w", tagString(tag), err)
+			}
+			raw, err = io.ReadAll(io.LimitReader(zr, int64(origLength)+1))
+			if err != nil {
+				return nil, fmt.Errorf("woff: table %s: %w", tagString(tag), err)
+			}
+		}
+		if len(raw) != origLength {
+			return nil, fmt.Errorf("%w: table %s has wrong length", ErrFormat, tagString(tag))
+		}
+		tables = append(tables, table{tag: tag, data: raw})
+	}
+	return buildSFNT(flavor, tables), nil
+}
+
+// table is a table of an sfnt font.
+type table struct {
+	tag  uint32
+	data []byte
+}
+
+// buildSFNT assembles an sfnt font from its tables. Table checksums and the
+// checksum adjustment of the head table are recalculated.
+func buildSFNT(flavor uint32, tables []table) []byte {
+	sort.Slice(tables, func(i, j int) bool { return tables[i].tag < tables[j].tag })
+	n := len(tables)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	size := 12 + 16*n
+	for _, t := range tables {
+		size += pad4(len(t.data))
+	}
+	out := make([]byte, size)
+	binary.BigEndian.PutUint32(out, flavor)
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(n*16-searchRange))
+
+	offset := 12 + 16*n
+	headOffset := -1
+	for i, t := range tables {
+		copy(out[offset:], t.data)
+		if t.tag == tagHead && len(t.data) >= 12 {
+			headOffset = offset
+			// checkSumAdjustment must be zero while checksums are calculated
+			binary.BigEndian.PutUint32(out[offset+8:], 0)
+		}
+		rec := out[12+16*i:]
+		binary.BigEndian.PutUint32(rec, t.tag)
+		binary.BigEndian.PutUint32(rec[4:], checksum(out[offset:offset+pad4(len(t.data))]))
+		binary.BigEndian.PutUint32(rec[8:], uint32(offset))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(t.data)))
+		offset += pad4(len(t.data))
+	}
+	if headOffset >= 0 {
+		binary.BigEndian.PutUint32(out[headOffset+8:], 0xB1B0AFBA-checksum(out))
+	}
+	return out
+}
+
+// checksum is the OpenType table checksum of data, whose length is a
+// multiple of four.
+func checksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i+4 <= len(data); i += 4 {
+		sum += binary.BigEndian.Uint32(data[i:])
+	}
+	return sum
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+func tagString(tag uint32) string {
+	return string([]byte{byte(tag >> 24), byte(tag >> 16), byte(tag >> 8), byte(tag)})
+}
+
+func makeTag(s string) uint32 {
+	return binary.BigEndian.Uint32([]byte(s))
+}
+
+var (
+	tagHead = makeTag("head")
+	tagGlyf = makeTag("glyf")
+	tagLoca = makeTag("loca")
+	tagHmtx = makeTag("hmtx")
+	tagHhea = makeTag("hhea")
+	tagMaxp = makeTag("maxp")
+)
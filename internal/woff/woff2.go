@@ -0,0 +1,596 @@
+package woff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// knownTags are the table tags that WOFF2 encodes as a 6 bit index.
+var knownTags = [63]string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post",
+	"cvt ", "fpgm", "glyf", "loca", "prep", "CFF ", "VORG", "EBDT",
+	"EBLC", "gasp", "hdmx", "kern", "LTSH", "PCLT", "VDMX", "vhea",
+	"vmtx", "BASE", "GDEF", "GPOS", "GSUB", "EBSC", "JSTF", "MATH",
+	"CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar",
+	"bdat", "bloc", "bsln", "cvar", "fdsc", "feat", "fmtx", "fvar",
+	"gvar", "hsty", "just", "lcar", "mort", "morx", "opbd", "prop",
+	"trak", "Zapf", "Silf", "Glat", "Gloc", "Feat", "Sill",
+}
+
+// maxDecompressed limits the size of the decompressed font data.
+const maxDecompressed = 64 << 20
+
+// woff2Entry is an entry of the WOFF2 table directory.
+type woff2Entry struct {
+	tag         uint32
+	transformed bool
+	origLength  int
+	length      int // length in the decompressed stream
+	data        []byte
+}
+
+// decodeWOFF2 converts a WOFF 2.0 font. Font collections are not supported.
+func decodeWOFF2(data []byte) ([]byte, error) {
+	const headerSize = 48
+	if len(data) < headerSize {
+		return nil, ErrFormat
+	}
+	flavor := binary.BigEndian.Uint32(data[4:])
+	if flavor == sigTTCF {
+		return nil, errors.New("woff2: font collections are not supported")
+	}
+	numTables := int(binary.BigEndian.Uint16(data[12:]))
+	totalCompressed := int(binary.BigEndian.Uint32(data[20:]))
+
+	r := &reader{data: data, pos: headerSize}
+	entries := make([]*woff2Entry, numTables)
+	for i := range entries {
+		flags, err := r.u8()
+		if err != nil {
+			return nil, err
+		}
+		e := &woff2Entry{}
+		if idx := flags & 0x3f; idx == 63 {
+			if e.tag, err = r.u32(); err != nil {
+				return nil, err
+			}
+		} else {
+			e.tag = makeTag(knownTags[idx])
+		}
+		version := flags >> 6
+		if e.tag == tagGlyf || e.tag == tagLoca {
+			e.transformed = version == 0
+		} else {
+			e.transformed = version != 0
+		}
+		if e.origLength, err = r.base128(); err != nil {
+			return nil, err
+		}
+		e.length = e.origLength
+		if e.transformed {
+			if e.length, err = r.base128(); err != nil {
+				return nil, err
+			}
+		}
+		entries[i] = e
+	}
+	if r.pos+totalCompressed > len(data) {
+		return nil, fmt.Errorf("%w: compressed data out of bounds", ErrFormat)
+	}
+	stream, err := io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(data[r.pos:r.pos+totalCompressed])), maxDecompressed))
+	if err != nil {
+		return nil, fmt.Errorf("woff2: %w", err)
+	}
+	offset := 0
+	byTag := make(map[uint32]*woff2Entry)
+	for _, e := range entries {
+		if offset+e.length > len(stream) {
+			return nil, fmt.Errorf("%w: table %s out of bounds", ErrFormat, tagString(e.tag))
+		}
+		e.data = stream[offset : offset+e.length]
+		offset += e.length
+		byTag[e.tag] = e
+	}
+
+	var xMins []int16
+	if glyf := byTag[tagGlyf]; glyf != nil && glyf.transformed {
+		loca := byTag[tagLoca]
+		if loca == nil {
+			return nil, fmt.Errorf("%w: transformed glyf without loca", ErrFormat)
+		}
+		g, l, mins, err := reconstructGlyf(glyf.data)
+		if err != nil {
+			return nil, err
+		}
+		glyf.data, loca.data, xMins = g, l, mins
+	}
+	if hmtx := byTag[tagHmtx]; hmtx != nil && hmtx.transformed {
+		if hmtx.data, err = reconstructHmtx(hmtx.data, byTag, xMins); err != nil {
+			return nil, err
+		}
+	}
+	tables := make([]table, 0, len(entries))
+	for _, e := range entries {
+		if e.transformed && e.tag != tagGlyf && e.tag != tagLoca && e.tag != tagHmtx {
+			return nil, fmt.Errorf("woff2: unknown transform of table %s", tagString(e.tag))
+		}
+		tables = append(tables, table{tag: e.tag, data: e.data})
+	}
+	return buildSFNT(flavor, tables), nil
+}
+
+// reconstructGlyf reverses the WOFF2 glyf transform. It returns the glyf and
+// loca tables and the xMin of every glyph (for the hmtx transform).
+func reconstructGlyf(data []byte) (glyf, loca []byte, xMins []int16, err error) {
+	hdr := &reader{data: data}
+	var sizes [7]int
+	if _, err = hdr.u16(); err != nil { // reserved
+		return
+	}
+	optionFlags, _ := hdr.u16()
+	numGlyphs16, _ := hdr.u16()
+	indexFormat, err := hdr.u16()
+	if err != nil {
+		return
+	}
+	numGlyphs := int(numGlyphs16)
+	for i := range sizes {
+		var v uint32
+		if v, err = hdr.u32(); err != nil {
+			return
+		}
+		sizes[i] = int(v)
+	}
+	// streams in order: nContour, nPoints, flag, glyph, composite, bbox, instruction
+	var streams [7]*reader
+	pos := hdr.pos
+	for i, size := range sizes {
+		if size < 0 || pos+size > len(data) {
+			return nil, nil, nil, fmt.Errorf("%w: glyf stream out of bounds", ErrFormat)
+		}
+		streams[i] = &reader{data: data[pos : pos+size]}
+		pos += size
+	}
+	nContourS, nPointsS, flagS, glyphS, compositeS, bboxS, instrS := streams[0], streams[1], streams[2], streams[3], streams[4], streams[5], streams[6]
+	bitmapLen := 4 * ((numGlyphs + 31) / 32)
+	bboxBitmap, err := bboxS.bytes(bitmapLen)
+	if err != nil {
+		return
+	}
+	var overlapBitmap []byte
+	if optionFlags&1 != 0 {
+		n := (numGlyphs + 7) / 8
+		if pos+n > len(data) {
+			return nil, nil, nil, fmt.Errorf("%w: overlap bitmap out of bounds", ErrFormat)
+		}
+		overlapBitmap = data[pos : pos+n]
+	}
+
+	var out bytes.Buffer
+	offsets := make([]int, numGlyphs+1)
+	xMins = make([]int16, numGlyphs)
+	for i := 0; i < numGlyphs; i++ {
+		offsets[i] = out.Len()
+		hasBBox := bboxBitmap[i>>3]&(0x80>>(i&7)) != 0
+		nContours, err := nContourS.i16()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch {
+		case nContours == 0:
+			if hasBBox {
+				return nil, nil, nil, fmt.Errorf("%w: empty glyph %d with bounding box", ErrFormat, i)
+			}
+		case nContours > 0:
+			overlap := overlapBitmap != nil && overlapBitmap[i>>3]&(0x80>>(i&7)) != 0
+			xMin, err := writeSimpleGlyph(&out, int(nContours), hasBBox, overlap, nPointsS, flagS, glyphS, bboxS, instrS)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			xMins[i] = xMin
+		case nContours == -1:
+			if !hasBBox {
+				return nil, nil, nil, fmt.Errorf("%w: composite glyph %d without bounding box", ErrFormat, i)
+			}
+			xMin, err := writeCompositeGlyph(&out, compositeS, glyphS, bboxS, instrS)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			xMins[i] = xMin
+		default:
+			return nil, nil, nil, fmt.Errorf("%w: glyph %d has %d contours", ErrFormat, i, nContours)
+		}
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	offsets[numGlyphs] = out.Len()
+
+	if indexFormat == 0 {
+		loca = make([]byte, 2*(numGlyphs+1))
+		for i, o := range offsets {
+			binary.BigEndian.PutUint16(loca[2*i:], uint16(o/2))
+		}
+	} else {
+		loca = make([]byte, 4*(numGlyphs+1))
+		for i, o := range offsets {
+			binary.BigEndian.PutUint32(loca[4*i:], uint32(o))
+		}
+	}
+	return out.Bytes(), loca, xMins, nil
+}
+
+// writeSimpleGlyph reconstructs a glyph with contours and returns its xMin.
+func writeSimpleGlyph(out *bytes.Buffer, nContours int, hasBBox, overlap bool, nPointsS, flagS, glyphS, bboxS, instrS *reader) (int16, error) {
+	endPts := make([]uint16, nContours)
+	total := 0
+	for c := range endPts {
+		n, err := nPointsS.u255()
+		if err != nil {
+			return 0, err
+		}
+		total += int(n)
+		if total > 0xffff {
+			return 0, fmt.Errorf("%w: too many points", ErrFormat)
+		}
+		endPts[c] = uint16(total - 1)
+	}
+	xs := make([]int, total)
+	ys := make([]int, total)
+	onCurve := make([]bool, total)
+	x, y := 0, 0
+	for p := 0; p < total; p++ {
+		flag, err := flagS.u8()
+		if err != nil {
+			return 0, err
+		}
+		dx, dy, err := glyphS.triplet(flag)
+		if err != nil {
+			return 0, err
+		}
+		x += dx
+		y += dy
+		xs[p], ys[p], onCurve[p] = x, y, flag&0x80 == 0
+	}
+	instrLen, err := glyphS.u255()
+	if err != nil {
+		return 0, err
+	}
+	instructions, err := instrS.bytes(int(instrLen))
+	if err != nil {
+		return 0, err
+	}
+	var bbox [4]int16
+	if hasBBox {
+		for i := range bbox {
+			if bbox[i], err = bboxS.i16(); err != nil {
+				return 0, err
+			}
+		}
+	} else if total > 0 {
+		minX, minY, maxX, maxY := xs[0], ys[0], xs[0], ys[0]
+		for p := 1; p < total; p++ {
+			minX, maxX = min(minX, xs[p]), max(maxX, xs[p])
+			minY, maxY = min(minY, ys[p]), max(maxY, ys[p])
+		}
+		bbox = [4]int16{int16(minX), int16(minY), int16(maxX), int16(maxY)}
+	}
+
+	writeI16(out, int16(nContours))
+	for _, v := range bbox {
+		writeI16(out, v)
+	}
+	for _, e := range endPts {
+		writeU16(out, e)
+	}
+	writeU16(out, instrLen)
+	out.Write(instructions)
+
+	// flags, then x and y coordinates as deltas
+	var xBuf, yBuf bytes.Buffer
+	prevX, prevY := 0, 0
+	for p := 0; p < total; p++ {
+		var f byte
+		if onCurve[p] {
+			f |= 0x01
+		}
+		if p == 0 && overlap {
+			f |= 0x40
+		}
+		f |= encodeDelta(&xBuf, xs[p]-prevX, 0x02, 0x10)
+		f |= encodeDelta(&yBuf, ys[p]-prevY, 0x04, 0x20)
+		prevX, prevY = xs[p], ys[p]
+		out.WriteByte(f)
+	}
+	out.Write(xBuf.Bytes())
+	out.Write(yBuf.Bytes())
+	return bbox[0], nil
+}
+
+// encodeDelta writes a coordinate delta in the shortest glyf form and
+// returns the flag bits for it.
+func encodeDelta(buf *bytes.Buffer, d int, short, same byte) byte {
+	switch {
+	case d == 0:
+		return same
+	case d > 0 && d < 256:
+		buf.WriteByte(byte(d))
+		return short | same
+	case d < 0 && d > -256:
+		buf.WriteByte(byte(-d))
+		return short
+	}
+	writeI16(buf, int16(d))
+	return 0
+}
+
+// Flags of composite glyph components.
+const (
+	argsAreWords     = 0x0001
+	haveScale        = 0x0008
+	moreComponents   = 0x0020
+	haveXYScale      = 0x0040
+	haveTwoByTwo     = 0x0080
+	haveInstructions = 0x0100
+)
+
+// writeCompositeGlyph reconstructs a composite glyph and returns its xMin.
+func writeCompositeGlyph(out *bytes.Buffer, compositeS, glyphS, bboxS, instrS *reader) (int16, error) {
+	start := compositeS.pos
+	instr := false
+	for {
+		flags, err := compositeS.u16()
+		if err != nil {
+			return 0, err
+		}
+		size := 2 // glyph index
+		if flags&argsAreWords != 0 {
+			size += 4
+		} else {
+			size += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			size += 2
+		case flags&haveXYScale != 0:
+			size += 4
+		case flags&haveTwoByTwo != 0:
+			size += 8
+		}
+		if _, err := compositeS.bytes(size); err != nil {
+			return 0, err
+		}
+		if flags&haveInstructions != 0 {
+			instr = true
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	components := compositeS.data[start:compositeS.pos]
+	var bbox [4]int16
+	for i := range bbox {
+		v, err := bboxS.i16()
+		if err != nil {
+			return 0, err
+		}
+		bbox[i] = v
+	}
+	writeI16(out, -1)
+	for _, v := range bbox {
+		writeI16(out, v)
+	}
+	out.Write(components)
+	if instr {
+		n, err := glyphS.u255()
+		if err != nil {
+			return 0, err
+		}
+		instructions, err := instrS.bytes(int(n))
+		if err != nil {
+			return 0, err
+		}
+		writeU16(out, n)
+		out.Write(instructions)
+	}
+	return bbox[0], nil
+}
+
+// reconstructHmtx reverses the WOFF2 hmtx transform. Left side bearings
+// that were dropped are taken from the xMin of the glyphs.
+func reconstructHmtx(data []byte, byTag map[uint32]*woff2Entry, xMins []int16) ([]byte, error) {
+	hhea, maxp := byTag[tagHhea], byTag[tagMaxp]
+	if hhea == nil || len(hhea.data) < 36 || maxp == nil || len(maxp.data) < 6 {
+		return nil, fmt.Errorf("%w: hmtx transform without hhea or maxp", ErrFormat)
+	}
+	numHMetrics := int(binary.BigEndian.Uint16(hhea.data[34:]))
+	numGlyphs := int(binary.BigEndian.Uint16(maxp.data[4:]))
+	if numHMetrics < 1 || numHMetrics > numGlyphs {
+		return nil, fmt.Errorf("%w: invalid number of horizontal metrics", ErrFormat)
+	}
+	r := &reader{data: data}
+	flags, err := r.u8()
+	if err != nil {
+		return nil, err
+	}
+	if (flags&1 != 0 || flags&2 != 0) && len(xMins) < numGlyphs {
+		return nil, fmt.Errorf("%w: hmtx transform needs a transformed glyf table", ErrFormat)
+	}
+	advances := make([]uint16, numHMetrics)
+	for i := range advances {
+		if advances[i], err = r.u16(); err != nil {
+			return nil, err
+		}
+	}
+	lsbs := make([]int16, numGlyphs)
+	for i := 0; i < numGlyphs; i++ {
+		proportional := i < numHMetrics
+		if proportional && flags&1 != 0 || !proportional && flags&2 != 0 {
+			lsbs[i] = xMins[i]
+			continue
+		}
+		if lsbs[i], err = r.i16(); err != nil {
+			return nil, err
+		}
+	}
+	var out bytes.Buffer
+	for i := 0; i < numGlyphs; i++ {
+		if i < numHMetrics {
+			writeU16(&out, advances[i])
+		}
+		writeI16(&out, lsbs[i])
+	}
+	return out.Bytes(), nil
+}
+
+func writeU16(b *bytes.Buffer, v uint16) {
+	b.WriteByte(byte(v >> 8))
+	b.WriteByte(byte(v))
+}
+
+func writeI16(b *bytes.Buffer, v int16) {
+	writeU16(b, uint16(v))
+}
+
+// reader reads big endian values from a byte slice.
+type reader struct {
+	data []byte
+	pos  int
+}
+
+var errShort = fmt.Errorf("%w: unexpected end of data", ErrFormat)
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) u8() (byte, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *reader) u16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (r *reader) i16() (int16, error) {
+	v, err := r.u16()
+	return int16(v), err
+}
+
+func (r *reader) u32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// base128 reads a UIntBase128 value.
+func (r *reader) base128() (int, error) {
+	var v uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.u8()
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 && b == 0x80 {
+			return 0, fmt.Errorf("%w: UIntBase128 with leading zero", ErrFormat)
+		}
+		if v&0xFE000000 != 0 {
+			return 0, fmt.Errorf("%w: UIntBase128 overflow", ErrFormat)
+		}
+		v = v<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: UIntBase128 too long", ErrFormat)
+}
+
+// u255 reads a 255UInt16 value.
+func (r *reader) u255() (uint16, error) {
+	code, err := r.u8()
+	if err != nil {
+		return 0, err
+	}
+	switch code {
+	case 253:
+		return r.u16()
+	case 254:
+		b, err := r.u8()
+		return uint16(b) + 253*2, err
+	case 255:
+		b, err := r.u8()
+		return uint16(b) + 253, err
+	}
+	return uint16(code), nil
+}
+
+// triplet decodes the coordinate delta of a point whose flag byte is flag.
+func (r *reader) triplet(flag byte) (dx, dy int, err error) {
+	f := int(flag & 0x7f)
+	withSign := func(flag, v int) int {
+		if flag&1 != 0 {
+			return v
+		}
+		return -v
+	}
+	var n int
+	switch {
+	case f < 10:
+		n = 1
+	case f < 20:
+		n = 1
+	case f < 84:
+		n = 1
+	case f < 120:
+		n = 2
+	case f < 124:
+		n = 3
+	default:
+		n = 4
+	}
+	b, err := r.bytes(n)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case f < 10:
+		dy = withSign(f, (f&14)<<7+int(b[0]))
+	case f < 20:
+		dx = withSign(f, ((f-10)&14)<<7+int(b[0]))
+	case f < 84:
+		b0 := f - 20
+		dx = withSign(f, 1+(b0&0x30)+int(b[0]>>4))
+		dy = withSign(f>>1, 1+(b0&0x0c)<<2+int(b[0]&0x0f))
+	case f < 120:
+		b0 := f - 84
+		dx = withSign(f, 1+(b0/12)<<8+int(b[0]))
+		dy = withSign(f>>1, 1+((b0%12)>>2)<<8+int(b[1]))
+	case f < 124:
+		dx = withSign(f, int(b[0])<<4+int(b[1]>>4))
+		dy = withSign(f>>1, int(b[1]&0x0f)<<8+int(b[2]))
+	default:
+		dx = withSign(f, int(b[0])<<8+int(b[1]))
+		dy = withSign(f>>1, int(b[2])<<8+int(b[3]))
+	}
+	return dx, dy, nil
+}
@@ -0,0 +1,268 @@
+package woff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func u16(v ...uint16) []byte {
+	b := make([]byte, 2*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint16(b[2*i:], x)
+	}
+	return b
+}
+
+func u32(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint32(b[4*i:], x)
+	}
+	return b
+}
+
+// sfntTables parses the table directory of an sfnt font.
+func sfntTables(t *testing.T, font []byte) map[string][]byte {
+	t.Helper()
+	n := int(binary.BigEndian.Uint16(font[4:]))
+	tables := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		rec := font[12+16*i:]
+		offset := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		tables[tagString(binary.BigEndian.Uint32(rec))] = font[offset : offset+length]
+	}
+	return tables
+}
+
+func testHead() []byte {
+	head := make([]byte, 54)
+	copy(head, u32(0x00010000, 0x00010000, 0x12345678, 0x5F0F3CF5))
+	return head
+}
+
+func TestDecodePlain(t *testing.T) {
+	data := []byte{0, 1, 0, 0, 1, 2, 3}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("non-WOFF data must be returned unchanged")
+	}
+	if IsWOFF(data) {
+		t.Error("plain font detected as WOFF")
+	}
+}
+
+func TestDecodeWOFF(t *testing.T) {
+	tables := []table{
+		{tag: makeTag("cmap"), data: bytes.Repeat([]byte("cmap data "), 20)},
+		{tag: tagHead, data: testHead()},
+		{tag: makeTag("name"), data: []byte("abc")},
+	}
+	want := buildSFNT(0x00010000, append([]table(nil), tables...))
+
+	var dir, body bytes.Buffer
+	offset := 44 + 20*len(tables)
+	for _, tb := range tables {
+		stored := tb.data
+		if tb.tag == makeTag("cmap") {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write(tb.data)
+			zw.Close()
+			stored = z.Bytes()
+		}
+		dir.Write(u32(tb.tag, uint32(offset+body.Len()), uint32(len(stored)), uint32(len(tb.data)), 0))
+		body.Write(stored)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	var woff bytes.Buffer
+	woff.Write(u32(sigWOFF, 0x00010000, uint32(offset+body.Len())))
+	woff.Write(u16(uint16(len(tables)), 0))
+	woff.Write(u32(uint32(len(want))))
+	woff.Write(u16(1, 0))
+	woff.Write(u32(0, 0, 0, 0, 0))
+	woff.Write(dir.Bytes())
+	woff.Write(body.Bytes())
+
+	if !IsWOFF(woff.Bytes()) {
+		t.Fatal("WOFF signature not detected")
+	}
+	got, err := Decode(woff.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("decoded WOFF differs from original sfnt")
+	}
+	if checksum(got) != 0xB1B0AFBA {
+		t.Errorf("wrong font checksum %08x", checksum(got))
+	}
+}
+
+func TestDecodeWOFFTruncated(t *testing.T) {
+	if _, err := Decode(u32(sigWOFF, 0x00010000)); !errors.Is(err, ErrFormat) {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestDecodeWOFFTooLarge(t *testing.T) {
+	// Two tables of 40 MiB each exceed maxDecompressed together.
+	data := make([]byte, 44+2*20)
+	copy(data, u32(sigWOFF, 0x00010000))
+	binary.BigEndian.PutUint16(data[12:], 2)
+	for i := 0; i < 2; i++ {
+		e := data[44+i*20:]
+		binary.BigEndian.PutUint32(e, uint32(tagHead+uint32(i)))
+		binary.BigEndian.PutUint32(e[4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(e[12:], 40<<20)
+	}
+	if _, err := Decode(data); !errors.Is(err, ErrFormat) || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected a size error, got %v", err)
+	}
+}
+
+func base128(v int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v & 0x7f)}, b...)
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := 0; i < len(b)-1; i++ {
+		b[i] |= 0x80
+	}
+	return b
+}
+
+// tagIndex returns the WOFF2 known tag index of tag.
+func tagIndex(tag string) byte {
+	for i, k := range knownTags {
+		if k == tag {
+			return byte(i)
+		}
+	}
+	panic(tag)
+}
+
+func TestDecodeWOFF2(t *testing.T) {
+	// Two glyphs: an empty one and a triangle (0,0) (100,0) (50,100).
+	var glyf bytes.Buffer
+	nContour := u16(0, 1)
+	nPoints := []byte{3}
+	flags := []byte{1, 11, 126}                // see triplet encoding
+	glyphs := []byte{0, 100, 0, 50, 0, 100, 0} // triplet data, then instruction length 0
+	bbox := make([]byte, 4)                    // bitmap for two glyphs, no explicit boxes
+	glyf.Write(u16(0, 0, 2, 0))                // reserved, options, numGlyphs, indexFormat
+	glyf.Write(u32(uint32(len(nContour)), uint32(len(nPoints)), uint32(len(flags)), uint32(len(glyphs)), 0, uint32(len(bbox)), 0))
+	glyf.Write(nContour)
+	glyf.Write(nPoints)
+	glyf.Write(flags)
+	glyf.Write(glyphs)
+	glyf.Write(bbox)
+
+	hhea := make([]byte, 36)
+	binary.BigEndian.PutUint16(hhea[34:], 2) // numberOfHMetrics
+	maxp := u32(0x00005000)
+	maxp = append(maxp, u16(2)...)
+	hmtx := append([]byte{1}, u16(500, 600)...) // lsb from xMin
+
+	type entry struct {
+		tag         string
+		flags       byte
+		origLength  int
+		data        []byte
+		transformed bool
+	}
+	entries := []entry{
+		{tag: "head", data: testHead(), origLength: 54},
+		{tag: "hhea", data: hhea, origLength: 36},
+		{tag: "maxp", data: maxp, origLength: 6},
+		{tag: "hmtx", data: hmtx, origLength: 8, flags: 1 << 6, transformed: true},
+		{tag: "glyf", data: glyf.Bytes(), origLength: 32, transformed: true},
+		{tag: "loca", data: nil, origLength: 6, transformed: true},
+	}
+	var dir, stream bytes.Buffer
+	for _, e := range entries {
+		dir.WriteByte(e.flags | tagIndex(e.tag))
+		dir.Write(base128(e.origLength))
+		if e.transformed {
+			dir.Write(base128(len(e.data)))
+		}
+		stream.Write(e.data)
+	}
+	var compressed bytes.Buffer
+	bw := brotli.NewWriter(&compressed)
+	bw.Write(stream.Bytes())
+	bw.Close()
+
+	var woff2 bytes.Buffer
+	woff2.Write(u32(sigWOFF2, 0x00010000, 0))
+	woff2.Write(u16(uint16(len(entries)), 0))
+	woff2.Write(u32(0, uint32(compressed.Len())))
+	woff2.Write(u16(1, 0))
+	woff2.Write(u32(0, 0, 0, 0, 0))
+	woff2.Write(dir.Bytes())
+	woff2.Write(compressed.Bytes())
+
+	font, err := Decode(woff2.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := sfntTables(t, font)
+	loca := tables["loca"]
+	if !bytes.Equal(loca, u16(0, 0, 10)) { // short offsets, glyph length 20
+		t.Errorf("unexpected loca %v", loca)
+	}
+	g := tables["glyf"]
+	wantGlyph := []byte{}
+	wantGlyph = append(wantGlyph, u16(1, 0, 0, 100, 100)...) // contours, bbox
+	wantGlyph = append(wantGlyph, u16(2, 0)...)              // endPts, instruction length
+	wantGlyph = append(wantGlyph, 0x01|0x10|0x20, 0x01|0x02|0x10|0x20, 0x01|0x02|0x04|0x20)
+	wantGlyph = append(wantGlyph, 100, 50, 100) // x deltas: 100, -50; y delta: 100
+	if !bytes.Equal(g[:len(wantGlyph)], wantGlyph) {
+		t.Errorf("unexpected glyph\n got %v\nwant %v", g[:len(wantGlyph)], wantGlyph)
+	}
+	if !bytes.Equal(tables["hmtx"], u16(500, 0, 600, 0)) {
+		t.Errorf("unexpected hmtx %v", tables["hmtx"])
+	}
+	if !bytes.Equal(tables["hhea"], hhea) {
+		t.Error("untransformed table changed")
+	}
+}
+
+func TestTriplet(t *testing.T) {
+	tests := []struct {
+		flag   byte
+		data   []byte
+		dx, dy int
+	}{
+		{1, []byte{0}, 0, 0},
+		{0, []byte{5}, 0, -5},
+		{11, []byte{100}, 100, 0},
+		{126, []byte{0, 50, 0, 100}, -50, 100},
+		{127, []byte{1, 0, 2, 0}, 256, 512},
+	}
+	for _, tt := range tests {
+		r := &reader{data: tt.data}
+		dx, dy, err := r.triplet(tt.flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dx != tt.dx || dy != tt.dy {
+			t.Errorf("flag %d: expected (%d, %d), got (%d, %d)", tt.flag, tt.dx, tt.dy, dx, dy)
+		}
+	}
+}